@@ -0,0 +1,71 @@
+package lfucache
+
+// The batch operations are equivalent to calling the corresponding single
+// item operation for each key in turn, and do the same work per item, so
+// eviction notifications and observer events are sent in the same order.
+// They are a convenience: callers serializing access to the cache can take
+// their lock once per batch rather than once per key.
+
+// InsertMany inserts each key with the value at the same position in
+// values. See Insert. Returns ErrBatchLength, without inserting anything,
+// if the number of keys and values differ.
+func (c *Cache) InsertMany(keys []interface{}, values []interface{}) error {
+	if len(keys) != len(values) {
+		return ErrBatchLength
+	}
+
+	if debug {
+		c.check()
+	}
+
+	for i := range keys {
+		c.insert(nil, keys[i], values[i], 0)
+	}
+
+	if debug {
+		c.check()
+	}
+
+	return nil
+}
+
+// AccessMany accesses each key in turn and returns the values and the "ok"
+// flags, in the same order as the keys. See Access.
+func (c *Cache) AccessMany(keys []interface{}) ([]interface{}, []bool) {
+	if debug {
+		c.check()
+	}
+
+	values := make([]interface{}, len(keys))
+	oks := make([]bool, len(keys))
+	for i := range keys {
+		values[i], oks[i] = c.access(nil, keys[i])
+	}
+
+	if debug {
+		c.check()
+	}
+
+	return values, oks
+}
+
+// DeleteMany deletes each key in turn and returns the number of items that
+// were present in the cache. See Delete.
+func (c *Cache) DeleteMany(keys []interface{}) int {
+	if debug {
+		c.check()
+	}
+
+	cnt := 0
+	for i := range keys {
+		if c.delete(keys[i]) {
+			cnt++
+		}
+	}
+
+	if debug {
+		c.check()
+	}
+
+	return cnt
+}
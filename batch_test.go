@@ -0,0 +1,63 @@
+package lfucache_test
+
+import (
+	"github.com/calmh/lfucache"
+	"testing"
+)
+
+func TestBatchInsertAccess(t *testing.T) {
+	c := lfucache.New(10)
+
+	c.InsertMany([]interface{}{"test1", "test2", "test3"}, []interface{}{42, 43, 44})
+
+	values, oks := c.AccessMany([]interface{}{"test3", "test4", "test1"})
+	if !oks[0] || values[0].(int) != 44 {
+		t.Error("Didn't get the right value back from the cache (test3)")
+	}
+	if oks[1] || values[1] != nil {
+		t.Error("Unexpected hit (test4)")
+	}
+	if !oks[2] || values[2].(int) != 42 {
+		t.Error("Didn't get the right value back from the cache (test1)")
+	}
+
+	stats := c.Statistics()
+	if stats.Inserts != 3 || stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("Stats incorrect, %+v", stats)
+	}
+	if stats.FreqListLen != 2 {
+		t.Errorf("Stats freqlistlen incorrect, %d", stats.FreqListLen)
+	}
+
+	if n := c.DeleteMany([]interface{}{"test1", "test4", "test2"}); n != 2 {
+		t.Errorf("Incorrect number of items deleted, %d", n)
+	}
+	if c.Len() != 1 {
+		t.Errorf("Unexpected size %d", c.Len())
+	}
+}
+
+func TestBatchEvictionOrder(t *testing.T) {
+	c := lfucache.New(2)
+
+	exp := make(chan interface{}, 10)
+	c.Evictions(exp)
+
+	c.InsertMany([]interface{}{"test1", "test2", "test3", "test4", "test5"}, []interface{}{42, 43, 44, 45, 46})
+
+	for _, v := range []int{42, 43, 44} {
+		if e := <-exp; e.(int) != v {
+			t.Errorf("Incorrect eviction %#v, expected %d", e, v)
+		}
+	}
+}
+
+func TestBatchLengthMismatch(t *testing.T) {
+	c := lfucache.New(10)
+	if err := c.InsertMany([]interface{}{"test1"}, nil); err != lfucache.ErrBatchLength {
+		t.Errorf("Unexpected error %v for mismatched batch", err)
+	}
+	if c.Len() != 0 {
+		t.Error("Mismatched batch was inserted")
+	}
+}
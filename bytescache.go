@@ -0,0 +1,366 @@
+package lfucache
+
+import (
+	"bytes"
+	"hash/maphash"
+)
+
+// BytesCache is an LFU cache for []byte keys and values. Keys and values
+// are copied into one large byte arena and the LFU bookkeeping is kept in a
+// slab, so the garbage collector sees a handful of large allocations
+// without pointers regardless of the number of items. Space in the arena
+// left behind by removed items is reclaimed by compaction, which happens
+// automatically when an insert would otherwise not fit.
+//
+// Unlike Cache, BytesCache does not support eviction channels.
+type BytesCache struct {
+	capacity int
+	slab     slab
+	arena    []byte
+	used     int // end of the data in the arena
+	garbage  int // bytes before used that belong to removed items
+	entries  []bytesEntry
+	index    map[uint64]int32 // key hash to first slot with that hash
+	first    int32            // slot with the lowest arena offset
+	last     int32            // slot with the highest arena offset
+	seed     maphash.Seed
+	stats    Statistics
+}
+
+type bytesEntry struct {
+	hash   uint64
+	offset int // key at offset, directly followed by the value
+	keyLen int
+	valLen int
+	chain  int32 // next slot with the same key hash
+	prev   int32 // previous slot in arena order
+	next   int32 // next slot in arena order
+}
+
+// NewBytes initializes a new BytesCache holding up to capacity items, in an
+// arena of arenaSize bytes. It panics with ErrInvalidCapacity or
+// ErrInvalidArenaSize if either is invalid; NewBytesCache returns the error
+// instead.
+func NewBytes(capacity int, arenaSize int) *BytesCache {
+	c, err := NewBytesCache(capacity, arenaSize)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// NewBytesCache initializes a new BytesCache like NewBytes. Returns
+// ErrInvalidCapacity if the capacity is not positive or larger than
+// math.MaxInt32-2, and ErrInvalidArenaSize if the arena size is not
+// positive.
+func NewBytesCache(capacity int, arenaSize int) (*BytesCache, error) {
+	if capacity <= 0 || int64(capacity) > maxSlabCapacity {
+		return nil, ErrInvalidCapacity
+	}
+	if arenaSize <= 0 {
+		return nil, ErrInvalidArenaSize
+	}
+
+	return &BytesCache{
+		capacity: capacity,
+		slab:     newSlab(capacity),
+		arena:    make([]byte, arenaSize),
+		entries:  make([]bytesEntry, capacity),
+		index:    make(map[uint64]int32, capacity),
+		first:    nilIndex,
+		last:     nilIndex,
+		seed:     maphash.MakeSeed(),
+	}, nil
+}
+
+// Insert copies the key and value into the cache, evicting the existing
+// item for the key if there is one. Returns false, without changing the
+// cache, if the item is larger than the arena.
+func (c *BytesCache) Insert(key []byte, value []byte) bool {
+	need := len(key) + len(value)
+	if need > len(c.arena) {
+		return false
+	}
+
+	if debug {
+		c.check()
+	}
+
+	h := maphash.Bytes(c.seed, key)
+	if i, ok := c.find(key, h); ok {
+		c.evict(i, EvictReplace)
+	}
+
+	if c.slab.length == c.capacity {
+		c.evict(c.slab.lfu(), EvictCapacity)
+	}
+
+	for len(c.arena)-c.used < need {
+		if len(c.arena)-c.used+c.garbage >= need {
+			c.compact()
+		} else {
+			c.evict(c.slab.lfu(), EvictMemory)
+		}
+	}
+
+	i := c.slab.insert()
+	e := &c.entries[i]
+	e.hash = h
+	e.offset = c.used
+	e.keyLen = len(key)
+	e.valLen = len(value)
+	c.used += copy(c.arena[c.used:], key)
+	c.used += copy(c.arena[c.used:], value)
+	e.prev = c.last
+	e.next = nilIndex
+	if c.last != nilIndex {
+		c.entries[c.last].next = i
+	} else {
+		c.first = i
+	}
+	c.last = i
+
+	if first, ok := c.index[h]; ok {
+		e.chain = first
+	} else {
+		e.chain = nilIndex
+	}
+	c.index[h] = i
+	c.stats.Inserts++
+
+	if debug {
+		c.check()
+	}
+
+	return true
+}
+
+// Get returns the value for the key and increases the item's use count.
+// The returned slice refers directly to the cache arena and is only valid
+// until the next call to Insert or Compact. It must not be modified.
+func (c *BytesCache) Get(key []byte) ([]byte, bool) {
+	i, ok := c.access(key)
+	if !ok {
+		return nil, false
+	}
+
+	e := &c.entries[i]
+	start := e.offset + e.keyLen
+	end := start + e.valLen
+	return c.arena[start:end:end], true
+}
+
+// GetCopy is like Get, but appends the value to dst and returns the
+// resulting slice, which remains valid regardless of later cache
+// operations.
+func (c *BytesCache) GetCopy(key []byte, dst []byte) ([]byte, bool) {
+	v, ok := c.Get(key)
+	if !ok {
+		return dst, false
+	}
+	return append(dst, v...), true
+}
+
+// Delete deletes an item from the cache and returns true. Does nothing and
+// returns false if the key was not present in the cache.
+func (c *BytesCache) Delete(key []byte) bool {
+	if debug {
+		c.check()
+	}
+
+	i, ok := c.find(key, maphash.Bytes(c.seed, key))
+	if ok {
+		c.remove(i)
+		c.stats.Deletes++
+	}
+
+	if debug {
+		c.check()
+	}
+
+	return ok
+}
+
+// Compact moves all items to the start of the arena, reclaiming the space
+// left by removed items.
+func (c *BytesCache) Compact() {
+	if debug {
+		c.check()
+	}
+
+	c.compact()
+
+	if debug {
+		c.check()
+	}
+}
+
+// Len returns the number of items currently stored in the cache.
+func (c *BytesCache) Len() int {
+	return c.slab.length
+}
+
+// Cap returns the maximum number of items the cache will hold.
+func (c *BytesCache) Cap() int {
+	return c.capacity
+}
+
+// ArenaUsage returns the number of arena bytes used by current items, the
+// number of bytes that would be reclaimed by compaction, and the total
+// arena size.
+func (c *BytesCache) ArenaUsage() (live, garbage, size int) {
+	return c.used - c.garbage, c.garbage, len(c.arena)
+}
+
+// Statistics returns the cache statistics.
+func (c *BytesCache) Statistics() Statistics {
+	c.stats.LenFreq0 = c.slab.items0()
+	c.stats.FreqListLen = c.slab.numFreqs()
+	return c.stats
+}
+
+// access looks up the key and increases the item's use count
+func (c *BytesCache) access(key []byte) (int32, bool) {
+	if debug {
+		c.check()
+	}
+
+	i, ok := c.find(key, maphash.Bytes(c.seed, key))
+	if !ok {
+		c.stats.Misses++
+		return nilIndex, false
+	}
+
+	c.slab.access(i)
+	c.stats.Hits++
+
+	if debug {
+		c.check()
+	}
+
+	return i, true
+}
+
+// find returns the slot holding key, which hashes to h
+func (c *BytesCache) find(key []byte, h uint64) (int32, bool) {
+	i, ok := c.index[h]
+	if !ok {
+		return nilIndex, false
+	}
+
+	for ; i != nilIndex; i = c.entries[i].chain {
+		if bytes.Equal(c.key(i), key) {
+			return i, true
+		}
+	}
+	return nilIndex, false
+}
+
+// key returns the key of the item in slot i
+func (c *BytesCache) key(i int32) []byte {
+	e := &c.entries[i]
+	return c.arena[e.offset : e.offset+e.keyLen]
+}
+
+// evict evicts the item in slot i for the given reason
+func (c *BytesCache) evict(i int32, reason EvictReason) {
+	c.remove(i)
+	c.stats.Evictions++
+	c.stats.EvictionsByReason[reason]++
+}
+
+// remove removes the item in slot i from the index and the slab, leaving
+// its arena space as garbage
+func (c *BytesCache) remove(i int32) {
+	e := &c.entries[i]
+	if c.index[e.hash] == i {
+		if e.chain == nilIndex {
+			delete(c.index, e.hash)
+		} else {
+			c.index[e.hash] = e.chain
+		}
+	} else {
+		p := c.index[e.hash]
+		for c.entries[p].chain != i {
+			p = c.entries[p].chain
+		}
+		c.entries[p].chain = e.chain
+	}
+
+	if e.prev != nilIndex {
+		c.entries[e.prev].next = e.next
+	} else {
+		c.first = e.next
+	}
+	if e.next != nilIndex {
+		c.entries[e.next].prev = e.prev
+	} else {
+		c.last = e.prev
+	}
+
+	c.garbage += e.keyLen + e.valLen
+	*e = bytesEntry{}
+	c.slab.remove(i)
+}
+
+// compact moves all items to the start of the arena, in their current
+// order, so that no item is overwritten before it has been moved
+func (c *BytesCache) compact() {
+	c.used = 0
+	for i := c.first; i != nilIndex; i = c.entries[i].next {
+		e := &c.entries[i]
+		size := e.keyLen + e.valLen
+		copy(c.arena[c.used:], c.arena[e.offset:e.offset+size])
+		e.offset = c.used
+		c.used += size
+	}
+	c.garbage = 0
+}
+
+// Validate verifies the internal consistency of the cache structure. See
+// Cache.Validate.
+func (c *BytesCache) Validate() error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *BytesCache) validate() *ValidationError {
+	count := 0
+	live := 0
+	for _, first := range c.index {
+		for i := first; i != nilIndex; i = c.entries[i].chain {
+			count++
+			live += c.entries[i].keyLen + c.entries[i].valLen
+		}
+	}
+
+	if count != c.slab.length {
+		return &ValidationError{Reason: "index/numItems mismatch"}
+	}
+	if live != c.used-c.garbage {
+		return &ValidationError{Reason: "arena usage mismatch"}
+	}
+
+	count = 0
+	prev, end := nilIndex, 0
+	for i := c.first; i != nilIndex; i = c.entries[i].next {
+		e := &c.entries[i]
+		if e.prev != prev || e.offset < end {
+			return &ValidationError{Reason: "arena order broken"}
+		}
+		prev, end = i, e.offset+e.keyLen+e.valLen
+		count++
+	}
+	if count != c.slab.length || c.last != prev {
+		return &ValidationError{Reason: "arena list/numItems mismatch"}
+	}
+	return c.slab.validate()
+}
+
+func (c *BytesCache) check() {
+	if err := c.validate(); err != nil {
+		panic("bug: " + err.Error())
+	}
+}
@@ -0,0 +1,157 @@
+package lfucache_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/calmh/lfucache"
+	"math"
+	"testing"
+)
+
+func TestBytesInsertGet(t *testing.T) {
+	c := lfucache.NewBytes(10, 1024)
+
+	c.Insert([]byte("test1"), []byte("value1"))
+	c.Insert([]byte("test2"), []byte("value2"))
+
+	if v, ok := c.Get([]byte("test1")); !ok || string(v) != "value1" {
+		t.Errorf("Didn't get the right value back from the cache, %q", v)
+	}
+
+	buf := []byte("prefix:")
+	if v, ok := c.GetCopy([]byte("test2"), buf); !ok || string(v) != "prefix:value2" {
+		t.Errorf("Didn't get the right value back from the cache, %q", v)
+	}
+
+	if _, ok := c.Get([]byte("test3")); ok {
+		t.Error("Unexpected hit")
+	}
+
+	c.Insert([]byte("test1"), []byte("value3"))
+	if v, ok := c.Get([]byte("test1")); !ok || string(v) != "value3" {
+		t.Errorf("Didn't get the replaced value back from the cache, %q", v)
+	}
+
+	if !c.Delete([]byte("test2")) || c.Delete([]byte("test2")) {
+		t.Error("Incorrect Delete result")
+	}
+	if c.Len() != 1 {
+		t.Errorf("Unexpected size %d", c.Len())
+	}
+}
+
+func TestBytesExpiry(t *testing.T) {
+	c := lfucache.NewBytes(3, 1024)
+
+	c.Insert([]byte("test1"), []byte("42"))
+	c.Get([]byte("test1"))
+	c.Insert([]byte("test2"), []byte("43"))
+	c.Insert([]byte("test3"), []byte("44"))
+	c.Get([]byte("test3"))
+
+	c.Insert([]byte("test4"), []byte("45")) // should remove test2 which is lfu
+
+	if _, ok := c.Get([]byte("test2")); ok {
+		t.Error("Node test2 was not removed")
+	}
+	if s := c.Statistics(); s.Evictions != 1 {
+		t.Errorf("Stats evictions incorrect, %d", s.Evictions)
+	}
+}
+
+func TestBytesArenaFull(t *testing.T) {
+	c := lfucache.NewBytes(100, 100)
+
+	// Each item takes 20 bytes, so only five fit at a time
+	value := bytes.Repeat([]byte("x"), 16)
+	for i := 0; i < 5; i++ {
+		c.Insert([]byte(fmt.Sprintf("k%03d", i)), value)
+		c.Get([]byte(fmt.Sprintf("k%03d", i)))
+	}
+
+	c.Insert([]byte("k005"), value) // should remove k000 which is lfu and oldest
+	if _, ok := c.Get([]byte("k000")); ok {
+		t.Error("k000 was not removed")
+	}
+	if c.Len() != 5 {
+		t.Errorf("Unexpected size %d", c.Len())
+	}
+	if s := c.Statistics(); s.EvictionsByReason[lfucache.EvictMemory] != 1 || s.EvictionsByReason[lfucache.EvictCapacity] != 0 {
+		t.Errorf("Unexpected evictions %v", s.EvictionsByReason)
+	}
+
+	if c.Insert([]byte("big"), make([]byte, 100)) {
+		t.Error("Should not be able to insert item larger than the arena")
+	}
+}
+
+func TestBytesInvalidSize(t *testing.T) {
+	cases := []struct {
+		capacity, arenaSize int
+		err                 error
+	}{
+		{0, 100, lfucache.ErrInvalidCapacity},
+		{math.MaxInt32, 100, lfucache.ErrInvalidCapacity},
+		{10, 0, lfucache.ErrInvalidArenaSize},
+	}
+	for _, tc := range cases {
+		if _, err := lfucache.NewBytesCache(tc.capacity, tc.arenaSize); err != tc.err {
+			t.Errorf("Unexpected error %v for %d, %d", err, tc.capacity, tc.arenaSize)
+		}
+	}
+}
+
+func TestBytesCompaction(t *testing.T) {
+	c := lfucache.NewBytes(100, 100)
+
+	value := bytes.Repeat([]byte("x"), 16)
+	for i := 0; i < 5; i++ {
+		c.Insert([]byte(fmt.Sprintf("k%03d", i)), value)
+	}
+	c.Delete([]byte("k001"))
+	c.Delete([]byte("k003"))
+
+	if live, garbage, _ := c.ArenaUsage(); live != 60 || garbage != 40 {
+		t.Errorf("Unexpected arena usage %d, %d", live, garbage)
+	}
+
+	// Requires compaction, but no eviction
+	c.Insert([]byte("k005"), bytes.Repeat([]byte("y"), 36))
+
+	if c.Len() != 4 {
+		t.Errorf("Unexpected size %d", c.Len())
+	}
+	if live, garbage, _ := c.ArenaUsage(); live != 100 || garbage != 0 {
+		t.Errorf("Unexpected arena usage %d, %d", live, garbage)
+	}
+	for _, k := range []string{"k000", "k002", "k004"} {
+		if v, ok := c.Get([]byte(k)); !ok || !bytes.Equal(v, value) {
+			t.Errorf("Incorrect value for %s after compaction, %q", k, v)
+		}
+	}
+	if v, ok := c.Get([]byte("k005")); !ok || len(v) != 36 || v[0] != 'y' {
+		t.Errorf("Incorrect value for k005 after compaction, %q", v)
+	}
+}
+
+func TestBytesRandomAccess(t *testing.T) {
+	c := lfucache.NewBytes(50, 1000)
+	m := make(map[string]string)
+
+	for i := 0; i < 10000; i++ {
+		k := fmt.Sprintf("k%d", i%80)
+		v := fmt.Sprintf("v%d", i)
+		if c.Insert([]byte(k), []byte(v)) {
+			m[k] = v
+		}
+		if r, ok := c.Get([]byte(k)); !ok || string(r) != v {
+			t.Fatalf("Didn't get the right value back from the cache, %q != %q", r, v)
+		}
+	}
+
+	for k, v := range m {
+		if r, ok := c.GetCopy([]byte(k), nil); ok && string(r) != v {
+			t.Errorf("Incorrect value for %s, %q != %q", k, r, v)
+		}
+	}
+}
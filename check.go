@@ -1,34 +1,69 @@
 package lfucache
 
-func (c *Cache) check() {
-	if c.length != len(c.index) {
-		c.bug("index/numItems mismatch")
+import (
+	"fmt"
+)
+
+// ValidationError describes a violation of the internal cache invariants,
+// as found by Validate.
+type ValidationError struct {
+	Reason string      // Description of the violated invariant
+	Usage  int         // Usage count of the frequency node where the violation was found
+	Key    interface{} // Key of the offending item, or nil if not item specific
+}
+
+func (e *ValidationError) Error() string {
+	if e.Key != nil {
+		return fmt.Sprintf("%s (usage %d, key %v)", e.Reason, e.Usage, e.Key)
+	}
+	return fmt.Sprintf("%s (usage %d)", e.Reason, e.Usage)
+}
+
+// Validate verifies the internal consistency of the cache structure and
+// returns a *ValidationError describing the first violation found, or nil.
+// It walks the entire cache and is intended for tests and periodic sanity
+// checks.
+func (c *Cache) Validate() error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *Cache) validate() *ValidationError {
+	if c.length != c.indexLen() {
+		return &ValidationError{Reason: "index/numItems mismatch"}
 	}
 
 	count := 0
+	var bytes int64
 	var prevFn *frequencyNode
 	for fn := c.frequencyList; fn != nil; fn = fn.next {
 		if fn.head == nil && fn.usage != 0 {
-			c.bug("empty non-head frequency node")
+			return &ValidationError{Reason: "empty non-head frequency node", Usage: fn.usage}
 		}
 		if fn.prev != prevFn {
-			c.bug("incorrect prev frequencyNode pointer")
+			return &ValidationError{Reason: "incorrect prev frequencyNode pointer", Usage: fn.usage}
+		}
+		if prevFn != nil && fn.usage <= prevFn.usage {
+			return &ValidationError{Reason: "frequency node usage not increasing", Usage: fn.usage}
 		}
 
 		var prev *node
 		for n := fn.head; n != nil; n = n.next {
 			if n.parent != fn {
-				c.bug("incorrect parent pointer")
+				return &ValidationError{Reason: "incorrect parent pointer", Usage: fn.usage, Key: n.userKey()}
 			}
 			if n.prev != prev {
-				c.bug("incorrect prev node pointer")
+				return &ValidationError{Reason: "incorrect prev node pointer", Usage: fn.usage, Key: n.userKey()}
 			}
 			prev = n
 			count++
+			bytes += n.size
 
 			if n.next == nil {
 				if fn.tail != n {
-					c.bug("tail pointer not pointing to last node")
+					return &ValidationError{Reason: "tail pointer not pointing to last node", Usage: fn.usage, Key: n.userKey()}
 				}
 			}
 		}
@@ -36,12 +71,23 @@ func (c *Cache) check() {
 		prevFn = fn
 	}
 
-	if count != len(c.index) {
-		c.bug("index/item count mismatch")
+	if count != c.indexLen() {
+		return &ValidationError{Reason: "index/item count mismatch"}
+	}
+	if bytes != c.bytes {
+		return &ValidationError{Reason: "item size/total size mismatch"}
+	}
+
+	return nil
+}
+
+func (c *Cache) check() {
+	if err := c.validate(); err != nil {
+		c.bug(err.Error())
 	}
 }
 
 func (c *Cache) bug(msg string) {
-	c.print()
+	c.DumpJSON(c.debugWriter())
 	panic("bug: " + msg)
 }
@@ -0,0 +1,98 @@
+// Command lfucached serves an LFU cache over the network using the
+// memcached text protocol and, when enabled with -resp and -http, the Redis
+// protocol and an HTTP/JSON API (see package httpapi). All protocols share
+// the same items.
+//
+// With -debug, cache introspection (see package debughttp) and Prometheus
+// metrics are served over HTTP on the given address, under /debug/lfucache/
+// and /metrics respectively.
+//
+// Example:
+//
+//	lfucached -memcache :11211 -resp :6379 -http 127.0.0.1:8081 -capacity 1000000 -policy lfu -debug 127.0.0.1:8080
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/calmh/deprecated_lfucache"
+	"github.com/calmh/deprecated_lfucache/debughttp"
+	"github.com/calmh/deprecated_lfucache/metrics"
+	"github.com/calmh/deprecated_lfucache/server"
+	"github.com/calmh/deprecated_lfucache/server/httpapi"
+	"github.com/calmh/deprecated_lfucache/server/memcache"
+	"github.com/calmh/deprecated_lfucache/server/resp"
+)
+
+var policies = map[string]lfucache.Policy{
+	"lfu":    lfucache.LFU,
+	"lfumru": lfucache.LFUMRU,
+	"lru":    lfucache.LRU,
+}
+
+func main() {
+	memcacheAddr := flag.String("memcache", ":11211", "Address to serve the memcached protocol on, if set")
+	respAddr := flag.String("resp", "", "Address to serve the Redis protocol on, if set")
+	httpAddr := flag.String("http", "", "Address to serve the HTTP API on, if set")
+	debugAddr := flag.String("debug", "", "Address to serve debug pages and metrics on, if set")
+	capacity := flag.Int("capacity", 100000, "Maximum number of items")
+	policy := flag.String("policy", "lfu", "Eviction policy ("+strings.Join(policyNames(), ", ")+")")
+	flag.Parse()
+
+	if *memcacheAddr == "" && *respAddr == "" && *httpAddr == "" {
+		log.Fatal("no protocol enabled")
+	}
+
+	p, ok := policies[*policy]
+	if !ok {
+		log.Fatalf("unknown policy %q", *policy)
+	}
+	cache, err := lfucache.NewWithOptions(lfucache.WithCapacity(*capacity), lfucache.WithPolicy(p))
+	if err != nil {
+		log.Fatal(err)
+	}
+	store := server.NewStore(cache)
+
+	if *debugAddr != "" {
+		debughttp.Register("lfucached", cache, store.Locker())
+		collector := metrics.NewCollector()
+		collector.Add("lfucached", cache, store.Locker())
+
+		mux := http.NewServeMux()
+		mux.Handle("/debug/lfucache/", debughttp.Handler())
+		mux.Handle("/metrics", collector)
+		go func() {
+			log.Fatal(http.ListenAndServe(*debugAddr, mux))
+		}()
+	}
+
+	if *memcacheAddr != "" {
+		go func() {
+			log.Fatal(memcache.NewServer(store).ListenAndServe(*memcacheAddr))
+		}()
+	}
+	if *respAddr != "" {
+		go func() {
+			log.Fatal(resp.NewServer(store).ListenAndServe(*respAddr))
+		}()
+	}
+	if *httpAddr != "" {
+		go func() {
+			log.Fatal(http.ListenAndServe(*httpAddr, httpapi.NewHandler(store)))
+		}()
+	}
+	select {}
+}
+
+func policyNames() []string {
+	var names []string
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,197 @@
+// Command lfusim replays a cache access trace against caches of various
+// capacities and prints the resulting hit ratios as CSV.
+//
+// Traces in the binary format written by trace.Recorder are read by
+// default; the ARC, LIRS and UMass (SPC) formats are selected with -format.
+// Each request in the trace is replayed as an Access, followed by an Insert
+// when the access misses. Recorded deletes are replayed as Delete, while
+// recorded inserts and evictions are ignored as they are the result of the
+// cache being simulated.
+//
+// With -synthetic, the standard workloads of the sim package are run
+// instead of a trace.
+//
+// Example:
+//
+//	lfusim -format arc -capacities 1000,10000,100000 -policies lfu,lru,arc P1.lis > p1.csv
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/calmh/deprecated_lfucache/sim"
+	"github.com/calmh/deprecated_lfucache/trace"
+)
+
+func main() {
+	format := flag.String("format", "native", "Trace format (native, arc, lirs, umass)")
+	capacities := flag.String("capacities", "1000,10000,100000", "Comma separated list of cache capacities")
+	policyList := flag.String("policies", "lfu", "Comma separated list of policies ("+strings.Join(sim.PolicyNames(), ", ")+")")
+	synthetic := flag.Bool("synthetic", false, "Run synthetic workloads instead of a trace")
+	requests := flag.Int("requests", 1000000, "Number of requests per synthetic workload")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] [tracefile]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *requests <= 0 {
+		log.Fatal("number of requests must be positive")
+	}
+	caps, err := parseCapacities(*capacities)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pols := strings.Split(*policyList, ",")
+	for _, p := range pols {
+		if _, ok := sim.Policies[p]; !ok {
+			log.Fatalf("unknown policy %q", p)
+		}
+	}
+
+	var results []sim.Result
+	if *synthetic {
+		for _, capacity := range caps {
+			res, err := sim.Compare(pols, sim.StandardWorkloads(10*uint64(capacity), *requests), capacity)
+			if err != nil {
+				log.Fatal(err)
+			}
+			results = append(results, res...)
+		}
+	} else {
+		results = replayTrace(*format, pols, caps)
+	}
+
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"workload", "policy", "capacity", "requests", "hits", "hit_ratio", "ns_per_op", "allocs_per_op"})
+	for _, res := range results {
+		w.Write([]string{
+			res.Workload,
+			res.Policy,
+			strconv.Itoa(res.Capacity),
+			strconv.Itoa(res.Requests),
+			strconv.Itoa(res.Hits),
+			strconv.FormatFloat(res.HitRatio(), 'f', 6, 64),
+			strconv.FormatFloat(res.NsPerOp(), 'f', 1, 64),
+			strconv.FormatFloat(res.AllocsPerOp(), 'f', 2, 64),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// replayTrace replays the trace given on the command line, or standard
+// input, for each policy and capacity
+func replayTrace(format string, pols []string, caps []int) []sim.Result {
+	in := io.Reader(os.Stdin)
+	if flag.NArg() > 0 {
+		fd, err := os.Open(flag.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer fd.Close()
+		in = fd
+	}
+
+	src, err := source(format, in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	recs, err := load(src)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var results []sim.Result
+	for _, p := range pols {
+		for _, capacity := range caps {
+			res := simulate(recs, sim.Policies[p](capacity))
+			res.Workload = "trace"
+			res.Policy = p
+			results = append(results, res)
+		}
+	}
+	return results
+}
+
+func parseCapacities(s string) ([]int, error) {
+	var caps []int
+	for _, f := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid capacity %q", f)
+		}
+		caps = append(caps, n)
+	}
+	return caps, nil
+}
+
+func source(format string, r io.Reader) (trace.Source, error) {
+	switch format {
+	case "native":
+		return trace.NewReader(r)
+	case "arc":
+		return trace.NewARCReader(r), nil
+	case "lirs":
+		return trace.NewLIRSReader(r), nil
+	case "umass":
+		return trace.NewUMassReader(r), nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// load reads the records to replay into memory, so that the trace can be
+// replayed once per capacity and policy
+func load(src trace.Source) ([]trace.Record, error) {
+	var recs []trace.Record
+	for {
+		rec, err := src.Next()
+		if errors.Is(err, io.EOF) {
+			return recs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if rec.Op.IsRequest() || rec.Op == trace.OpDelete {
+			recs = append(recs, rec)
+		}
+	}
+}
+
+func simulate(recs []trace.Record, c sim.Policy) sim.Result {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	t0 := time.Now()
+
+	res := sim.Result{Capacity: c.Cap()}
+	for _, rec := range recs {
+		if rec.Op == trace.OpDelete {
+			c.Delete(rec.Key)
+			continue
+		}
+
+		res.Requests++
+		if _, ok := c.Access(rec.Key); ok {
+			res.Hits++
+		} else {
+			c.Insert(rec.Key, nil)
+		}
+	}
+	res.Duration = time.Since(t0)
+	runtime.ReadMemStats(&after)
+	res.Allocs = after.Mallocs - before.Mallocs
+	return res
+}
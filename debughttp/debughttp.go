@@ -0,0 +1,223 @@
+// Package debughttp provides live introspection of caches over HTTP and
+// expvar.
+//
+// Caches opt in by registering under a name:
+//
+//	var mut sync.Mutex
+//	c := lfucache.New(1024)
+//	debughttp.Register("users", c, &mut)
+//	http.Handle("/debug/lfucache/", debughttp.Handler())
+//
+// The handler renders all registered caches as HTML, or as JSON when
+// requested with "?format=json" or an "Accept: application/json" header.
+// All registered caches are also published as the expvar "lfucache".
+package debughttp // import "github.com/calmh/deprecated_lfucache/debughttp"
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/calmh/deprecated_lfucache"
+)
+
+// DefaultTopK is the number of hottest keys shown unless overridden by the
+// "k" query parameter.
+const DefaultTopK = 10
+
+type registered struct {
+	cache *lfucache.Cache
+	lock  sync.Locker
+}
+
+var (
+	mut        sync.Mutex
+	caches     = make(map[string]registered)
+	expvarOnce sync.Once
+)
+
+// Register makes the cache visible under the given name, replacing any
+// cache previously registered under the same name. As the cache is not
+// thread safe, lock must be the lock that serializes access to it; it is
+// held while the cache is inspected. A nil lock is only safe when the cache
+// is not used concurrently with requests to the handler.
+func Register(name string, c *lfucache.Cache, lock sync.Locker) {
+	expvarOnce.Do(func() {
+		expvar.Publish("lfucache", expvar.Func(expvarSnapshot))
+	})
+
+	mut.Lock()
+	caches[name] = registered{c, lock}
+	mut.Unlock()
+}
+
+// Unregister removes the cache registered under the given name, if any.
+func Unregister(name string) {
+	mut.Lock()
+	delete(caches, name)
+	mut.Unlock()
+}
+
+// Snapshot is the state of one registered cache at a point in time.
+type Snapshot struct {
+	Name       string
+	Len        int
+	Cap        int
+	Statistics lfucache.Statistics
+	Histogram  []lfucache.Bucket
+	Hottest    []HotKey
+}
+
+// HotKey is one of the most frequently used keys in a cache. The key is
+// formatted using fmt as it is not necessarily representable in JSON.
+type HotKey struct {
+	Key   string
+	Usage int
+}
+
+// Fill returns the fraction of the capacity in use.
+func (s Snapshot) Fill() float64 {
+	if s.Cap <= 0 {
+		return 0
+	}
+	return float64(s.Len) / float64(s.Cap)
+}
+
+// snapshots returns the state of all registered caches, sorted by name,
+// with up to k hottest keys each
+func snapshots(k int) []Snapshot {
+	mut.Lock()
+	regs := make(map[string]registered, len(caches))
+	for name, r := range caches {
+		regs[name] = r
+	}
+	mut.Unlock()
+
+	snaps := make([]Snapshot, 0, len(regs))
+	for name, r := range regs {
+		snaps = append(snaps, snapshot(name, r, k))
+	}
+	sort.Slice(snaps, func(a, b int) bool {
+		return snaps[a].Name < snaps[b].Name
+	})
+	return snaps
+}
+
+func snapshot(name string, r registered, k int) Snapshot {
+	if r.lock != nil {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+	}
+
+	s := Snapshot{
+		Name:       name,
+		Len:        r.cache.Len(),
+		Cap:        r.cache.Cap(),
+		Statistics: r.cache.Statistics(),
+		Histogram:  r.cache.Histogram(),
+	}
+	for _, ku := range r.cache.Hottest(k) {
+		s.Hottest = append(s.Hottest, HotKey{fmt.Sprint(ku.Key), ku.Usage})
+	}
+	return s
+}
+
+// expvarSnapshot returns the expvar representation of all registered
+// caches, without histograms and hottest keys as these walk the cache
+func expvarSnapshot() interface{} {
+	mut.Lock()
+	regs := make(map[string]registered, len(caches))
+	for name, r := range caches {
+		regs[name] = r
+	}
+	mut.Unlock()
+
+	vars := make(map[string]interface{}, len(regs))
+	for name, r := range regs {
+		s := snapshot(name, r, 0)
+		vars[name] = map[string]interface{}{
+			"Len":        s.Len,
+			"Cap":        s.Cap,
+			"Statistics": s.Statistics,
+		}
+	}
+	return vars
+}
+
+// Handler returns an http.Handler rendering all registered caches.
+func Handler() http.Handler {
+	return http.HandlerFunc(serve)
+}
+
+func serve(w http.ResponseWriter, r *http.Request) {
+	k := DefaultTopK
+	if v := r.URL.Query().Get("k"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid k", http.StatusBadRequest)
+			return
+		}
+		k = n
+	}
+
+	snaps := snapshots(k)
+
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(snaps)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := page.Execute(w, snaps); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+var page = template.Must(template.New("page").Funcs(template.FuncMap{
+	"percent": func(f float64) string { return fmt.Sprintf("%.1f%%", 100*f) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><title>lfucache</title></head>
+<body>
+<h1>lfucache</h1>
+{{range .}}
+<h2>{{.Name}}</h2>
+<p>
+<meter min="0" max="{{.Cap}}" value="{{.Len}}"></meter>
+{{.Len}} / {{.Cap}} items ({{percent .Fill}})
+</p>
+<h3>Statistics</h3>
+<table>
+<tr><td>Inserts</td><td>{{.Statistics.Inserts}}</td></tr>
+<tr><td>Hits</td><td>{{.Statistics.Hits}}</td></tr>
+<tr><td>Misses</td><td>{{.Statistics.Misses}}</td></tr>
+<tr><td>Evictions</td><td>{{.Statistics.Evictions}}</td></tr>
+<tr><td>Deletes</td><td>{{.Statistics.Deletes}}</td></tr>
+<tr><td>Items at usage zero</td><td>{{.Statistics.LenFreq0}}</td></tr>
+<tr><td>Frequency buckets</td><td>{{.Statistics.FreqListLen}}</td></tr>
+</table>
+<h3>Frequency histogram</h3>
+<table>
+<tr><th>Usage</th><th>Items</th></tr>
+{{range .Histogram}}<tr><td>{{.Usage}}</td><td>{{.Len}}</td></tr>
+{{end}}</table>
+<h3>Hottest keys</h3>
+<table>
+<tr><th>Key</th><th>Usage</th></tr>
+{{range .Hottest}}<tr><td>{{.Key}}</td><td>{{.Usage}}</td></tr>
+{{end}}</table>
+{{else}}
+<p>No caches registered.</p>
+{{end}}
+</body>
+</html>
+`))
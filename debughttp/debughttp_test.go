@@ -0,0 +1,107 @@
+package debughttp_test
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/calmh/deprecated_lfucache"
+	"github.com/calmh/deprecated_lfucache/debughttp"
+)
+
+func registerTestCache(t *testing.T) (*lfucache.Cache, *sync.Mutex) {
+	var mut sync.Mutex
+	c := lfucache.New(10)
+	c.Insert("test1", 42)
+	c.Insert("test2", 43)
+	c.Access("test1")
+	c.Access("test1")
+
+	debughttp.Register("test", c, &mut)
+	t.Cleanup(func() { debughttp.Unregister("test") })
+	return c, &mut
+}
+
+func TestHandlerJSON(t *testing.T) {
+	registerTestCache(t)
+
+	rec := httptest.NewRecorder()
+	debughttp.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/lfucache/?format=json&k=1", nil))
+
+	var snaps []debughttp.Snapshot
+	if err := json.Unmarshal(rec.Body.Bytes(), &snaps); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(snaps) != 1 {
+		t.Fatalf("Unexpected snapshots %+v", snaps)
+	}
+	s := snaps[0]
+	if s.Name != "test" || s.Len != 2 || s.Cap != 10 || s.Statistics.Hits != 2 {
+		t.Errorf("Unexpected snapshot %+v", s)
+	}
+	if len(s.Histogram) != 2 || s.Histogram[1] != (lfucache.Bucket{Usage: 2, Len: 1}) {
+		t.Errorf("Unexpected histogram %+v", s.Histogram)
+	}
+	if len(s.Hottest) != 1 || s.Hottest[0] != (debughttp.HotKey{Key: "test1", Usage: 2}) {
+		t.Errorf("Unexpected hottest keys %+v", s.Hottest)
+	}
+}
+
+func TestHandlerHTML(t *testing.T) {
+	registerTestCache(t)
+
+	rec := httptest.NewRecorder()
+	debughttp.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/lfucache/", nil))
+
+	body := rec.Body.String()
+	for _, s := range []string{"<h2>test</h2>", `<meter min="0" max="10" value="2">`, "<td>test1</td><td>2</td>"} {
+		if !strings.Contains(body, s) {
+			t.Errorf("Missing %q in:\n%s", s, body)
+		}
+	}
+}
+
+func TestHandlerConcurrentTraffic(t *testing.T) {
+	c, mut := registerTestCache(t)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10000; i++ {
+			mut.Lock()
+			c.Insert(i%20, i)
+			c.Access(i % 7)
+			mut.Unlock()
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		rec := httptest.NewRecorder()
+		debughttp.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/?format=json", nil))
+	}
+	<-done
+}
+
+func TestExpvar(t *testing.T) {
+	registerTestCache(t)
+
+	v := expvar.Get("lfucache")
+	if v == nil {
+		t.Fatal("lfucache expvar not published")
+	}
+
+	var vars map[string]struct {
+		Len        int
+		Statistics lfucache.Statistics
+	}
+	if err := json.Unmarshal([]byte(v.String()), &vars); err != nil {
+		t.Fatal(err)
+	}
+	if vars["test"].Len != 2 || vars["test"].Statistics.Inserts != 2 {
+		t.Errorf("Unexpected expvar %s", v.String())
+	}
+}
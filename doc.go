@@ -17,6 +17,34 @@ and manually evicting cache items matching a certain criteria. This is useful
 for example when using the package as a write cache for a database, where
 items must be written to the backing store on eviction.
 
+Several logical caches can share one capacity budget by using namespaces. Each
+namespace has its own key space, statistics and optional quotas, while LFU
+eviction is done across all namespaces.
+
+The eviction policy can be changed with the WithPolicy option. Besides the
+default LFU policy, LFU breaking ties by evicting the most recently used item
+and plain LRU are provided. Custom policies choose how the usage count of an
+item changes when it is accessed, and may choose the items to evict
+themselves by implementing Selector.
+
+Keys are compared as Go map keys by default. The WithHasher option supplies
+hash and equality functions instead, which allows keys that are not
+comparable, such as []byte keys with BytesHasher, or custom notions of key
+equality.
+
+With the WithMemoryEstimate option the cache estimates the memory used by
+its items, using the Sizer interface or reflection, and reports it in the
+statistics and histogram. WithMaxBytes additionally limits it, evicting items
+in LFU order as the capacity does.
+
+For very large caches, SlabCache keeps the LFU bookkeeping in preallocated,
+pointer free slices. This greatly reduces the work required by the garbage
+collector. It supports the core of the Cache API with the same LFU semantics:
+Insert, Access, Delete, EvictIf, Resize, Len, Cap, Statistics, Validate and
+eviction channels. It has no options, namespaces, Peek, observers or
+eviction policies. BytesCache takes this further for []byte keys and values,
+storing them in a single byte arena.
+
 The cache structure is not thread safe.
 
 Example:
@@ -0,0 +1,189 @@
+package lfucache
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// DumpOptions controls how much of the cache structure is rendered by
+// DumpJSON and DumpDOT.
+type DumpOptions struct {
+	MaxBuckets int  // Maximum number of frequency buckets to render, zero for all
+	MaxNodes   int  // Maximum number of nodes to render per bucket, zero for all
+	Values     bool // Include item values, not only keys
+}
+
+// DefaultDumpOptions are the dump options used unless changed by
+// SetDumpOptions.
+var DefaultDumpOptions = DumpOptions{
+	MaxBuckets: 32,
+	MaxNodes:   16,
+}
+
+// dumpSlack is how many more nodes and buckets than the cache length the
+// dump walks before giving up. A broken cache, which is what the dump is for
+// in the consistency checks, may have lists that are too long or cyclic.
+const dumpSlack = 16
+
+// The dump* types are the rendered form of the cache. Keys and values are
+// formatted using fmt, as they are not necessarily representable in JSON.
+
+type dumpCache struct {
+	Len              int          `json:"len"`
+	Cap              int          `json:"cap"`
+	Buckets          []dumpBucket `json:"buckets"`
+	TruncatedBuckets int          `json:"truncatedBuckets,omitempty"`
+	Unterminated     bool         `json:"unterminated,omitempty"` // Walk stopped after len+dumpSlack entries
+}
+
+type dumpBucket struct {
+	Usage          int        `json:"usage"`
+	Len            int        `json:"len"`
+	Nodes          []dumpNode `json:"nodes"`
+	TruncatedNodes int        `json:"truncatedNodes,omitempty"`
+}
+
+type dumpNode struct {
+	Namespace string `json:"namespace,omitempty"`
+	Key       string `json:"key"`
+	Value     string `json:"value,omitempty"`
+}
+
+// SetDumpOptions sets the options used by DumpJSON, DumpDOT and the
+// internal consistency checks.
+func (c *Cache) SetDumpOptions(opts DumpOptions) {
+	c.dumpOpts = &opts
+}
+
+// SetDebugOutput sets the writer that the cache structure is dumped to when
+// an internal consistency check fails in a debug build. The default is
+// os.Stderr.
+func (c *Cache) SetDebugOutput(w io.Writer) {
+	c.debugOutput = w
+}
+
+// DumpJSON writes the frequency buckets and the nodes within them, in
+// insertion order, to w as JSON.
+func (c *Cache) DumpJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c.dump())
+}
+
+// DumpDOT writes the frequency buckets and the nodes within them, in
+// insertion order, to w as a Graphviz DOT graph.
+func (c *Cache) DumpDOT(w io.Writer) error {
+	d := c.dump()
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "digraph lfucache {\n")
+	fmt.Fprintf(bw, "\trankdir=LR;\n")
+	fmt.Fprintf(bw, "\tlabel=%s;\n", strconv.Quote(fmt.Sprintf("len %d, cap %d", d.Len, d.Cap)))
+	fmt.Fprintf(bw, "\tnode [shape=box];\n")
+
+	for i, b := range d.Buckets {
+		label := fmt.Sprintf("usage %d\n%d items", b.Usage, b.Len)
+		fmt.Fprintf(bw, "\tf%d [shape=record, label=%s];\n", i, strconv.Quote(label))
+		if i > 0 {
+			fmt.Fprintf(bw, "\tf%d -> f%d;\n", i-1, i)
+		}
+
+		prev := fmt.Sprintf("f%d", i)
+		for j, n := range b.Nodes {
+			label := n.Key
+			if n.Namespace != "" {
+				label = n.Namespace + ": " + label
+			}
+			if n.Value != "" {
+				label += " = " + n.Value
+			}
+			fmt.Fprintf(bw, "\tn%d_%d [label=%s];\n", i, j, strconv.Quote(label))
+			fmt.Fprintf(bw, "\t%s -> n%d_%d;\n", prev, i, j)
+			prev = fmt.Sprintf("n%d_%d", i, j)
+		}
+		if b.TruncatedNodes > 0 {
+			fmt.Fprintf(bw, "\tt%d [shape=plaintext, label=\"%d more\"];\n", i, b.TruncatedNodes)
+			fmt.Fprintf(bw, "\t%s -> t%d;\n", prev, i)
+		}
+	}
+
+	if d.Unterminated {
+		fmt.Fprintf(bw, "\tuf [shape=plaintext, label=\"lists longer than len, walk stopped\"];\n")
+	}
+
+	if d.TruncatedBuckets > 0 {
+		fmt.Fprintf(bw, "\ttf [shape=plaintext, label=\"%d more buckets\"];\n", d.TruncatedBuckets)
+		if len(d.Buckets) > 0 {
+			fmt.Fprintf(bw, "\tf%d -> tf;\n", len(d.Buckets)-1)
+		}
+	}
+
+	fmt.Fprintf(bw, "}\n")
+	return bw.Flush()
+}
+
+// dump renders the cache structure according to the dump options
+func (c *Cache) dump() dumpCache {
+	opts := DefaultDumpOptions
+	if c.dumpOpts != nil {
+		opts = *c.dumpOpts
+	}
+
+	d := dumpCache{
+		Len: c.length,
+		Cap: c.capacity,
+	}
+
+	// Stop walking if the lists hold more entries than the length allows,
+	// so that a cycle cannot make the dump loop forever
+	limit := c.length + dumpSlack
+	walked := 0
+
+	for fn := c.frequencyList; fn != nil; fn = fn.next {
+		if walked++; walked > limit {
+			d.Unterminated = true
+			break
+		}
+		if opts.MaxBuckets > 0 && len(d.Buckets) == opts.MaxBuckets {
+			d.TruncatedBuckets++
+			continue
+		}
+
+		b := dumpBucket{Usage: fn.usage, Nodes: []dumpNode{}}
+		for n := fn.head; n != nil; n = n.next {
+			if walked++; walked > limit {
+				d.Unterminated = true
+				break
+			}
+			b.Len++
+			if opts.MaxNodes > 0 && len(b.Nodes) == opts.MaxNodes {
+				b.TruncatedNodes++
+				continue
+			}
+
+			dn := dumpNode{Key: fmt.Sprint(n.userKey())}
+			if n.ns != nil {
+				dn.Namespace = n.ns.name
+			}
+			if opts.Values {
+				dn.Value = fmt.Sprint(n.value)
+			}
+			b.Nodes = append(b.Nodes, dn)
+		}
+		d.Buckets = append(d.Buckets, b)
+	}
+
+	return d
+}
+
+// debugWriter returns the writer for consistency check failure dumps
+func (c *Cache) debugWriter() io.Writer {
+	if c.debugOutput != nil {
+		return c.debugOutput
+	}
+	return os.Stderr
+}
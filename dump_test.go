@@ -0,0 +1,114 @@
+package lfucache_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/calmh/lfucache"
+	"strings"
+	"testing"
+)
+
+func dumpTestCache() *lfucache.Cache {
+	c := lfucache.New(10)
+
+	c.Insert("test1", 42)
+	c.Insert("test2", 43)
+	c.Insert("test3", 44)
+	c.Access("test1")
+	c.Access("test1")
+	c.Namespace("ns").Insert("test4", 45)
+
+	return c
+}
+
+func TestDumpJSON(t *testing.T) {
+	c := dumpTestCache()
+	c.SetDumpOptions(lfucache.DumpOptions{MaxNodes: 2, Values: true})
+
+	var buf bytes.Buffer
+	if err := c.DumpJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var d struct {
+		Len     int
+		Cap     int
+		Buckets []struct {
+			Usage          int
+			Len            int
+			TruncatedNodes int
+			Nodes          []struct {
+				Namespace string
+				Key       string
+				Value     string
+			}
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.Len != 4 || d.Cap != 10 || len(d.Buckets) != 2 {
+		t.Fatalf("Unexpected dump %s", buf.String())
+	}
+
+	b := d.Buckets[0]
+	if b.Usage != 0 || b.Len != 3 || len(b.Nodes) != 2 || b.TruncatedNodes != 1 {
+		t.Errorf("Unexpected first bucket %+v", b)
+	}
+	if b.Nodes[0].Key != "test2" || b.Nodes[0].Value != "43" || b.Nodes[1].Key != "test3" {
+		t.Errorf("Unexpected nodes in first bucket %+v", b.Nodes)
+	}
+
+	b = d.Buckets[1]
+	if b.Usage != 2 || b.Len != 1 || b.Nodes[0].Key != "test1" {
+		t.Errorf("Unexpected second bucket %+v", b)
+	}
+}
+
+func TestDumpJSONTruncatedBuckets(t *testing.T) {
+	c := dumpTestCache()
+	c.SetDumpOptions(lfucache.DumpOptions{MaxBuckets: 1})
+
+	var buf bytes.Buffer
+	if err := c.DumpJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), `"truncatedBuckets": 1`) {
+		t.Errorf("Missing bucket truncation in %s", buf.String())
+	}
+	if strings.Contains(buf.String(), `"value"`) {
+		t.Errorf("Unexpected values in %s", buf.String())
+	}
+}
+
+func TestDumpDOT(t *testing.T) {
+	c := dumpTestCache()
+
+	var buf bytes.Buffer
+	if err := c.DumpDOT(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `digraph lfucache {
+	rankdir=LR;
+	label="len 4, cap 10";
+	node [shape=box];
+	f0 [shape=record, label="usage 0\n3 items"];
+	n0_0 [label="test2"];
+	f0 -> n0_0;
+	n0_1 [label="test3"];
+	n0_0 -> n0_1;
+	n0_2 [label="ns: test4"];
+	n0_1 -> n0_2;
+	f1 [shape=record, label="usage 2\n1 items"];
+	f0 -> f1;
+	n1_0 [label="test1"];
+	f1 -> n1_0;
+}
+`
+	if buf.String() != expected {
+		t.Errorf("Unexpected DOT output:\n%s", buf.String())
+	}
+}
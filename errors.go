@@ -0,0 +1,20 @@
+package lfucache
+
+import (
+	"errors"
+)
+
+// Errors returned, or for the constructors without an error return value
+// used as panic values, when a cache is misconfigured or misused.
+var (
+	ErrInvalidCapacity  = errors.New("invalid capacity")
+	ErrInvalidArenaSize = errors.New("invalid arena size")
+	ErrInvalidQuota     = errors.New("invalid namespace quota")
+	ErrInvalidTieBreak  = errors.New("invalid tie break")
+	ErrNilPolicy        = errors.New("nil policy")
+	ErrNilChannel       = errors.New("nil eviction channel")
+	ErrBatchLength      = errors.New("mismatched key and value count")
+	ErrInvalidUsage     = errors.New("negative usage")
+	ErrInvalidMaxBytes  = errors.New("invalid memory limit")
+	ErrItemTooLarge     = errors.New("item larger than memory limit")
+)
@@ -0,0 +1,344 @@
+// Package group provides a cache shared by a set of peers, in the style of
+// groupcache.
+//
+// Each key is owned by one of the peers, chosen by consistent hashing. A
+// Get on the owner is served from its LFU cache or, on a miss, by its
+// Loader. A Get on any other peer fetches the value from the owner over
+// HTTP, falling back to loading it locally if the owner cannot be reached.
+// Concurrent Gets of the same key on a peer share a single load or fetch.
+//
+// Non-owners may keep the values they fetch in a small "hot" cache. As it
+// is an LFU cache, the keys that stay in it are the frequently fetched
+// ones, sparing the owner of popular keys most of the requests.
+//
+// Peers are configured statically by their base URLs, and each serves the
+// others on BasePath:
+//
+//	g, err := group.New(group.Config{
+//		Self:     "http://10.0.0.1:8000",
+//		Peers:    []string{"http://10.0.0.1:8000", "http://10.0.0.2:8000"},
+//		Capacity: 100000,
+//	}, group.LoaderFunc(load))
+//	http.Handle(group.BasePath, g)
+//
+// All peers must be given the same list of peers, in any order.
+package group // import "github.com/calmh/deprecated_lfucache/group"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/calmh/deprecated_lfucache"
+)
+
+// BasePath is the path under which peers serve each other.
+const BasePath = "/_lfucache/"
+
+// maxErrorLen is the longest error message read from a peer
+const maxErrorLen = 4096
+
+// ErrNoSelf is returned by New when Self is not among the peers.
+var ErrNoSelf = errors.New("self is not a peer")
+
+// errIncomplete is the result of a call whose load or fetch panicked
+var errIncomplete = errors.New("load did not complete")
+
+// Loader loads the value for a key on a cache miss on the owner of the
+// key.
+type Loader interface {
+	Load(ctx context.Context, key string) ([]byte, error)
+}
+
+// LoaderFunc is a function implementing Loader.
+type LoaderFunc func(ctx context.Context, key string) ([]byte, error)
+
+// Load calls f(ctx, key).
+func (f LoaderFunc) Load(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
+// Config is the configuration of a peer.
+type Config struct {
+	Self        string       // Base URL of this peer
+	Peers       []string     // Base URLs of all peers, including Self
+	Capacity    int          // Number of owned items cached
+	HotCapacity int          // Number of fetched items cached, none if zero
+	Replicas    int          // Points on the hash ring per peer, DefaultReplicas if zero
+	Client      *http.Client // Client used to fetch from peers, http.DefaultClient if nil
+}
+
+// RemoteError is an error reported by the owner of a key, usually from its
+// Loader.
+type RemoteError struct {
+	Peer    string
+	Status  int
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("peer %s: %s (%d)", e.Peer, e.Message, e.Status)
+}
+
+// Statistics contains the counters of a peer and the statistics of its
+// caches.
+type Statistics struct {
+	Gets         int // Number of Get()s
+	Hits         int // Number of lookups served from the main cache
+	HotHits      int // Number of lookups served from the hot cache
+	Loads        int // Number of calls to the Loader
+	Fetches      int // Number of values fetched from peers
+	FetchErrors  int // Number of fetches failing for reasons other than RemoteError
+	Deduplicated int // Number of lookups waiting for a load or fetch already in progress
+	Served       int // Number of requests served to peers
+
+	Main lfucache.Statistics
+	Hot  lfucache.Statistics
+}
+
+// Group is a peer of a distributed cache. It is safe for concurrent use.
+type Group struct {
+	self   string
+	ring   *Ring
+	loader Loader
+	client *http.Client
+
+	mut   sync.Mutex
+	main  *lfucache.Cache
+	hot   *lfucache.Cache // nil if disabled
+	calls map[string]*call
+	stats Statistics
+}
+
+// call is a load or fetch in progress, shared by concurrent Gets
+type call struct {
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+// New returns the peer described by the configuration, loading values
+// using loader.
+func New(cfg Config, loader Loader) (*Group, error) {
+	found := false
+	for _, peer := range cfg.Peers {
+		if peer == cfg.Self {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, ErrNoSelf
+	}
+
+	main, err := lfucache.NewWithOptions(lfucache.WithCapacity(cfg.Capacity))
+	if err != nil {
+		return nil, err
+	}
+	var hot *lfucache.Cache
+	if cfg.HotCapacity != 0 {
+		hot, err = lfucache.NewWithOptions(lfucache.WithCapacity(cfg.HotCapacity))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	replicas := cfg.Replicas
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	client := cfg.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &Group{
+		self:   cfg.Self,
+		ring:   NewRing(replicas, cfg.Peers...),
+		loader: loader,
+		client: client,
+		main:   main,
+		hot:    hot,
+		calls:  make(map[string]*call),
+	}, nil
+}
+
+// Owner returns the base URL of the peer owning the key.
+func (g *Group) Owner(key string) string {
+	return g.ring.Owner(key)
+}
+
+// Get returns the value for the key, from the cache, the owner of the key
+// or the Loader. The value must not be modified. Concurrent Gets of the key
+// wait for the same load, which runs with the context of the first of
+// them. Should that context end before the load does, the other Gets retry
+// the load with their own.
+func (g *Group) Get(ctx context.Context, key string) ([]byte, error) {
+	return g.get(ctx, key, g.ring.Owner(key) == g.self, false)
+}
+
+// get returns the value for the key, fetching it from the owner unless
+// owned is set. Served is set for requests from other peers.
+func (g *Group) get(ctx context.Context, key string, owned, served bool) ([]byte, error) {
+	g.mut.Lock()
+	if served {
+		g.stats.Served++
+	} else {
+		g.stats.Gets++
+	}
+	for {
+		if v, ok := g.main.Access(key); ok {
+			g.stats.Hits++
+			g.mut.Unlock()
+			return v.([]byte), nil
+		}
+		if g.hot != nil {
+			if v, ok := g.hot.Access(key); ok {
+				g.stats.HotHits++
+				g.mut.Unlock()
+				return v.([]byte), nil
+			}
+		}
+		c, ok := g.calls[key]
+		if !ok {
+			break
+		}
+		g.stats.Deduplicated++
+		g.mut.Unlock()
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		// A call ended by the context of the Get that started it is
+		// retried with this one
+		if !errors.Is(c.err, context.Canceled) && !errors.Is(c.err, context.DeadlineExceeded) {
+			return c.value, c.err
+		}
+		g.mut.Lock()
+	}
+	c := &call{done: make(chan struct{}), err: errIncomplete}
+	g.calls[key] = c
+	g.mut.Unlock()
+
+	defer func() {
+		g.mut.Lock()
+		delete(g.calls, key)
+		g.mut.Unlock()
+		close(c.done)
+	}()
+
+	c.value, c.err = g.load(ctx, key, owned)
+	return c.value, c.err
+}
+
+// load fetches the value from the owner of the key, unless owned is set,
+// or loads it locally, and caches it
+func (g *Group) load(ctx context.Context, key string, owned bool) ([]byte, error) {
+	if !owned {
+		v, err := g.fetch(ctx, g.ring.Owner(key), key)
+		var rerr *RemoteError
+		if err == nil || errors.As(err, &rerr) || ctx.Err() != nil {
+			g.mut.Lock()
+			if err == nil {
+				g.stats.Fetches++
+				if g.hot != nil {
+					g.hot.Insert(key, v)
+				}
+			}
+			g.mut.Unlock()
+			return v, err
+		}
+
+		// The owner is unreachable. The value is loaded here instead, but
+		// kept only as a hot item as it would not be found in the main
+		// cache once the owner is back.
+		g.mut.Lock()
+		g.stats.FetchErrors++
+		g.mut.Unlock()
+	}
+
+	g.mut.Lock()
+	g.stats.Loads++
+	g.mut.Unlock()
+
+	v, err := g.loader.Load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	g.mut.Lock()
+	if owned {
+		g.main.Insert(key, v)
+	} else if g.hot != nil {
+		g.hot.Insert(key, v)
+	}
+	g.mut.Unlock()
+	return v, nil
+}
+
+// fetch requests the value for the key from the peer
+func (g *Group) fetch(ctx context.Context, peer, key string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(peer, "/")+BasePath+url.PathEscape(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorLen))
+		return nil, &RemoteError{
+			Peer:    peer,
+			Status:  resp.StatusCode,
+			Message: strings.TrimSpace(string(msg)),
+		}
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// ServeHTTP serves the values of keys to other peers, at BasePath followed
+// by the escaped key. The value is loaded locally, regardless of which
+// peer owns the key, so that peers with differing peer lists do not
+// forward requests in circles.
+func (g *Group) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, BasePath) {
+		http.NotFound(w, r)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, BasePath)
+
+	v, err := g.get(r.Context(), key, true, true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(v)
+}
+
+// Statistics returns the peer statistics.
+func (g *Group) Statistics() Statistics {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	s := g.stats
+	s.Main = g.main.Statistics()
+	if g.hot != nil {
+		s.Hot = g.hot.Statistics()
+	}
+	return s
+}
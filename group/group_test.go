@@ -0,0 +1,280 @@
+package group_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/calmh/deprecated_lfucache/group"
+)
+
+// startPeers starts n peers on localhost ports, loading values using
+// loader
+func startPeers(t *testing.T, n int, loader group.Loader) ([]*group.Group, []string) {
+	var listeners []net.Listener
+	var peers []string
+	for i := 0; i < n; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners = append(listeners, l)
+		peers = append(peers, "http://"+l.Addr().String())
+	}
+
+	var groups []*group.Group
+	for i, l := range listeners {
+		g, err := group.New(group.Config{
+			Self:        peers[i],
+			Peers:       peers,
+			Capacity:    100,
+			HotCapacity: 10,
+		}, loader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		groups = append(groups, g)
+
+		srv := &http.Server{Handler: g}
+		go srv.Serve(l)
+		t.Cleanup(func() { srv.Close() })
+	}
+	return groups, peers
+}
+
+// keyOwnedBy returns a key owned by the peer
+func keyOwnedBy(g *group.Group, peer string) string {
+	for i := 0; ; i++ {
+		key := "key" + strconv.Itoa(i)
+		if g.Owner(key) == peer {
+			return key
+		}
+	}
+}
+
+// countingLoader returns the key as the value and counts the loads per key
+type countingLoader struct {
+	mut   sync.Mutex
+	loads map[string]int
+	delay time.Duration
+}
+
+func (l *countingLoader) Load(_ context.Context, key string) ([]byte, error) {
+	time.Sleep(l.delay)
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	if l.loads == nil {
+		l.loads = make(map[string]int)
+	}
+	l.loads[key]++
+	if key == "error" {
+		return nil, errors.New("load failed")
+	}
+	return []byte(key), nil
+}
+
+func TestGroupGet(t *testing.T) {
+	loader := &countingLoader{}
+	groups, _ := startPeers(t, 3, loader)
+	ctx := context.Background()
+
+	for i := 0; i < 30; i++ {
+		key := "key" + strconv.Itoa(i)
+		for _, g := range groups {
+			v, err := g.Get(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(v) != key {
+				t.Errorf("Unexpected value %q for %s", v, key)
+			}
+		}
+		if loader.loads[key] != 1 {
+			t.Errorf("Key %s loaded %d times", key, loader.loads[key])
+		}
+	}
+
+	var loads, fetches, served int
+	for _, g := range groups {
+		s := g.Statistics()
+		loads += s.Loads
+		fetches += s.Fetches
+		served += s.Served
+		if s.Loads == 0 || s.Fetches == 0 {
+			t.Errorf("Unbalanced statistics %+v", s)
+		}
+	}
+	if loads != 30 || fetches != 60 || served != 60 {
+		t.Errorf("Unexpected totals, %d loads, %d fetches, %d served", loads, fetches, served)
+	}
+}
+
+func TestGroupHotCache(t *testing.T) {
+	groups, peers := startPeers(t, 2, &countingLoader{})
+	ctx := context.Background()
+
+	key := keyOwnedBy(groups[0], peers[1])
+	for i := 0; i < 5; i++ {
+		if v, err := groups[0].Get(ctx, key); err != nil || string(v) != key {
+			t.Fatalf("Unexpected result %q, %v", v, err)
+		}
+	}
+
+	if s := groups[0].Statistics(); s.Fetches != 1 || s.HotHits != 4 || s.Hot.Hits != 4 {
+		t.Errorf("Unexpected statistics %+v", s)
+	}
+	if s := groups[1].Statistics(); s.Served != 1 || s.Loads != 1 {
+		t.Errorf("Unexpected owner statistics %+v", s)
+	}
+}
+
+func TestGroupLoadError(t *testing.T) {
+	groups, peers := startPeers(t, 2, &countingLoader{})
+	ctx := context.Background()
+
+	owner := groups[0].Owner("error")
+	for i, g := range groups {
+		_, err := g.Get(ctx, "error")
+		var rerr *group.RemoteError
+		switch {
+		case err == nil:
+			t.Errorf("Missing load error on peer %d", i)
+		case peers[i] == owner && errors.As(err, &rerr):
+			t.Errorf("Unexpected remote error %v on owner", err)
+		case peers[i] != owner && (!errors.As(err, &rerr) || rerr.Status != http.StatusInternalServerError):
+			t.Errorf("Unexpected error %v on non-owner", err)
+		}
+	}
+}
+
+func TestGroupDeduplication(t *testing.T) {
+	loader := &countingLoader{delay: 50 * time.Millisecond}
+	groups, _ := startPeers(t, 2, loader)
+
+	var wg sync.WaitGroup
+	var failures int32
+	for i := 0; i < 10; i++ {
+		for _, g := range groups {
+			wg.Add(1)
+			go func(g *group.Group) {
+				defer wg.Done()
+				if v, err := g.Get(context.Background(), "test1"); err != nil || string(v) != "test1" {
+					atomic.AddInt32(&failures, 1)
+				}
+			}(g)
+		}
+	}
+	wg.Wait()
+
+	if failures != 0 {
+		t.Errorf("%d failed Gets", failures)
+	}
+	if loader.loads["test1"] != 1 {
+		t.Errorf("Loaded %d times", loader.loads["test1"])
+	}
+}
+
+// blockingLoader loads the key as the value once released, or fails when
+// the context ends first
+type blockingLoader struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (l *blockingLoader) Load(ctx context.Context, key string) ([]byte, error) {
+	l.started <- struct{}{}
+	select {
+	case <-l.release:
+		return []byte(key), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestGroupDeduplicationCancel(t *testing.T) {
+	loader := &blockingLoader{started: make(chan struct{}, 2), release: make(chan struct{})}
+	g, err := group.New(group.Config{Self: "a", Peers: []string{"a"}, Capacity: 10}, loader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := g.Get(ctx, "test1")
+		first <- err
+	}()
+	<-loader.started
+
+	second := make(chan error)
+	go func() {
+		v, err := g.Get(context.Background(), "test1")
+		if err == nil && string(v) != "test1" {
+			err = errors.New("unexpected value " + string(v))
+		}
+		second <- err
+	}()
+	for g.Statistics().Deduplicated == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The second Get retries the load cancelled with the first
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Errorf("Unexpected error %v for the cancelled Get", err)
+	}
+	<-loader.started
+	close(loader.release)
+	if err := <-second; err != nil {
+		t.Error(err)
+	}
+	if s := g.Statistics(); s.Loads != 2 {
+		t.Errorf("Unexpected statistics %+v", s)
+	}
+}
+
+func TestGroupUnreachableOwner(t *testing.T) {
+	loader := &countingLoader{}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := "http://" + l.Addr().String()
+	l.Close()
+
+	g, err := group.New(group.Config{
+		Self:        "http://self.invalid",
+		Peers:       []string{"http://self.invalid", down},
+		Capacity:    10,
+		HotCapacity: 10,
+	}, loader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := keyOwnedBy(g, down)
+	if v, err := g.Get(context.Background(), key); err != nil || string(v) != key {
+		t.Fatalf("Unexpected result %q, %v", v, err)
+	}
+	if _, err := g.Get(context.Background(), key); err != nil {
+		t.Fatal(err)
+	}
+	if s := g.Statistics(); s.FetchErrors != 1 || s.Loads != 1 || s.HotHits != 1 {
+		t.Errorf("Unexpected statistics %+v", s)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	if _, err := group.New(group.Config{Self: "a", Peers: []string{"b"}, Capacity: 10}, nil); err != group.ErrNoSelf {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if _, err := group.New(group.Config{Self: "a", Peers: []string{"a"}}, nil); err == nil {
+		t.Error("Unexpected nil error for zero capacity")
+	}
+}
@@ -0,0 +1,61 @@
+package group
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// DefaultReplicas is the number of points on the ring per peer unless
+// overridden by Config.Replicas.
+const DefaultReplicas = 64
+
+// Ring assigns keys to peers by consistent hashing. Each peer is placed on
+// the ring at a number of points, and a key is owned by the peer at the
+// first point following the hash of the key. Adding or removing a peer
+// thus moves only the keys adjacent to its points.
+type Ring struct {
+	points []uint32
+	owners map[uint32]string
+}
+
+// NewRing returns a ring of the peers with the given number of points per
+// peer.
+func NewRing(replicas int, peers ...string) *Ring {
+	r := &Ring{owners: make(map[uint32]string, replicas*len(peers))}
+	for _, peer := range peers {
+		for i := 0; i < replicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + peer))
+			if _, ok := r.owners[h]; ok {
+				// The first peer keeps a colliding point, regardless of
+				// the order peers are given in
+				if r.owners[h] < peer {
+					continue
+				}
+			} else {
+				r.points = append(r.points, h)
+			}
+			r.owners[h] = peer
+		}
+	}
+	sort.Slice(r.points, func(a, b int) bool {
+		return r.points[a] < r.points[b]
+	})
+	return r
+}
+
+// Owner returns the peer owning the key, or the empty string if the ring
+// is empty.
+func (r *Ring) Owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i] >= h
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
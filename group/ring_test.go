@@ -0,0 +1,49 @@
+package group
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestRingOwner(t *testing.T) {
+	if owner := NewRing(10).Owner("test1"); owner != "" {
+		t.Errorf("Empty ring returned owner %q", owner)
+	}
+
+	peers := []string{"a", "b", "c"}
+	r := NewRing(DefaultReplicas, peers...)
+	reversed := NewRing(DefaultReplicas, "c", "b", "a")
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		key := "key" + strconv.Itoa(i)
+		owner := r.Owner(key)
+		if other := reversed.Owner(key); other != owner {
+			t.Fatalf("Owner of %s depends on peer order, %s != %s", key, owner, other)
+		}
+		counts[owner]++
+	}
+	for _, peer := range peers {
+		if counts[peer] < 500 {
+			t.Errorf("Uneven distribution %v", counts)
+		}
+	}
+}
+
+func TestRingStability(t *testing.T) {
+	before := NewRing(DefaultReplicas, "a", "b", "c")
+	after := NewRing(DefaultReplicas, "a", "b", "c", "d")
+
+	moved := 0
+	for i := 0; i < 4000; i++ {
+		key := "key" + strconv.Itoa(i)
+		if o := after.Owner(key); o != before.Owner(key) {
+			if o != "d" {
+				t.Fatalf("Key %s moved between existing peers", key)
+			}
+			moved++
+		}
+	}
+	if moved < 500 || moved > 1500 {
+		t.Errorf("Unexpected number of moved keys %d", moved)
+	}
+}
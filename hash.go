@@ -0,0 +1,145 @@
+package lfucache
+
+import (
+	"bytes"
+	"hash/maphash"
+)
+
+// Hasher defines the identity of keys for a cache created with WithHasher,
+// in place of Go map key equality. Keys that are Equal must have the same
+// Hash.
+type Hasher interface {
+	Hash(key interface{}) uint64
+	Equal(a, b interface{}) bool
+}
+
+// WithHasher makes the cache identify keys by the hasher, using its own
+// hash table instead of a Go map. Keys then need not be comparable, and
+// keys that are logically equal but differ structurally, such as strings
+// differing only in case, can be made to refer to the same item. Keys in
+// namespaces are identified by the hasher within each namespace. A nil
+// hasher restores the default.
+func WithHasher(h Hasher) Option {
+	return func(c *Cache) {
+		c.hasher = h
+	}
+}
+
+// BytesHasher is a Hasher for []byte keys, comparing them by content. It
+// avoids converting keys to strings for use with a plain Cache. Keys must
+// not be modified while in the cache.
+var BytesHasher Hasher = bytesHasher{maphash.MakeSeed()}
+
+type bytesHasher struct {
+	seed maphash.Seed
+}
+
+func (h bytesHasher) Hash(key interface{}) uint64 {
+	return maphash.Bytes(h.seed, key.([]byte))
+}
+
+func (bytesHasher) Equal(a, b interface{}) bool {
+	return bytes.Equal(a.([]byte), b.([]byte))
+}
+
+// lookup returns the node for the index key
+func (c *Cache) lookup(key interface{}) (*node, bool) {
+	if c.hasher == nil {
+		n, ok := c.index[key]
+		return n, ok
+	}
+
+	for n := c.hashIndex[c.hashKey(key)]; n != nil; n = n.chain {
+		if c.keysEqual(n.key, key) {
+			return n, true
+		}
+	}
+	return nil, false
+}
+
+// addToIndex adds the node to the index under its key, which must not
+// already be present
+func (c *Cache) addToIndex(n *node) {
+	if c.hasher == nil {
+		c.index[n.key] = n
+		return
+	}
+
+	n.hash = c.hashKey(n.key)
+	n.chain = c.hashIndex[n.hash]
+	c.hashIndex[n.hash] = n
+	c.hashLen++
+}
+
+// removeFromIndex removes the node from the index
+func (c *Cache) removeFromIndex(n *node) {
+	if c.hasher == nil {
+		delete(c.index, n.key)
+		return
+	}
+
+	first := c.hashIndex[n.hash]
+	if first == n {
+		if n.chain == nil {
+			delete(c.hashIndex, n.hash)
+		} else {
+			c.hashIndex[n.hash] = n.chain
+		}
+	} else {
+		p := first
+		for p.chain != n {
+			p = p.chain
+		}
+		p.chain = n.chain
+	}
+	n.chain = nil
+	c.hashLen--
+}
+
+// indexLen returns the number of nodes in the index
+func (c *Cache) indexLen() int {
+	if c.hasher == nil {
+		return len(c.index)
+	}
+	return c.hashLen
+}
+
+// forEachNode calls fn for each node in the index. The node passed may be
+// removed by fn.
+func (c *Cache) forEachNode(fn func(*node)) {
+	if c.hasher == nil {
+		for _, n := range c.index {
+			fn(n)
+		}
+		return
+	}
+
+	nodes := make([]*node, 0, c.hashLen)
+	for _, n := range c.hashIndex {
+		for ; n != nil; n = n.chain {
+			nodes = append(nodes, n)
+		}
+	}
+	for _, n := range nodes {
+		fn(n)
+	}
+}
+
+// hashKey hashes an index key, which may be wrapped for a namespace
+func (c *Cache) hashKey(key interface{}) uint64 {
+	return c.hasher.Hash(unwrapKey(key))
+}
+
+// keysEqual compares index keys, which are equal if in the same namespace
+// and equal by the hasher
+func (c *Cache) keysEqual(a, b interface{}) bool {
+	ka, aok := a.(nsKey)
+	kb, bok := b.(nsKey)
+	switch {
+	case aok && bok:
+		return ka.ns == kb.ns && c.hasher.Equal(ka.key, kb.key)
+	case aok || bok:
+		return false
+	}
+	return c.hasher.Equal(a, b)
+}
@@ -0,0 +1,146 @@
+package lfucache_test
+
+import (
+	"github.com/calmh/lfucache"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// foldHasher treats strings differing only in case as equal
+type foldHasher struct{}
+
+func (foldHasher) Hash(key interface{}) uint64 {
+	var h uint64
+	for _, r := range strings.ToLower(key.(string)) {
+		h = h*31 + uint64(r)
+	}
+	return h
+}
+
+func (foldHasher) Equal(a, b interface{}) bool {
+	return strings.EqualFold(a.(string), b.(string))
+}
+
+// collidingHasher hashes all int keys into a few buckets, to exercise
+// chains of nodes sharing a hash
+type collidingHasher struct{}
+
+func (collidingHasher) Hash(key interface{}) uint64 {
+	return uint64(key.(int) % 3)
+}
+
+func (collidingHasher) Equal(a, b interface{}) bool {
+	return a.(int) == b.(int)
+}
+
+func TestHasherBytesKeys(t *testing.T) {
+	c := lfucache.New(2, lfucache.WithHasher(lfucache.BytesHasher))
+
+	c.Insert([]byte("a"), 1)
+	c.Insert([]byte("b"), 2)
+	c.Insert([]byte("a"), 3)
+
+	if c.Len() != 2 {
+		t.Errorf("Unexpected size %d", c.Len())
+	}
+	if v, ok := c.Access([]byte("a")); !ok || v.(int) != 3 {
+		t.Errorf("Unexpected value %v, %v for a", v, ok)
+	}
+
+	c.Insert([]byte("c"), 4)
+	if _, ok := c.Peek([]byte("b")); ok {
+		t.Error("b was not evicted")
+	}
+	if !c.Delete([]byte("c")) {
+		t.Error("Delete of c failed")
+	}
+	if c.Delete([]byte("c")) {
+		t.Error("Second delete of c succeeded")
+	}
+	if c.Len() != 1 {
+		t.Errorf("Unexpected size %d", c.Len())
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHasherEquality(t *testing.T) {
+	c := lfucache.New(10, lfucache.WithHasher(foldHasher{}))
+
+	c.Insert("Test", 1)
+	c.Insert("TEST", 2)
+
+	if c.Len() != 1 {
+		t.Errorf("Unexpected size %d", c.Len())
+	}
+	if v, ok := c.Access("test"); !ok || v.(int) != 2 {
+		t.Errorf("Unexpected value %v, %v for test", v, ok)
+	}
+	if u, _ := c.Usage("tEST"); u != 1 {
+		t.Errorf("Unexpected usage %d", u)
+	}
+}
+
+func TestHasherNamespaces(t *testing.T) {
+	c := lfucache.New(10, lfucache.WithHasher(foldHasher{}))
+	a := c.Namespace("a")
+	b := c.Namespace("b")
+
+	c.Insert("test", 1)
+	a.Insert("Test", 2)
+	b.Insert("TEST", 3)
+
+	if c.Len() != 3 {
+		t.Errorf("Unexpected size %d", c.Len())
+	}
+	if v, _ := c.Access("TeSt"); v.(int) != 1 {
+		t.Error("Didn't get the right value back from the cache")
+	}
+	if v, _ := a.Access("test"); v.(int) != 2 {
+		t.Error("Didn't get the right value back from namespace a")
+	}
+	if v, _ := b.Access("test"); v.(int) != 3 {
+		t.Error("Didn't get the right value back from namespace b")
+	}
+
+	if n := a.EvictIf(func(interface{}) bool { return true }); n != 1 {
+		t.Errorf("Unexpected number of evictions %d", n)
+	}
+	if c.Len() != 2 {
+		t.Errorf("Unexpected size %d after eviction", c.Len())
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHasherRandomOperations(t *testing.T) {
+	c := lfucache.New(64, lfucache.WithHasher(collidingHasher{}))
+	r := rand.New(rand.NewSource(42))
+
+	for i := 0; i < 100000; i++ {
+		key := r.Intn(256)
+		switch r.Intn(5) {
+		case 0:
+			c.Insert(key, key)
+		case 1:
+			c.Delete(key)
+		case 2:
+			c.EvictIf(func(v interface{}) bool { return v.(int) == key+1 })
+		default:
+			if v, ok := c.Access(key); ok && v.(int) != key {
+				t.Fatalf("Incorrect value %v for key %d", v, key)
+			}
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	s := c.Statistics()
+	if s.Inserts-s.Evictions-s.Deletes != c.Len() || c.Len() > c.Cap() {
+		t.Errorf("Inconsistent length %d for %+v", c.Len(), s)
+	}
+}
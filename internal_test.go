@@ -2,6 +2,7 @@ package lfucache
 
 import (
 	"testing"
+	"time"
 )
 
 func TestMinimalFrequencyNodesDuringAccess(t *testing.T) {
@@ -111,3 +112,160 @@ func TestMinimalFrequencyNodesDuringDelete2(t *testing.T) {
 		t.Errorf("Non-minimal number of frequency nodes %d\n", n)
 	}
 }
+
+func TestFreelistsBoundedByCapacity(t *testing.T) {
+	c := New(2)
+
+	for i := 0; i < 10; i++ {
+		c.Insert(i, i)
+		c.Access(i)
+		c.Access(i)
+	}
+
+	c.Delete(8)
+	c.Delete(9)
+
+	if c.numFreeNodes != 2 {
+		t.Errorf("Unexpected number of free nodes %d", c.numFreeNodes)
+	}
+	if c.numFreeFrequencyNodes != 2 {
+		t.Errorf("Unexpected number of free frequency nodes %d", c.numFreeFrequencyNodes)
+	}
+
+	c.Resize(1)
+
+	if c.numFreeNodes != 1 || c.numFreeFrequencyNodes != 1 {
+		t.Errorf("Freelists not trimmed, %d, %d", c.numFreeNodes, c.numFreeFrequencyNodes)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := New(10)
+
+	c.Insert("test1", 42)
+	c.Insert("test2", 43)
+	c.Access("test1")
+	c.Access("test1")
+	c.Access("test2")
+
+	if err := c.Validate(); err != nil {
+		t.Fatal("Unexpected validation error", err)
+	}
+
+	// Swap the usage counts of the two non-head frequency nodes
+	fn := c.frequencyList.next
+	fn.usage, fn.next.usage = fn.next.usage, fn.usage
+
+	err := c.Validate()
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Expected *ValidationError, got %#v", err)
+	}
+	if verr.Reason != "frequency node usage not increasing" || verr.Usage != 1 {
+		t.Errorf("Unexpected validation error %v", verr)
+	}
+	fn.usage, fn.next.usage = fn.next.usage, fn.usage
+
+	// Break the tail pointer
+	fn.tail = nil
+
+	err = c.Validate()
+	if verr, ok := err.(*ValidationError); !ok || verr.Reason != "tail pointer not pointing to last node" || verr.Key != "test2" {
+		t.Errorf("Unexpected validation error %v", err)
+	}
+}
+
+func TestDumpCycle(t *testing.T) {
+	c := New(10)
+
+	c.Insert("test1", 42)
+	c.Insert("test2", 43)
+
+	// Make the node list cyclic; the dump must still terminate
+	n := c.frequencyList.tail
+	n.next = c.frequencyList.head
+
+	d := c.dump()
+	if !d.Unterminated || d.Buckets[0].Len != c.length+dumpSlack-1 {
+		t.Errorf("Unexpected dump of cyclic list %+v", d)
+	}
+	n.next = nil
+}
+
+func TestHistogramHottest(t *testing.T) {
+	c := New(10)
+
+	c.Insert("test1", 42)
+	c.Insert("test2", 43)
+	c.Insert("test3", 44)
+	c.Access("test1")
+	c.Access("test1")
+	c.Access("test2")
+	c.Access("test3")
+
+	hist := c.Histogram()
+	expected := []Bucket{{0, 0, 0}, {1, 2, 0}, {2, 1, 0}}
+	if len(hist) != len(expected) {
+		t.Fatalf("Unexpected histogram %v", hist)
+	}
+	for i := range hist {
+		if hist[i] != expected[i] {
+			t.Errorf("Unexpected histogram %v", hist)
+		}
+	}
+
+	hot := c.Hottest(2)
+	if len(hot) != 2 || hot[0] != (KeyUsage{"test1", 2}) || hot[1] != (KeyUsage{"test3", 1}) {
+		t.Errorf("Unexpected hottest keys %v", hot)
+	}
+
+	cold := c.Coldest(2)
+	if len(cold) != 2 || cold[0] != (KeyUsage{"test2", 1}) || cold[1] != (KeyUsage{"test3", 1}) {
+		t.Errorf("Unexpected coldest keys %v", cold)
+	}
+}
+
+func TestWindowedStatistics(t *testing.T) {
+	now := time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := New(2, WithWindowedStatistics())
+	c.windows.now = func() time.Time { return now }
+
+	c.Insert("test1", 42)
+	c.Access("test1")
+	c.Access("test2")
+
+	now = now.Add(10 * time.Second)
+	c.Insert("test2", 43)
+	c.Insert("test3", 44) // evicts test2
+	c.Access("test1")
+
+	s := c.WindowedStatistics(5 * time.Second)
+	if s.Period != 5*time.Second || s.Inserts != 2 || s.Hits != 1 || s.Misses != 0 || s.Evictions != 1 {
+		t.Errorf("Unexpected 5s statistics %+v", s)
+	}
+
+	s = c.WindowedStatistics(time.Minute)
+	if s.Inserts != 3 || s.Hits != 2 || s.Misses != 1 || s.Evictions != 1 {
+		t.Errorf("Unexpected 1m statistics %+v", s)
+	}
+	if r := s.HitRatio(); r != 2.0/3 {
+		t.Errorf("Unexpected hit ratio %v", r)
+	}
+
+	// The seconds ring has wrapped, but the minutes ring still has it all
+	now = now.Add(2 * time.Minute)
+	if s := c.WindowedStatistics(30 * time.Second); s.Inserts != 0 {
+		t.Errorf("Unexpected 30s statistics %+v", s)
+	}
+	if s := c.WindowedStatistics(5 * time.Minute); s.Period != 5*time.Minute || s.Inserts != 3 {
+		t.Errorf("Unexpected 5m statistics %+v", s)
+	}
+
+	c.ResetStatistics()
+	if s := c.WindowedStatistics(time.Hour); s.Inserts != 0 {
+		t.Errorf("Windowed statistics not reset %+v", s)
+	}
+	if s := c.Statistics(); s.Inserts != 0 || s.Hits != 0 || s.Evictions != 0 {
+		t.Errorf("Statistics not reset %+v", s)
+	}
+}
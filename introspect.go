@@ -0,0 +1,109 @@
+package lfucache
+
+// Bucket describes one level in the frequency list.
+type Bucket struct {
+	Usage int   // Usage count of the items in the bucket
+	Len   int   // Number of items in the bucket
+	Bytes int64 // Estimated size of the items, with WithMemoryEstimate or WithMaxBytes
+}
+
+// KeyUsage is a key and its current usage count.
+type KeyUsage struct {
+	Key   interface{}
+	Usage int
+}
+
+// Histogram returns the frequency buckets in order of increasing usage. The
+// zero usage bucket is always included, even when empty. This walks all
+// items in the cache.
+func (c *Cache) Histogram() []Bucket {
+	if debug {
+		c.check()
+	}
+
+	var hist []Bucket
+	for fn := c.frequencyList; fn != nil; fn = fn.next {
+		b := Bucket{Usage: fn.usage}
+		for n := fn.head; n != nil; n = n.next {
+			b.Len++
+			b.Bytes += n.size
+		}
+		hist = append(hist, b)
+	}
+	return hist
+}
+
+// Hottest returns up to k of the most frequently used keys, in order of
+// decreasing usage. Among keys with the same usage, the most recently
+// promoted come first. Keys in namespaces are returned without the
+// namespace.
+func (c *Cache) Hottest(k int) []KeyUsage {
+	if debug {
+		c.check()
+	}
+
+	last := c.frequencyList
+	for last.next != nil {
+		last = last.next
+	}
+
+	var hot []KeyUsage
+	for fn := last; fn != nil && len(hot) < k; fn = fn.prev {
+		for n := fn.tail; n != nil && len(hot) < k; n = n.prev {
+			hot = append(hot, KeyUsage{n.userKey(), fn.usage})
+		}
+	}
+	return hot
+}
+
+// Coldest returns up to k of the least frequently used keys, in order of
+// increasing usage. Among keys with the same usage, the least recently
+// promoted come first. Keys in namespaces are returned without the
+// namespace.
+func (c *Cache) Coldest(k int) []KeyUsage {
+	if debug {
+		c.check()
+	}
+
+	var cold []KeyUsage
+	for fn := c.frequencyList; fn != nil && len(cold) < k; fn = fn.next {
+		for n := fn.head; n != nil && len(cold) < k; n = n.next {
+			cold = append(cold, KeyUsage{n.userKey(), fn.usage})
+		}
+	}
+	return cold
+}
+
+// Range calls fn with the key, value and usage count of each item outside
+// of any namespace, in order of increasing usage. Among items with the same
+// usage, the least recently promoted come first. Range stops if fn returns
+// false. fn must not modify the cache. This walks all items in the cache.
+func (c *Cache) Range(fn func(key, value interface{}, usage int) bool) {
+	if debug {
+		c.check()
+	}
+
+	c.rangeItems(nil, fn)
+}
+
+// Range calls fn for each item in the namespace. See Cache.Range.
+func (ns *Namespace) Range(fn func(key, value interface{}, usage int) bool) {
+	c := ns.cache
+	if debug {
+		c.check()
+	}
+
+	c.rangeItems(ns, fn)
+}
+
+// rangeItems calls fn for the items in the namespace, or outside of any
+// namespace if ns is nil, until it returns false
+func (c *Cache) rangeItems(ns *Namespace, fn func(key, value interface{}, usage int) bool) {
+	for f := c.frequencyList; f != nil; f = f.next {
+		for n := f.head; n != nil; n = n.next {
+			if n.ns == ns && !fn(n.userKey(), n.value, f.usage) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,270 @@
+// Package invalidate keeps the caches of several processes consistent with
+// a backing store by broadcasting invalidations between them.
+//
+// Items are inserted with a version, such as a row version or modification
+// time from the database, and optionally a set of tags. When a value
+// changes, the process making the change calls Invalidate (or
+// InvalidateTag) with the new version. The invalidation is applied to the
+// local cache and published on an Invalidator, reaching the caches of the
+// other processes.
+//
+// An invalidation removes only items with a version older than its own, so
+// a newer value inserted before a delayed invalidation arrives is kept.
+// Invalidations are also remembered for a while, so that an older value
+// inserted after the invalidation arrived, for example by a slow reader,
+// is refused.
+//
+// Invalidators are provided for UDP multicast (NewMulticast), for any
+// io.ReadWriter stream such as a TCP connection (NewStream), and in memory
+// for tests (NewMemoryBus).
+package invalidate // import "github.com/calmh/deprecated_lfucache/invalidate"
+
+import (
+	"sync"
+
+	"github.com/calmh/deprecated_lfucache"
+)
+
+// DefaultTombstones is the number of invalidations remembered to refuse
+// inserts of older values, unless overridden by SetTombstones.
+const DefaultTombstones = 1024
+
+// Invalidation removes the item with a key, or the items with a tag, that
+// are older than the version.
+type Invalidation struct {
+	Tag     bool   // Name is a tag rather than a key
+	Name    string // The key or tag
+	Version uint64 // Items with an older version are removed
+}
+
+// Invalidator carries invalidations between processes.
+type Invalidator interface {
+	// Publish sends the invalidation to the subscribers of the other
+	// processes. It may or may not be delivered to local subscribers as
+	// well.
+	Publish(inv Invalidation) error
+
+	// Subscribe arranges for fn to be called with each invalidation
+	// received, until unsubscribe is called. fn may be called from any
+	// goroutine.
+	Subscribe(fn func(Invalidation)) (unsubscribe func())
+}
+
+// Cache is an LFU cache of versioned and tagged items, kept up to date by
+// the invalidations received from an Invalidator. It is safe for
+// concurrent use.
+type Cache struct {
+	mut         sync.Mutex
+	cache       *lfucache.Cache
+	tombstones  *lfucache.Cache
+	inv         Invalidator
+	unsubscribe func()
+	invalidated int
+	refused     int
+}
+
+// entry is the value stored in the underlying cache
+type entry struct {
+	key     string
+	value   interface{}
+	version uint64
+	tags    []string
+}
+
+// tombstone keys are kept apart from each other by type
+type (
+	keyTombstone string
+	tagTombstone string
+)
+
+// Statistics contains the statistics of the underlying cache and the
+// invalidation counters.
+type Statistics struct {
+	lfucache.Statistics
+	Invalidated int // Number of items removed by invalidations, also counted as Deletes
+	Refused     int // Number of Insert()s of items older than an invalidation or the cached item
+}
+
+// New returns a cache keeping its items in cache and applying the
+// invalidations received from inv. The cache must not be used directly
+// while in use by the returned Cache, other than under the lock returned by
+// Locker.
+func New(cache *lfucache.Cache, inv Invalidator) *Cache {
+	c := &Cache{
+		cache:      cache,
+		tombstones: lfucache.New(DefaultTombstones),
+		inv:        inv,
+	}
+	c.unsubscribe = inv.Subscribe(func(inv Invalidation) {
+		c.mut.Lock()
+		c.apply(inv)
+		c.mut.Unlock()
+	})
+	return c
+}
+
+// Close stops applying received invalidations.
+func (c *Cache) Close() {
+	c.unsubscribe()
+}
+
+// Locker returns the lock protecting the underlying cache, for use with
+// packages inspecting it such as metrics and debughttp.
+func (c *Cache) Locker() sync.Locker {
+	return &c.mut
+}
+
+// SetTombstones sets the number of invalidations remembered to refuse
+// inserts of older values. Returns lfucache.ErrInvalidCapacity if n is not
+// positive.
+func (c *Cache) SetTombstones(n int) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	return c.tombstones.Resize(n)
+}
+
+// Insert inserts the value with the given version and tags, replacing any
+// existing item with the same key. Returns false, without inserting, if the
+// existing item or an invalidation of the key or any of the tags has a
+// newer version.
+func (c *Cache) Insert(key string, value interface{}, version uint64, tags ...string) bool {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if c.invalidatedAfter(keyTombstone(key), version) {
+		c.refused++
+		return false
+	}
+	for _, tag := range tags {
+		if c.invalidatedAfter(tagTombstone(tag), version) {
+			c.refused++
+			return false
+		}
+	}
+
+	if v, ok := c.cache.Peek(key); ok && v.(*entry).version > version {
+		c.refused++
+		return false
+	}
+
+	c.cache.Insert(key, &entry{key: key, value: value, version: version, tags: tags})
+	return true
+}
+
+// Access returns the value for the key. See lfucache.Cache.Access.
+func (c *Cache) Access(key string) (interface{}, bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	v, ok := c.cache.Access(key)
+	if !ok {
+		return nil, false
+	}
+	return v.(*entry).value, true
+}
+
+// Version returns the version of the item for the key.
+func (c *Cache) Version(key string) (uint64, bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	v, ok := c.cache.Peek(key)
+	if !ok {
+		return 0, false
+	}
+	return v.(*entry).version, true
+}
+
+// Delete deletes the item with the key locally, without publishing an
+// invalidation. See lfucache.Cache.Delete.
+func (c *Cache) Delete(key string) bool {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	return c.cache.Delete(key)
+}
+
+// Invalidate removes the item with the key if it is older than version, in
+// this cache and, by publishing the invalidation, in all others.
+func (c *Cache) Invalidate(key string, version uint64) error {
+	return c.invalidate(Invalidation{Name: key, Version: version})
+}
+
+// InvalidateTag removes the items with the tag that are older than
+// version, in this cache and, by publishing the invalidation, in all
+// others. It walks the entire cache.
+func (c *Cache) InvalidateTag(tag string, version uint64) error {
+	return c.invalidate(Invalidation{Tag: true, Name: tag, Version: version})
+}
+
+// Statistics returns the cache statistics.
+func (c *Cache) Statistics() Statistics {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	return Statistics{
+		Statistics:  c.cache.Statistics(),
+		Invalidated: c.invalidated,
+		Refused:     c.refused,
+	}
+}
+
+func (c *Cache) invalidate(inv Invalidation) error {
+	c.mut.Lock()
+	c.apply(inv)
+	c.mut.Unlock()
+
+	return c.inv.Publish(inv)
+}
+
+// apply removes the items invalidated and remembers the invalidation
+func (c *Cache) apply(inv Invalidation) {
+	if !inv.Tag {
+		c.tombstone(keyTombstone(inv.Name), inv.Version)
+		if v, ok := c.cache.Peek(inv.Name); ok && v.(*entry).version < inv.Version {
+			c.cache.Delete(inv.Name)
+			c.invalidated++
+		}
+		return
+	}
+
+	c.tombstone(tagTombstone(inv.Name), inv.Version)
+	var keys []string
+	c.cache.Range(func(_, v interface{}, _ int) bool {
+		e := v.(*entry)
+		if e.version < inv.Version && hasTag(e.tags, inv.Name) {
+			keys = append(keys, e.key)
+		}
+		return true
+	})
+	for _, key := range keys {
+		c.cache.Delete(key)
+	}
+	c.invalidated += len(keys)
+}
+
+// tombstone remembers the invalidation of the key or tag at the version,
+// unless a newer one is already remembered
+func (c *Cache) tombstone(key interface{}, version uint64) {
+	if c.invalidatedAfter(key, version) {
+		return
+	}
+	c.tombstones.Insert(key, version)
+}
+
+// invalidatedAfter returns true if the key or tag has been invalidated at
+// a version newer than version
+func (c *Cache) invalidatedAfter(key interface{}, version uint64) bool {
+	v, ok := c.tombstones.Peek(key)
+	return ok && v.(uint64) > version
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package invalidate_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/calmh/deprecated_lfucache"
+	"github.com/calmh/deprecated_lfucache/invalidate"
+)
+
+func TestInvalidateKey(t *testing.T) {
+	bus := invalidate.NewMemoryBus()
+	c1 := invalidate.New(lfucache.New(10), bus)
+	c2 := invalidate.New(lfucache.New(10), bus)
+
+	c1.Insert("test1", "a", 1)
+	c2.Insert("test1", "a", 1)
+	c2.Insert("test2", "b", 1)
+
+	if err := c1.Invalidate("test1", 2); err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range []*invalidate.Cache{c1, c2} {
+		if _, ok := c.Access("test1"); ok {
+			t.Errorf("test1 not invalidated in cache %d", i)
+		}
+	}
+	if _, ok := c2.Access("test2"); !ok {
+		t.Error("test2 invalidated")
+	}
+
+	// An older value inserted after the invalidation is refused, a newer
+	// value survives a delayed older invalidation
+	if c2.Insert("test1", "a", 1) {
+		t.Error("Insert of stale value succeeded")
+	}
+	if !c2.Insert("test1", "c", 3) {
+		t.Error("Insert of new value failed")
+	}
+	c1.Invalidate("test1", 2)
+	if v, ok := c2.Access("test1"); !ok || v != "c" {
+		t.Errorf("Newer value removed by older invalidation, %v", v)
+	}
+	if ver, _ := c2.Version("test1"); ver != 3 {
+		t.Errorf("Unexpected version %d", ver)
+	}
+
+	// An older value does not replace a newer one
+	if c2.Insert("test1", "d", 2) {
+		t.Error("Insert of value older than the cached one succeeded")
+	}
+	if v, _ := c2.Access("test1"); v != "c" {
+		t.Errorf("Newer value replaced by %v", v)
+	}
+
+	if s := c2.Statistics(); s.Invalidated != 1 || s.Refused != 2 || s.Deletes != 1 {
+		t.Errorf("Unexpected statistics %+v", s)
+	}
+
+	c2.Close()
+	c1.Invalidate("test1", 4)
+	if _, ok := c2.Access("test1"); !ok {
+		t.Error("Invalidation applied after Close")
+	}
+}
+
+func TestInvalidateTag(t *testing.T) {
+	bus := invalidate.NewMemoryBus()
+	c1 := invalidate.New(lfucache.New(10), bus)
+	c2 := invalidate.New(lfucache.New(10), bus)
+
+	c2.Insert("user1", "a", 1, "users", "admins")
+	c2.Insert("user2", "b", 1, "users")
+	c2.Insert("user3", "c", 5, "users")
+	c2.Insert("group1", "d", 1, "groups")
+
+	c1.InvalidateTag("users", 2)
+	for _, key := range []string{"user1", "user2"} {
+		if _, ok := c2.Access(key); ok {
+			t.Errorf("%s not invalidated", key)
+		}
+	}
+	for _, key := range []string{"user3", "group1"} {
+		if _, ok := c2.Access(key); !ok {
+			t.Errorf("%s invalidated", key)
+		}
+	}
+
+	if c2.Insert("user4", "e", 1, "users") {
+		t.Error("Insert of stale tagged value succeeded")
+	}
+	if !c2.Insert("user4", "e", 1, "others") {
+		t.Error("Insert of untagged value failed")
+	}
+}
+
+func TestTombstones(t *testing.T) {
+	c := invalidate.New(lfucache.New(10), invalidate.NewMemoryBus())
+	if err := c.SetTombstones(0); err != lfucache.ErrInvalidCapacity {
+		t.Errorf("Unexpected error %v", err)
+	}
+	c.SetTombstones(1)
+
+	c.Invalidate("test1", 2)
+	c.Invalidate("test2", 2)
+	if !c.Insert("test1", "a", 1) {
+		t.Error("Forgotten invalidation refused insert")
+	}
+	if c.Insert("test2", "a", 1) {
+		t.Error("Remembered invalidation did not refuse insert")
+	}
+}
+
+func TestStream(t *testing.T) {
+	a, b := net.Pipe()
+	sa := invalidate.NewStream(a)
+	sb := invalidate.NewStream(b)
+
+	received := make(chan invalidate.Invalidation, 2)
+	sb.Subscribe(func(inv invalidate.Invalidation) { received <- inv })
+
+	invs := []invalidate.Invalidation{
+		{Name: "test1", Version: 1},
+		{Tag: true, Name: "users", Version: 1 << 40},
+	}
+	for _, inv := range invs {
+		if err := sa.Publish(inv); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, inv := range invs {
+		if got := <-received; got != inv {
+			t.Errorf("Received %+v, expected %+v", got, inv)
+		}
+	}
+
+	// Longer than fits in a UDP datagram with the header
+	long := make([]byte, 65507-11+1)
+	if err := sa.Publish(invalidate.Invalidation{Name: string(long)}); err != invalidate.ErrNameTooLong {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	sa.Close()
+	if err := sb.Wait(); err == nil {
+		t.Error("Unexpected nil error")
+	}
+}
+
+func TestStreamCaches(t *testing.T) {
+	a, b := net.Pipe()
+	c1 := invalidate.New(lfucache.New(10), invalidate.NewStream(a))
+	c2 := invalidate.New(lfucache.New(10), invalidate.NewStream(b))
+
+	c2.Insert("test1", "a", 1)
+	c1.Invalidate("test1", 2)
+
+	// The invalidation is applied asynchronously on the receiving end
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := c2.Access("test1"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("test1 not invalidated")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMulticast(t *testing.T) {
+	group := &net.UDPAddr{IP: net.IPv4(239, 255, 76, 70), Port: 21027 + int(time.Now().UnixNano()%1000)}
+	m1, err := invalidate.NewMulticast(nil, group)
+	if err != nil {
+		t.Skip("multicast unavailable:", err)
+	}
+	defer m1.Close()
+	m2, err := invalidate.NewMulticast(nil, group)
+	if err != nil {
+		t.Skip("multicast unavailable:", err)
+	}
+	defer m2.Close()
+
+	received := make(chan invalidate.Invalidation, 1)
+	m2.Subscribe(func(inv invalidate.Invalidation) {
+		select {
+		case received <- inv:
+		default:
+		}
+	})
+
+	inv := invalidate.Invalidation{Name: "test1", Version: 1}
+	if err := m1.Publish(inv); err != nil {
+		t.Skip("multicast unavailable:", err)
+	}
+	select {
+	case got := <-received:
+		if got != inv {
+			t.Errorf("Received %+v, expected %+v", got, inv)
+		}
+	case <-time.After(time.Second):
+		t.Skip("multicast not delivered")
+	}
+}
@@ -0,0 +1,64 @@
+package invalidate
+
+import (
+	"sync"
+)
+
+// MemoryBus is an Invalidator within a single process, for tests. Any
+// number of caches may share a bus, and invalidations published by one are
+// delivered synchronously to all subscribers, including the publisher's
+// own.
+type MemoryBus struct {
+	subscribers
+}
+
+// NewMemoryBus returns an empty bus.
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{}
+}
+
+// Publish delivers the invalidation to all subscribers before returning.
+func (b *MemoryBus) Publish(inv Invalidation) error {
+	b.deliver(inv)
+	return nil
+}
+
+// subscribers is the set of subscribers of an Invalidator
+type subscribers struct {
+	mut  sync.Mutex
+	next int
+	fns  map[int]func(Invalidation)
+}
+
+// Subscribe implements Invalidator.
+func (s *subscribers) Subscribe(fn func(Invalidation)) func() {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.fns == nil {
+		s.fns = make(map[int]func(Invalidation))
+	}
+	id := s.next
+	s.next++
+	s.fns[id] = fn
+
+	return func() {
+		s.mut.Lock()
+		delete(s.fns, id)
+		s.mut.Unlock()
+	}
+}
+
+// deliver calls all subscribers with the invalidation
+func (s *subscribers) deliver(inv Invalidation) {
+	s.mut.Lock()
+	fns := make([]func(Invalidation), 0, len(s.fns))
+	for _, fn := range s.fns {
+		fns = append(fns, fn)
+	}
+	s.mut.Unlock()
+
+	for _, fn := range fns {
+		fn(inv)
+	}
+}
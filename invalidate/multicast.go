@@ -0,0 +1,77 @@
+package invalidate
+
+import (
+	"net"
+)
+
+// maxDatagram is the largest datagram read, enough for any invalidation
+const maxDatagram = headerLen + maxNameLen
+
+// Multicast is an Invalidator over UDP multicast, delivering invalidations
+// to every process in the multicast group. Delivery is unreliable; lost
+// datagrams are not retransmitted. Invalidations are usually delivered to
+// the publisher's own subscribers as well. Malformed datagrams are
+// ignored.
+type Multicast struct {
+	subscribers
+	recv *net.UDPConn
+	send *net.UDPConn
+	done chan struct{}
+}
+
+// NewMulticast joins the multicast group address on the interface, or on
+// the system default interface if ifi is nil, and starts reading
+// invalidations.
+func NewMulticast(ifi *net.Interface, group *net.UDPAddr) (*Multicast, error) {
+	recv, err := net.ListenMulticastUDP("udp", ifi, group)
+	if err != nil {
+		return nil, err
+	}
+	send, err := net.DialUDP("udp", nil, group)
+	if err != nil {
+		recv.Close()
+		return nil, err
+	}
+
+	m := &Multicast{
+		recv: recv,
+		send: send,
+		done: make(chan struct{}),
+	}
+	go m.read()
+	return m, nil
+}
+
+// Publish sends the invalidation to the group.
+func (m *Multicast) Publish(inv Invalidation) error {
+	buf, err := appendInvalidation(nil, inv)
+	if err != nil {
+		return err
+	}
+	_, err = m.send.Write(buf)
+	return err
+}
+
+// Close leaves the group and waits for reading to stop.
+func (m *Multicast) Close() error {
+	err := m.recv.Close()
+	if serr := m.send.Close(); err == nil {
+		err = serr
+	}
+	<-m.done
+	return err
+}
+
+func (m *Multicast) read() {
+	defer close(m.done)
+	buf := make([]byte, maxDatagram)
+	for {
+		n, _, err := m.recv.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if inv, err := parseInvalidation(buf[:n]); err == nil {
+			m.deliver(inv)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package invalidate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// maxUDPPayload is the largest payload of a UDP datagram over IPv4
+const maxUDPPayload = 65507
+
+// maxNameLen is the longest key or tag that can be encoded, such that an
+// invalidation fits in a UDP datagram
+const maxNameLen = maxUDPPayload - headerLen
+
+// headerLen is the length of an encoded invalidation without the name:
+// kind, version and name length
+const headerLen = 1 + 8 + 2
+
+const (
+	kindKey byte = 'k'
+	kindTag byte = 't'
+)
+
+// Errors returned when encoding or decoding invalidations.
+var (
+	ErrNameTooLong      = errors.New("key or tag too long")
+	ErrInvalidEncoding  = errors.New("invalid invalidation encoding")
+	errTrailingDatagram = errors.New("trailing data in datagram")
+)
+
+// Stream is an Invalidator over a stream connecting two processes, such
+// as a TCP connection. Invalidations published on one end are delivered to
+// the subscribers of the other end.
+type Stream struct {
+	subscribers
+	rw      io.ReadWriter
+	wmut    sync.Mutex
+	done    chan struct{}
+	readErr error
+}
+
+// NewStream returns an invalidator over the stream and starts reading
+// invalidations from it.
+func NewStream(rw io.ReadWriter) *Stream {
+	s := &Stream{
+		rw:   rw,
+		done: make(chan struct{}),
+	}
+	go s.read()
+	return s
+}
+
+// Publish writes the invalidation to the stream.
+func (s *Stream) Publish(inv Invalidation) error {
+	buf, err := appendInvalidation(nil, inv)
+	if err != nil {
+		return err
+	}
+
+	s.wmut.Lock()
+	defer s.wmut.Unlock()
+	_, err = s.rw.Write(buf)
+	return err
+}
+
+// Wait waits for reading to stop and returns the reason, io.EOF if the
+// other end closed the stream.
+func (s *Stream) Wait() error {
+	<-s.done
+	return s.readErr
+}
+
+// Close closes the stream, if it is an io.Closer.
+func (s *Stream) Close() error {
+	if c, ok := s.rw.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (s *Stream) read() {
+	defer close(s.done)
+	for {
+		inv, err := readInvalidation(s.rw)
+		if err != nil {
+			s.readErr = err
+			return
+		}
+		s.deliver(inv)
+	}
+}
+
+// appendInvalidation appends the encoded invalidation to buf
+func appendInvalidation(buf []byte, inv Invalidation) ([]byte, error) {
+	if len(inv.Name) > maxNameLen {
+		return nil, ErrNameTooLong
+	}
+	kind := kindKey
+	if inv.Tag {
+		kind = kindTag
+	}
+	buf = append(buf, kind)
+	buf = binary.BigEndian.AppendUint64(buf, inv.Version)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(inv.Name)))
+	return append(buf, inv.Name...), nil
+}
+
+// readInvalidation reads one encoded invalidation from r
+func readInvalidation(r io.Reader) (Invalidation, error) {
+	var hdr [headerLen]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return Invalidation{}, err
+	}
+	if hdr[0] != kindKey && hdr[0] != kindTag {
+		return Invalidation{}, ErrInvalidEncoding
+	}
+	name := make([]byte, binary.BigEndian.Uint16(hdr[9:]))
+	if _, err := io.ReadFull(r, name); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Invalidation{}, err
+	}
+	return Invalidation{
+		Tag:     hdr[0] == kindTag,
+		Name:    string(name),
+		Version: binary.BigEndian.Uint64(hdr[1:]),
+	}, nil
+}
+
+// parseInvalidation decodes a datagram holding exactly one invalidation
+func parseInvalidation(bs []byte) (Invalidation, error) {
+	r := bytes.NewReader(bs)
+	inv, err := readInvalidation(r)
+	if err != nil {
+		return Invalidation{}, err
+	}
+	if r.Len() != 0 {
+		return Invalidation{}, errTrailingDatagram
+	}
+	return inv, nil
+}
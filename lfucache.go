@@ -1,7 +1,9 @@
 package lfucache // import "github.com/calmh/deprecated_lfucache"
 
 import (
-	"errors"
+	"io"
+	"math/rand"
+	"time"
 )
 
 // Cache is an LFU cache structure.
@@ -10,8 +12,32 @@ type Cache struct {
 	length        int
 	frequencyList *frequencyNode
 	index         map[interface{}]*node
+	hasher        Hasher
+	hashIndex     map[uint64]*node // used instead of index with a hasher
+	hashLen       int
 	evictedChans  []chan<- interface{}
+	droppingChans []chan<- interface{}
 	stats         Statistics
+	namespaces    map[string]*Namespace
+	dumpOpts      *DumpOptions
+	debugOutput   io.Writer
+	windows       *windows
+	observer      Observer
+	policy        Policy
+	selector      Selector // the policy, if it is one
+	tieBreak      TieBreak
+	tieBreakSet   bool
+	cost          CostFunc
+	rand          *rand.Rand
+	estimate      bool  // estimate the memory used by items
+	maxBytes      int64 // limit of bytes, or zero
+	maxBytesSet   bool
+	bytes         int64 // estimated size of the items
+
+	freeNodes             *node
+	numFreeNodes          int
+	freeFrequencyNodes    *frequencyNode
+	numFreeFrequencyNodes int
 }
 
 // Statistics contains current item counts and operation counters.
@@ -23,6 +49,36 @@ type Statistics struct {
 	Evictions   int // Number of evictions (due to size constraints on Insert(), or EvictIf() calls)
 	Deletes     int // Number of Delete()s.
 	FreqListLen int // Current length of frequency list, i.e. the number of distinct usage levels
+	Dropped     int // Number of eviction notifications dropped on full EvictionsNonBlocking() channels
+	Rejected    int // Number of Insert()s refused for items larger than the WithMaxBytes limit
+
+	// Estimated memory use, with WithMemoryEstimate or WithMaxBytes
+	Bytes         int64 // Estimated bytes used by the items and the bookkeeping
+	OverheadBytes int64 // The part of Bytes used by nodes, index entries, the frequency list and freelists
+
+	EvictionsByReason [numEvictReasons]int // Evictions, broken down by EvictReason
+}
+
+// EvictReason is the cause of an eviction.
+type EvictReason int
+
+const (
+	EvictCapacity EvictReason = iota // Making room for an Insert()
+	EvictReplace                     // Replaced by an Insert() of the same key
+	EvictResize                      // Shrinking by Resize()
+	EvictQuota                       // Making room within a namespace maximum quota
+	EvictManual                      // Matched by EvictIf()
+	EvictMemory                      // Making room within the WithMaxBytes limit, or in a BytesCache arena
+	numEvictReasons
+)
+
+var evictReasonNames = [numEvictReasons]string{"capacity", "replace", "resize", "quota", "manual", "memory"}
+
+func (r EvictReason) String() string {
+	if r < 0 || r >= numEvictReasons {
+		return "unknown"
+	}
+	return evictReasonNames[r]
 }
 
 // The "frequencyNode" and "node" types make up the two levels of linked lists
@@ -48,60 +104,185 @@ type node struct {
 	parent *frequencyNode
 	next   *node
 	prev   *node
+	ns     *Namespace
+	hash   uint64 // with a hasher, the hash of the key
+	chain  *node  // with a hasher, the next node with the same hash
+	size   int64  // with memory estimation, the estimated size of the item
 }
 
-var (
-	errZeroSizeCache = errors.New("create zero-sized cache")
-	errEmptyLFU      = errors.New("lfu on empty cache")
-)
-
-// New initializes a new LFU Cache structure with the specified capacity.
-func New(capacity int) *Cache {
-	if capacity == 0 {
-		panic(errZeroSizeCache)
+// New initializes a new LFU Cache structure with the specified capacity and
+// options. It panics with one of the exported errors if the capacity is not
+// positive or an option is invalid; NewWithOptions returns the error
+// instead.
+func New(capacity int, opts ...Option) *Cache {
+	c, err := NewWithOptions(append([]Option{WithCapacity(capacity)}, opts...)...)
+	if err != nil {
+		panic(err)
 	}
+	return c
+}
 
-	return &Cache{
-		capacity:      capacity,
-		index:         make(map[interface{}]*node, capacity),
+// NewWithOptions initializes a new LFU Cache structure configured by the
+// options, which must include WithCapacity. Returns ErrInvalidCapacity,
+// ErrNilPolicy, ErrInvalidTieBreak, ErrNilChannel or ErrInvalidMaxBytes if
+// the configuration is invalid.
+func NewWithOptions(opts ...Option) (*Cache, error) {
+	c := &Cache{
 		frequencyList: &frequencyNode{},
+		policy:        LFU,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if err := c.validateOptions(); err != nil {
+		return nil, err
 	}
+
+	if c.hasher != nil {
+		c.hashIndex = make(map[uint64]*node, c.capacity)
+	} else {
+		c.index = make(map[interface{}]*node, c.capacity)
+	}
+	c.initPolicy()
+	return c, nil
 }
 
 // Resize the cache to a new capacity. When shrinking, items may get evicted.
-func (c *Cache) Resize(capacity int) {
+// Returns ErrInvalidCapacity, leaving the cache unchanged, if the capacity
+// is not positive.
+func (c *Cache) Resize(capacity int) error {
+	if capacity <= 0 {
+		return ErrInvalidCapacity
+	}
+
+	var start time.Time
+	if c.observer != nil {
+		start = time.Now()
+	}
+
+	old := c.capacity
 	c.capacity = capacity
 	for c.length > c.capacity {
-		c.evict(c.lfu())
+		c.evict(c.victim(), EvictResize)
+	}
+	c.trimFreelists()
+
+	if c.observer != nil {
+		c.observer.OnResize(ResizeEvent{old, capacity, time.Since(start)})
 	}
+	return nil
 }
 
 // Insert inserts an item into the cache. If the key already exists, the
 // existing item is evicted and the new one inserted. The key type is
 // restricted to those acceptable as map keys
-// (http://golang.org/ref/spec#Map_types).
+// (http://golang.org/ref/spec#Map_types). With WithMaxBytes, an item
+// larger than the limit by itself is not inserted and counted as Rejected.
+// Any existing item with the key is deleted, as it would otherwise be
+// returned in place of the newer value.
 func (c *Cache) Insert(key interface{}, value interface{}) {
 	if debug {
 		c.check()
 	}
 
-	if n, ok := c.index[key]; ok {
-		c.evict(n)
+	c.insert(nil, key, value, 0)
+
+	if debug {
+		c.check()
+	}
+}
+
+// InsertWithUsage inserts an item like Insert, but with the given use count
+// instead of zero, as when restoring an item previously evicted from the
+// cache. Finding the position of the item takes time proportional to the
+// number of distinct use counts below it. Returns ErrInvalidUsage, without
+// inserting the item, if usage is negative, and ErrItemTooLarge if the item
+// is rejected by the WithMaxBytes limit.
+func (c *Cache) InsertWithUsage(key interface{}, value interface{}, usage int) error {
+	if usage < 0 {
+		return ErrInvalidUsage
+	}
+	if debug {
+		c.check()
+	}
+
+	ok := c.insert(nil, key, value, usage)
+
+	if debug {
+		c.check()
+	}
+	if !ok {
+		return ErrItemTooLarge
+	}
+	return nil
+}
+
+// insert inserts an item owned by the namespace ns (nil for the root key
+// space) under the given index key, with the given use count. Returns
+// false, deleting any existing item with the key, if the item is larger
+// than the memory limit.
+func (c *Cache) insert(ns *Namespace, key interface{}, value interface{}, usage int) bool {
+	var start time.Time
+	if c.observer != nil {
+		start = time.Now()
+	}
+
+	var size int64
+	if c.estimate {
+		size = c.itemSize(key, value)
+		if c.maxBytes > 0 && size > c.maxBytes {
+			c.delete(key)
+			c.stats.Rejected++
+			if ns != nil {
+				ns.stats.Rejected++
+			}
+			return false
+		}
+	}
+
+	if n, ok := c.lookup(key); ok {
+		c.evict(n, EvictReplace)
+	}
+
+	if ns != nil && ns.max > 0 && ns.length >= ns.max {
+		c.evict(c.lfuWhere(ns.owns), EvictQuota)
 	}
 
 	if c.length == c.capacity {
-		c.evict(c.lfu())
+		c.evict(c.victim(), EvictCapacity)
 	}
 
-	n := &node{key: key, value: value}
-	c.index[key] = n
-	c.moveNodeToFn(n, c.frequencyList)
+	if c.maxBytes > 0 {
+		c.makeRoom(size)
+	}
+
+	n := c.allocNode()
+	n.key = key
+	n.value = value
+	n.ns = ns
+	n.size = size
+	c.addToIndex(n)
+	c.moveNodeToFn(n, c.frequencyNodeFor(usage, c.frequencyList))
+	if c.selector != nil {
+		c.selector.Added(Entry{n})
+	}
 	c.length++
+	c.bytes += size
 	c.stats.Inserts++
+	if c.windows != nil {
+		c.windows.add(windowInserts)
+	}
+	if ns != nil {
+		ns.length++
+		ns.stats.Inserts++
+	}
 
-	if debug {
-		c.check()
+	if c.observer != nil {
+		e := n.event()
+		e.Duration = time.Since(start)
+		c.observer.OnInsert(e)
 	}
+	return true
 }
 
 // Delete deletes an item from the cache and returns true. Does nothing and
@@ -111,11 +292,7 @@ func (c *Cache) Delete(key interface{}) bool {
 		c.check()
 	}
 
-	n, ok := c.index[key]
-	if ok {
-		c.deleteNode(n)
-		c.stats.Deletes++
-	}
+	ok := c.delete(key)
 
 	if debug {
 		c.check()
@@ -124,6 +301,37 @@ func (c *Cache) Delete(key interface{}) bool {
 	return ok
 }
 
+// delete deletes the item with the given index key, if present.
+func (c *Cache) delete(key interface{}) bool {
+	var start time.Time
+	if c.observer != nil {
+		start = time.Now()
+	}
+
+	n, ok := c.lookup(key)
+	if !ok {
+		return false
+	}
+
+	var e Event
+	if c.observer != nil {
+		e = n.event()
+		e.UsageAfter = 0
+	}
+
+	if n.ns != nil {
+		n.ns.stats.Deletes++
+	}
+	c.deleteNode(n)
+	c.stats.Deletes++
+
+	if c.observer != nil {
+		e.Duration = time.Since(start)
+		c.observer.OnDelete(e)
+	}
+	return true
+}
+
 // Access an item in the cache. Returns "value, ok" similar to map indexing.
 // Increases the item's use count.
 func (c *Cache) Access(key interface{}) (interface{}, bool) {
@@ -131,30 +339,87 @@ func (c *Cache) Access(key interface{}) (interface{}, bool) {
 		c.check()
 	}
 
-	n, ok := c.index[key]
+	v, ok := c.access(nil, key)
+
+	if debug {
+		c.check()
+	}
+
+	return v, ok
+}
+
+// access looks up the item with the given index key on behalf of the
+// namespace ns (nil for the root key space) and increases its use count.
+func (c *Cache) access(ns *Namespace, key interface{}) (interface{}, bool) {
+	var start time.Time
+	if c.observer != nil {
+		start = time.Now()
+	}
+
+	n, ok := c.lookup(key)
 	if !ok {
 		c.stats.Misses++
+		if c.windows != nil {
+			c.windows.add(windowMisses)
+		}
+		if ns != nil {
+			ns.stats.Misses++
+		}
+		if c.observer != nil {
+			e := missEvent(ns, key)
+			e.Duration = time.Since(start)
+			c.observer.OnMiss(e)
+		}
 		return nil, false
 	}
 
-	nextUsage := n.parent.usage + 1
-	var nextFn *frequencyNode
-	if n.parent.next == nil || n.parent.next.usage != nextUsage {
-		nextFn = c.newFrequencyNode(nextUsage, n.parent)
-	} else {
-		nextFn = n.parent.next
+	usage := n.parent.usage
+	promoted := c.policy.Promote(usage)
+	if promoted < 0 {
+		promoted = 0
+	}
+	c.moveNodeToFn(n, c.frequencyNodeFor(promoted, n.parent))
+	if c.selector != nil {
+		c.selector.Accessed(Entry{n})
 	}
-
-	c.moveNodeToFn(n, nextFn)
 	c.stats.Hits++
+	if c.windows != nil {
+		c.windows.add(windowHits)
+	}
+	if ns != nil {
+		ns.stats.Hits++
+	}
 
-	if debug {
-		c.check()
+	if c.observer != nil {
+		e := n.event()
+		e.UsageBefore = usage
+		e.Duration = time.Since(start)
+		c.observer.OnHit(e)
 	}
 
 	return n.value, true
 }
 
+// Peek returns the value of an item like Access, but without increasing
+// its use count or counting a hit or miss.
+func (c *Cache) Peek(key interface{}) (interface{}, bool) {
+	n, ok := c.lookup(key)
+	if !ok {
+		return nil, false
+	}
+	return n.value, true
+}
+
+// Usage returns the use count of an item, i.e. the level of the frequency
+// list it is at, without affecting it.
+func (c *Cache) Usage(key interface{}) (int, bool) {
+	n, ok := c.lookup(key)
+	if !ok {
+		return 0, false
+	}
+	return n.parent.usage, true
+}
+
 // Len returns the number of items currently stored in the cache.
 func (c *Cache) Len() int {
 	return c.length
@@ -173,6 +438,9 @@ func (c *Cache) Statistics() Statistics {
 
 	c.stats.LenFreq0 = c.items0()
 	c.stats.FreqListLen = c.numFrequencyNodes()
+	if c.estimate {
+		c.memoryStatistics(&c.stats, c.stats.FreqListLen)
+	}
 	return c.stats
 }
 
@@ -189,6 +457,19 @@ func (c *Cache) Evictions(e chan<- interface{}) {
 	c.evictedChans = append(c.evictedChans, e)
 }
 
+// EvictionsNonBlocking registers a channel used to report evicted items,
+// like Evictions, except that the eviction does not wait for the channel.
+// When the channel buffer is full the item is not sent and the Dropped
+// statistic is increased instead. This suits consumers that may fall
+// behind, such as network clients.
+func (c *Cache) EvictionsNonBlocking(e chan<- interface{}) {
+	if debug {
+		c.check()
+	}
+
+	c.droppingChans = append(c.droppingChans, e)
+}
+
 // UnregisterEvictions removes the channel from the list of channels to be
 // notified on item eviction. Must be called when there is no longer a reader
 // for the channel in question.
@@ -197,21 +478,29 @@ func (c *Cache) UnregisterEvictions(e chan<- interface{}) {
 		c.check()
 	}
 
+	c.evictedChans = removeChan(c.evictedChans, e)
+	c.droppingChans = removeChan(c.droppingChans, e)
+}
+
+// removeChan removes e from the list of eviction channels, if present
+func removeChan(chans []chan<- interface{}, e chan<- interface{}) []chan<- interface{} {
 	var i int
 	var found bool
 
-	for i = range c.evictedChans {
-		if c.evictedChans[i] == e {
+	for i = range chans {
+		if chans[i] == e {
 			found = true
 			break
 		}
 	}
 
 	if found {
-		copy(c.evictedChans[i:], c.evictedChans[i+1:])
-		c.evictedChans[len(c.evictedChans)-1] = nil
-		c.evictedChans = c.evictedChans[:len(c.evictedChans)-1]
+		copy(chans[i:], chans[i+1:])
+		chans[len(chans)-1] = nil
+		chans = chans[:len(chans)-1]
 	}
+
+	return chans
 }
 
 // EvictIf applies test to each item in the cache and evicts it if the test
@@ -221,13 +510,7 @@ func (c *Cache) EvictIf(test func(interface{}) bool) int {
 		c.check()
 	}
 
-	cnt := 0
-	for _, n := range c.index {
-		if test(n.value) {
-			c.evict(n)
-			cnt++
-		}
-	}
+	cnt := c.evictIf(nil, test)
 
 	if debug {
 		c.check()
@@ -236,14 +519,56 @@ func (c *Cache) EvictIf(test func(interface{}) bool) int {
 	return cnt
 }
 
-// evict evicts a node from the cache by removing it from the structure and
-// notifying any interested eviction listeners
-func (c *Cache) evict(n *node) {
+// evictIf evicts the items passing test. When ns is non-nil, only items
+// owned by that namespace are considered.
+func (c *Cache) evictIf(ns *Namespace, test func(interface{}) bool) int {
+	cnt := 0
+	c.forEachNode(func(n *node) {
+		if ns != nil && n.ns != ns {
+			return
+		}
+		if test(n.value) {
+			c.evict(n, EvictManual)
+			cnt++
+		}
+	})
+	return cnt
+}
+
+// evict evicts a node from the cache for the given reason, by removing it
+// from the structure and notifying any interested eviction listeners
+func (c *Cache) evict(n *node, reason EvictReason) {
+	var e Event
+	if c.observer != nil {
+		e = n.event()
+		e.UsageAfter = 0
+		e.Reason = reason
+	}
+
 	for i := range c.evictedChans {
 		c.evictedChans[i] <- n.value
 	}
+	for i := range c.droppingChans {
+		select {
+		case c.droppingChans[i] <- n.value:
+		default:
+			c.stats.Dropped++
+		}
+	}
+	if n.ns != nil {
+		n.ns.stats.Evictions++
+		n.ns.stats.EvictionsByReason[reason]++
+	}
 	c.deleteNode(n)
 	c.stats.Evictions++
+	c.stats.EvictionsByReason[reason]++
+	if c.windows != nil {
+		c.windows.add(windowEvictions)
+	}
+
+	if c.observer != nil {
+		c.observer.OnEvict(e)
+	}
 }
 
 // deleteNode deletes a node from the cache, also deleting the frequency node
@@ -269,29 +594,86 @@ func (c *Cache) deleteNode(n *node) {
 		c.deleteFrequencyNode(fn)
 	}
 
-	delete(c.index, n.key)
+	if c.selector != nil {
+		c.selector.Removed(Entry{n})
+	}
+	c.removeFromIndex(n)
 	c.length--
+	c.bytes -= n.size
+	if n.ns != nil {
+		n.ns.length--
+	}
+	c.releaseNode(n)
 }
 
-// lfu returns the least frequently used node in the cache, prefering the
-// oldest if there are multiple nodes with the same lowest usage count
+// lfu returns the least frequently used node in the cache, using the tie
+// break of the policy if there are multiple nodes with the same lowest
+// usage count, or nil if the cache is empty
 func (c *Cache) lfu() *node {
 	for fn := c.frequencyList; fn != nil; fn = fn.next {
-		if fn.head != nil {
-			return fn.head
+		if n := c.pick(fn); n != nil {
+			return n
 		}
 	}
+	return nil
+}
 
-	panic(errEmptyLFU)
+// lfuWhere returns the least frequently used node for which test returns
+// true, or nil if there is no such node. Unlike lfu this is not O(1) as it
+// may need to skip over any number of nodes.
+func (c *Cache) lfuWhere(test func(*node) bool) *node {
+	for fn := c.frequencyList; fn != nil; fn = fn.next {
+		// Only the newest tie break changes the order of the search, the
+		// sampling ones start from the oldest node like the default.
+		if c.tieBreak == TieBreakNewest {
+			for n := fn.tail; n != nil; n = n.prev {
+				if test(n) {
+					return n
+				}
+			}
+			continue
+		}
+		for n := fn.head; n != nil; n = n.next {
+			if test(n) {
+				return n
+			}
+		}
+	}
+	return nil
+}
+
+// victim returns the node to evict to make room for a new one. This is the
+// lfu node, or the choice of the Selector, unless that would bring its
+// namespace below the minimum quota, in which case the least frequently
+// used unprotected node is chosen. When every node is protected we fall
+// back to that node regardless. Returns nil if the cache is empty.
+func (c *Cache) victim() *node {
+	var n *node
+	if c.selector != nil {
+		// A node no longer in the cache has been zeroed by releaseNode and
+		// has no parent
+		if v := c.selector.Victim().n; v != nil && v.parent != nil {
+			n = v
+		}
+	}
+	if n == nil {
+		n = c.lfu()
+	}
+	if n == nil || !n.protected() {
+		return n
+	}
+	if v := c.lfuWhere(func(n *node) bool { return !n.protected() }); v != nil {
+		return v
+	}
+	return n
 }
 
 // newFrequencyNode inserts a new frequency node after the specified prev node
 func (c *Cache) newFrequencyNode(usage int, prev *frequencyNode) *frequencyNode {
-	fn := &frequencyNode{
-		usage: usage,
-		prev:  prev,
-		next:  prev.next,
-	}
+	fn := c.allocFrequencyNode()
+	fn.usage = usage
+	fn.prev = prev
+	fn.next = prev.next
 
 	if fn.next != nil {
 		fn.next.prev = fn
@@ -309,11 +691,17 @@ func (c *Cache) deleteFrequencyNode(fn *frequencyNode) {
 	}
 
 	fn.prev.next = fn.next
+	c.releaseFrequencyNode(fn)
 }
 
 // moveNodeToFn moves a node to become a child of a frequency node, while
-// properly removing it from any current frequency node
+// properly removing it from any current frequency node. Moving a node to
+// its current frequency node places it last.
 func (c *Cache) moveNodeToFn(n *node, fn *frequencyNode) {
+	if n.parent == fn && fn.tail == n {
+		return
+	}
+
 	if n.prev != nil {
 		n.prev.next = n.next
 	}
@@ -350,6 +738,12 @@ func (c *Cache) moveNodeToFn(n *node, fn *frequencyNode) {
 	fn.tail = n
 }
 
+// protected returns true if evicting the node would bring its namespace
+// below the namespace's minimum quota
+func (n *node) protected() bool {
+	return n.ns != nil && n.ns.length <= n.ns.min
+}
+
 // items0 returns the number of items at the head of the node list (usage
 // count zero)
 func (c *Cache) items0() (count int) {
@@ -10,6 +10,26 @@ import (
 	"testing/quick"
 )
 
+var policies = []struct {
+	name   string
+	policy lfucache.Policy
+}{
+	{"LFU", lfucache.LFU},
+	{"LFUMRU", lfucache.LFUMRU},
+	{"LRU", lfucache.LRU},
+}
+
+// forEachPolicy runs test as a subtest for each built in policy
+func forEachPolicy(t *testing.T, test func(t *testing.T, name string, opt lfucache.Option)) {
+	for _, p := range policies {
+		opt := lfucache.WithPolicy(p.policy)
+		name := p.name
+		t.Run(name, func(t *testing.T) {
+			test(t, name, opt)
+		})
+	}
+}
+
 func TestInstantiateCache(t *testing.T) {
 	_ = lfucache.New(42)
 }
@@ -22,18 +42,74 @@ func TestInstantiateZero(t *testing.T) {
 	t.Error("Should not be able to instantiate zero-sized cache")
 }
 
-func TestInsertAccess(t *testing.T) {
+func TestNewWithOptions(t *testing.T) {
+	exp := make(chan interface{}, 1)
+	c, err := lfucache.NewWithOptions(lfucache.WithCapacity(1), lfucache.WithPolicy(lfucache.LRU), lfucache.WithEvictions(exp))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Insert("test1", 42)
+	c.Insert("test2", 43)
+	if e := <-exp; e.(int) != 42 {
+		t.Errorf("Incorrect eviction %#v", e)
+	}
+}
+
+func TestNewWithOptionsErrors(t *testing.T) {
+	cases := []struct {
+		opts []lfucache.Option
+		err  error
+	}{
+		{nil, lfucache.ErrInvalidCapacity},
+		{[]lfucache.Option{lfucache.WithCapacity(-1)}, lfucache.ErrInvalidCapacity},
+		{[]lfucache.Option{lfucache.WithCapacity(10), lfucache.WithPolicy(nil)}, lfucache.ErrNilPolicy},
+		{[]lfucache.Option{lfucache.WithCapacity(10), lfucache.WithTieBreak(-1)}, lfucache.ErrInvalidTieBreak},
+		{[]lfucache.Option{lfucache.WithCapacity(10), lfucache.WithEvictions(nil)}, lfucache.ErrNilChannel},
+	}
+
+	for i, tc := range cases {
+		if c, err := lfucache.NewWithOptions(tc.opts...); err != tc.err || c != nil {
+			t.Errorf("%d: unexpected result %v, %v", i, c, err)
+		}
+	}
+}
+
+func TestResizeInvalid(t *testing.T) {
 	c := lfucache.New(10)
+	c.Insert("test1", 42)
 
-	c.Insert("test", 42)
-	v, _ := c.Access("test")
-	if v.(int) != 42 {
-		t.Error("Didn't get the right value back from the cache")
+	for _, capacity := range []int{0, -1} {
+		if err := c.Resize(capacity); err != lfucache.ErrInvalidCapacity {
+			t.Errorf("Unexpected error %v resizing to %d", err, capacity)
+		}
+	}
+	if c.Cap() != 10 || c.Len() != 1 {
+		t.Error("Invalid resize changed the cache")
 	}
 }
 
+func TestInsertAccess(t *testing.T) {
+	forEachPolicy(t, func(t *testing.T, _ string, opt lfucache.Option) {
+		c := lfucache.New(10, opt)
+
+		c.Insert("test", 42)
+		v, _ := c.Access("test")
+		if v.(int) != 42 {
+			t.Error("Didn't get the right value back from the cache")
+		}
+	})
+}
+
 func TestExpiry(t *testing.T) {
-	c := lfucache.New(3)
+	expected := map[string]string{"LFU": "test2", "LFUMRU": "test2", "LRU": "test1"}
+	forEachPolicy(t, func(t *testing.T, name string, opt lfucache.Option) {
+		testExpiry(t, opt, expected[name])
+	})
+}
+
+func testExpiry(t *testing.T, opt lfucache.Option, evicted string) {
+	c := lfucache.New(3, opt)
 
 	c.Insert("test1", 42) // usage=1
 	c.Access("test1")     // usage=2
@@ -58,38 +134,47 @@ func TestExpiry(t *testing.T) {
 
 	c.Insert("test4", 45) // usage=1, should remove test2 which is lfu
 
-	if v, _ := c.Access("test1"); v.(int) != 42 {
-		t.Error("Didn't get the right value back from the cache (test1)")
-	}
-
-	if _, ok := c.Access("test2"); ok {
-		t.Error("Node test2 was not removed")
-	}
-
-	if v, _ := c.Access("test3"); v.(int) != 44 {
-		t.Error("Didn't get the right value back from the cache (test3)")
-	}
-
-	if v, _ := c.Access("test4"); v.(int) != 45 {
-		t.Error("Didn't get the right value back from the cache (test4)")
+	for i, key := range []string{"test1", "test2", "test3", "test4"} {
+		v, ok := c.Access(key)
+		if key == evicted {
+			if ok {
+				t.Errorf("Node %s was not removed", key)
+			}
+		} else if !ok || v.(int) != 42+i {
+			t.Errorf("Didn't get the right value back from the cache (%s)", key)
+		}
 	}
 }
 
 func TestExpireOldest(t *testing.T) {
-	c := lfucache.New(3)
+	expected := map[string]string{"LFU": "test1", "LFUMRU": "test3", "LRU": "test1"}
+	forEachPolicy(t, func(t *testing.T, name string, opt lfucache.Option) {
+		c := lfucache.New(3, opt)
 
-	c.Insert("test1", 42)
-	c.Insert("test2", 43)
-	c.Insert("test3", 44)
-	c.Insert("test4", 45) // should remove test1 which is oldest
+		c.Insert("test1", 42)
+		c.Insert("test2", 43)
+		c.Insert("test3", 44)
+		c.Insert("test4", 45) // should remove test1 which is oldest
 
-	if _, ok := c.Access("test1"); ok {
-		t.Error("test1 was not removed")
-	}
+		if _, ok := c.Access(expected[name]); ok {
+			t.Errorf("%s was not removed", expected[name])
+		}
+	})
 }
 
 func TestResize(t *testing.T) {
-	c := lfucache.New(10)
+	expected := map[string][2]string{
+		"LFU":    {"test2", "test4"},
+		"LFUMRU": {"test2", "test4"},
+		"LRU":    {"test1", "test2"},
+	}
+	forEachPolicy(t, func(t *testing.T, name string, opt lfucache.Option) {
+		testResize(t, opt, expected[name])
+	})
+}
+
+func testResize(t *testing.T, opt lfucache.Option, evicted [2]string) {
+	c := lfucache.New(10, opt)
 
 	c.Insert("test1", 42) // usage=0
 	c.Access("test1")     // usage=1
@@ -128,25 +213,26 @@ func TestResize(t *testing.T) {
 		t.Errorf("missed evictions, %d", s.Evictions)
 	}
 
-	if _, ok := c.Access("test2"); ok {
-		t.Error("Node test2 was not removed")
-	}
-
-	if _, ok := c.Access("test4"); ok {
-		t.Error("Node test4 was not removed")
-	}
-
-	if v, _ := c.Access("test1"); v.(int) != 42 {
-		t.Error("Didn't get the right value back from the cache (test1)")
-	}
-
-	if v, _ := c.Access("test3"); v.(int) != 44 {
-		t.Error("Didn't get the right value back from the cache (test3)")
+	for i, key := range []string{"test1", "test2", "test3", "test4"} {
+		v, ok := c.Access(key)
+		if key == evicted[0] || key == evicted[1] {
+			if ok {
+				t.Errorf("Node %s was not removed", key)
+			}
+		} else if !ok || v.(int) != 42+i {
+			t.Errorf("Didn't get the right value back from the cache (%s)", key)
+		}
 	}
 }
 
 func TestDelete(t *testing.T) {
-	c := lfucache.New(3)
+	forEachPolicy(t, func(t *testing.T, _ string, opt lfucache.Option) {
+		testDelete(t, opt)
+	})
+}
+
+func testDelete(t *testing.T, opt lfucache.Option) {
+	c := lfucache.New(3, opt)
 
 	c.Insert("test1", 42) // usage=1
 	c.Access("test1")     // usage=2
@@ -172,9 +258,84 @@ func TestDelete(t *testing.T) {
 	}
 }
 
-func TestDoubleInsert(t *testing.T) {
+func TestPeek(t *testing.T) {
+	c := lfucache.New(2)
+
+	c.Insert("test1", 42)
+	c.Insert("test2", 43)
+	c.Access("test2")
+	if v, ok := c.Peek("test1"); !ok || v.(int) != 42 {
+		t.Error("Didn't get the right value back from the cache (test1)")
+	}
+	if _, ok := c.Peek("test3"); ok {
+		t.Error("Unexpected value for test3")
+	}
+
+	// test1 is still the lfu item
+	c.Insert("test3", 44)
+	if _, ok := c.Peek("test1"); ok {
+		t.Error("test1 was not removed")
+	}
+
+	if s := c.Statistics(); s.Hits != 1 || s.Misses != 0 {
+		t.Errorf("Peek affected statistics %+v", s)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	c := lfucache.New(10)
+
+	c.Insert("test1", 42)
+	c.Access("test1")
+	c.Access("test1")
+	if u, ok := c.Usage("test1"); !ok || u != 2 {
+		t.Errorf("Unexpected usage %d for test1", u)
+	}
+	if u, ok := c.Usage("test1"); !ok || u != 2 {
+		t.Errorf("Usage changed to %d", u)
+	}
+	if _, ok := c.Usage("test2"); ok {
+		t.Error("Unexpected usage for test2")
+	}
+}
+
+func TestInsertWithUsage(t *testing.T) {
 	c := lfucache.New(3)
 
+	c.InsertWithUsage("test1", 42, 5)
+	c.InsertWithUsage("test2", 43, 2)
+	c.Insert("test3", 44)
+	c.Access("test3")
+
+	if u, _ := c.Usage("test1"); u != 5 {
+		t.Errorf("Unexpected usage %d for test1", u)
+	}
+	// Evicts test3, at usage 1
+	c.InsertWithUsage("test4", 45, 3)
+	if _, ok := c.Peek("test3"); ok {
+		t.Error("test3 not evicted")
+	}
+	if u, _ := c.Usage("test2"); u != 2 {
+		t.Errorf("Unexpected usage %d for test2", u)
+	}
+
+	if err := c.InsertWithUsage("test5", 46, -1); err != lfucache.ErrInvalidUsage {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if _, ok := c.Peek("test5"); ok || c.Len() != 3 {
+		t.Error("Item with invalid usage was inserted")
+	}
+}
+
+func TestDoubleInsert(t *testing.T) {
+	forEachPolicy(t, func(t *testing.T, _ string, opt lfucache.Option) {
+		testDoubleInsert(t, opt)
+	})
+}
+
+func testDoubleInsert(t *testing.T, opt lfucache.Option) {
+	c := lfucache.New(3, opt)
+
 	c.Insert("test1", 42)
 	c.Insert("test1", 43)
 	c.Insert("test1", 44)
@@ -195,7 +356,14 @@ func TestDoubleInsert(t *testing.T) {
 }
 
 func TestEvictionsChannel(t *testing.T) {
-	c := lfucache.New(3)
+	expected := map[string]int{"LFU": 43, "LFUMRU": 43, "LRU": 42}
+	forEachPolicy(t, func(t *testing.T, name string, opt lfucache.Option) {
+		testEvictionsChannel(t, opt, expected[name])
+	})
+}
+
+func testEvictionsChannel(t *testing.T, opt lfucache.Option, evicted int) {
+	c := lfucache.New(3, opt)
 
 	exp := make(chan interface{})
 	c.Evictions(exp)
@@ -213,7 +381,7 @@ func TestEvictionsChannel(t *testing.T) {
 			case e := <-exp:
 				if !ready {
 					t.Errorf("Unexpected expire %#v", e)
-				} else if e.(int) != 43 {
+				} else if e.(int) != evicted {
 					t.Errorf("Incorrect expire %#v", e)
 				} else {
 					done <- true
@@ -239,17 +407,55 @@ func TestEvictionsChannel(t *testing.T) {
 	c.Access("test3")
 
 	start <- true
-	// Will evict test2
+	// Will evict test2, or test1 with LRU
 	c.Insert("test4", 45) // usage=1
 	<-done
 
 	c.UnregisterEvictions(exp)
-	// Will evict test3, there is noone listening on the expired channel
+	// Will evict another item, there is noone listening on the expired channel
 	c.Insert("test5", 45) // usage=1
 }
 
+func TestEvictionsNonBlocking(t *testing.T) {
+	c := lfucache.New(1)
+
+	exp := make(chan interface{}, 1)
+	c.EvictionsNonBlocking(exp)
+
+	c.Insert("test1", 42)
+	c.Insert("test2", 43) // evicts test1, buffered
+	c.Insert("test3", 44) // evicts test2, dropped
+
+	if v := <-exp; v.(int) != 42 {
+		t.Errorf("Incorrect expire %#v", v)
+	}
+	if s := c.Statistics(); s.Dropped != 1 || s.Evictions != 2 {
+		t.Errorf("Unexpected statistics %+v", s)
+	}
+
+	c.UnregisterEvictions(exp)
+	c.Insert("test4", 45)
+	if len(exp) != 0 {
+		t.Error("Unexpected expire after unregister")
+	}
+}
+
 func TestStats(t *testing.T) {
-	c := lfucache.New(3)
+	// With LRU, test1 is evicted rather than test2, which is then a hit,
+	// and all items stay at usage zero
+	lfu := lfucache.Statistics{LenFreq0: 1, Inserts: 5, Hits: 6, Misses: 3, Evictions: 2, Deletes: 1, FreqListLen: 2}
+	expected := map[string]lfucache.Statistics{
+		"LFU":    lfu,
+		"LFUMRU": lfu,
+		"LRU":    {LenFreq0: 2, Inserts: 5, Hits: 7, Misses: 2, Evictions: 2, Deletes: 1, FreqListLen: 1},
+	}
+	forEachPolicy(t, func(t *testing.T, name string, opt lfucache.Option) {
+		testStats(t, opt, expected[name])
+	})
+}
+
+func testStats(t *testing.T, opt lfucache.Option, exp lfucache.Statistics) {
+	c := lfucache.New(3, opt)
 
 	c.Access("test1") // miss
 	c.Access("test2") // miss
@@ -267,12 +473,12 @@ func TestStats(t *testing.T) {
 	c.Access("test2") // usage=1
 	c.Access("test3") // usage=2
 
-	// Will evict test2
+	// Will evict test2, or test1 with LRU
 	c.Insert("test4", 45) // usage=0
 
 	c.Access("test2") // miss
 
-	// Will evict test4
+	// Will evict test4, or test3 with LRU
 	c.Insert("test5", 45) // usage=0
 
 	c.Delete("test1")
@@ -280,31 +486,40 @@ func TestStats(t *testing.T) {
 
 	stats := c.Statistics()
 
-	if stats.LenFreq0 != 1 {
+	if stats.LenFreq0 != exp.LenFreq0 {
 		t.Errorf("Stats itemsfreq0 incorrect, %d", stats.LenFreq0)
 	}
-	if stats.Inserts != 5 {
+	if stats.Inserts != exp.Inserts {
 		t.Errorf("Stats inserts incorrect, %d", stats.Inserts)
 	}
-	if stats.Hits != 6 {
+	if stats.Hits != exp.Hits {
 		t.Errorf("Stats hits incorrect, %d", stats.Hits)
 	}
-	if stats.Misses != 3 {
+	if stats.Misses != exp.Misses {
 		t.Errorf("Stats misses incorrect, %d", stats.Misses)
 	}
-	if stats.Evictions != 2 {
+	if stats.Evictions != exp.Evictions {
 		t.Errorf("Stats evictions incorrect, %d", stats.Evictions)
 	}
-	if stats.Deletes != 1 {
+	if stats.Deletes != exp.Deletes {
 		t.Errorf("Stats deletes incorrect, %d", stats.Deletes)
 	}
-	if stats.FreqListLen != 2 {
+	if stats.FreqListLen != exp.FreqListLen {
 		t.Errorf("Stats freqlistlen incorrect, %d", stats.FreqListLen)
 	}
+	if stats.EvictionsByReason[lfucache.EvictCapacity] != exp.Evictions {
+		t.Errorf("Stats capacity evictions incorrect, %d", stats.EvictionsByReason[lfucache.EvictCapacity])
+	}
 }
 
 func TestEvictIf(t *testing.T) {
-	c := lfucache.New(10)
+	forEachPolicy(t, func(t *testing.T, _ string, opt lfucache.Option) {
+		testEvictIf(t, opt)
+	})
+}
+
+func testEvictIf(t *testing.T, opt lfucache.Option) {
+	c := lfucache.New(10, opt)
 
 	c.Insert("test1", 42)
 	c.Insert("test2", 43)
@@ -338,17 +553,48 @@ func TestEvictIf(t *testing.T) {
 }
 
 func TestRandomAccess(t *testing.T) {
-	c := lfucache.New(1024)
+	forEachPolicy(t, func(t *testing.T, _ string, opt lfucache.Option) {
+		c := lfucache.New(1024, opt)
 
-	err := quick.Check(func(key string, val int) bool {
-		c.Insert(key, val)
-		v, ok := c.Access(key)
-		return ok && v.(int) == val
-	}, &quick.Config{MaxCount: 100000})
+		err := quick.Check(func(key string, val int) bool {
+			c.Insert(key, val)
+			v, ok := c.Access(key)
+			return ok && v.(int) == val
+		}, &quick.Config{MaxCount: 100000})
 
-	if err != nil {
-		t.Error(err)
-	}
+		if err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestRandomOperations(t *testing.T) {
+	forEachPolicy(t, func(t *testing.T, _ string, opt lfucache.Option) {
+		c := lfucache.New(64, opt)
+		r := rand.New(rand.NewSource(42))
+
+		for i := 0; i < 100000; i++ {
+			key := r.Intn(256)
+			switch r.Intn(4) {
+			case 0:
+				c.Insert(key, key)
+			case 1:
+				c.Delete(key)
+			default:
+				if v, ok := c.Access(key); ok && v.(int) != key {
+					t.Fatalf("Incorrect value %v for key %d", v, key)
+				}
+			}
+		}
+
+		if err := c.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		s := c.Statistics()
+		if s.Inserts-s.Evictions-s.Deletes != c.Len() || c.Len() > c.Cap() {
+			t.Errorf("Inconsistent length %d for %+v", c.Len(), s)
+		}
+	})
 }
 
 // func TestParallellAccess(t *testing.T) {
@@ -386,57 +632,93 @@ func TestRandomAccess(t *testing.T) {
 
 const cacheSize = 1e6
 
-func BenchmarkInsertStr(b *testing.B) {
-	c := lfucache.New(cacheSize)
+// Keys and values are converted to interface{} up front so that the
+// benchmarks do not measure, and the allocation checks do not count, the
+// conversion.
 
-	keys := make([]string, cacheSize)
+func strKeys() []interface{} {
+	keys := make([]interface{}, cacheSize)
 	for i := 0; i < cacheSize; i++ {
 		keys[i] = fmt.Sprintf("k%d", i)
 	}
+	return keys
+}
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		c.Insert(keys[i%cacheSize], i)
+// assertNoAllocs fails the benchmark if f, called with increasing
+// iteration numbers, allocates in the steady state.
+func assertNoAllocs(b *testing.B, f func(i int)) {
+	b.StopTimer()
+	i := b.N
+	if allocs := testing.AllocsPerRun(1000, func() {
+		f(i)
+		i++
+	}); allocs != 0 {
+		b.Errorf("%v allocations per operation in steady state", allocs)
 	}
 }
 
-func BenchmarkAccessHitBestCaseStr(b *testing.B) {
+func BenchmarkInsertStr(b *testing.B) {
 	c := lfucache.New(cacheSize)
+	keys := strKeys()
+	insert := func(i int) {
+		c.Insert(keys[i%cacheSize], keys[i%cacheSize])
+	}
 
-	keys := make([]string, cacheSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		insert(i)
+	}
+
+	// Fill the cache, so that every further insert evicts an item
+	b.StopTimer()
 	for i := 0; i < cacheSize; i++ {
-		keys[i] = fmt.Sprintf("k%d", i)
+		insert(i)
 	}
+	assertNoAllocs(b, insert)
+}
+
+func BenchmarkAccessHitBestCaseStr(b *testing.B) {
+	c := lfucache.New(cacheSize)
+	keys := strKeys()
 	for i := 0; i < cacheSize; i++ {
 		c.Insert(keys[i], i)
 	}
+	access := func(i int) {
+		c.Access(keys[i%cacheSize])
+	}
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		c.Access(keys[i%cacheSize])
+		access(i)
 	}
+
+	assertNoAllocs(b, access)
 }
 
 func BenchmarkAccessHitRandomStr(b *testing.B) {
 	c := lfucache.New(cacheSize)
-
-	keys := make([]string, cacheSize)
-	for i := 0; i < cacheSize; i++ {
-		keys[i] = fmt.Sprintf("k%d", i)
-	}
+	keys := strKeys()
 	for i := 0; i < cacheSize; i++ {
 		c.Insert(keys[i], i)
 	}
 
-	indexes := make([]string, cacheSize)
+	indexes := make([]interface{}, cacheSize)
 	for i := 0; i < cacheSize; i++ {
 		indexes[i] = keys[int(rand.Int31n(cacheSize))]
 	}
+	access := func(i int) {
+		c.Access(indexes[i%cacheSize])
+	}
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		c.Access(indexes[i%cacheSize])
+		access(i)
 	}
+
+	assertNoAllocs(b, access)
 }
 
 func BenchmarkAccessHitRandomInt(b *testing.B) {
@@ -446,44 +728,54 @@ func BenchmarkAccessHitRandomInt(b *testing.B) {
 		c.Insert(i, i)
 	}
 
-	indexes := make([]int, cacheSize)
+	indexes := make([]interface{}, cacheSize)
 	for i := 0; i < cacheSize; i++ {
 		indexes[i] = int(rand.Int31n(cacheSize))
 	}
+	access := func(i int) {
+		c.Access(indexes[i%cacheSize])
+	}
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		c.Access(indexes[i%cacheSize])
+		access(i)
 	}
+
+	assertNoAllocs(b, access)
 }
 
 func BenchmarkAccessHitWorstCaseStr(b *testing.B) {
 	c := lfucache.New(cacheSize)
-
-	keys := make([]string, cacheSize)
-	for i := 0; i < cacheSize; i++ {
-		keys[i] = fmt.Sprintf("k%d", i)
-	}
+	keys := strKeys()
 	for i := 0; i < cacheSize; i++ {
 		c.Insert(keys[i], i)
 	}
+	access := func(i int) {
+		c.Access(keys[0])
+	}
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		c.Access(keys[0])
+		access(i)
 	}
+
+	assertNoAllocs(b, access)
 }
 
 func BenchmarkAccessMissStr(b *testing.B) {
 	c := lfucache.New(cacheSize)
-
-	keys := make([]string, cacheSize)
-	for i := 0; i < cacheSize; i++ {
-		keys[i] = fmt.Sprintf("k%d", i)
+	keys := strKeys()
+	access := func(i int) {
+		c.Access(keys[i%cacheSize])
 	}
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		c.Access(keys[i%cacheSize])
+		access(i)
 	}
+
+	assertNoAllocs(b, access)
 }
@@ -0,0 +1,158 @@
+// Package metrics exposes lfucache statistics in the Prometheus text
+// exposition format, without depending on any Prometheus client library.
+//
+// A Collector can be served directly as an HTTP handler for scraping, or
+// its output can be merged with that of other collectors using WriteTo.
+package metrics // import "github.com/calmh/deprecated_lfucache/metrics"
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/calmh/deprecated_lfucache"
+)
+
+// Collector gathers statistics from one or more caches.
+type Collector struct {
+	mut    sync.Mutex
+	caches []collected
+}
+
+type collected struct {
+	name  string
+	cache *lfucache.Cache
+	lock  sync.Locker
+}
+
+// NewCollector returns a new Collector without any caches.
+func NewCollector() *Collector {
+	return &Collector{}
+}
+
+// Add adds a cache to the collector. When name is not empty, all metrics
+// for the cache carry a constant "cache" label with that value. As the cache
+// is not thread safe, lock must be the lock that serializes access to it, or
+// nil if the cache is not used concurrently with scrapes.
+func (c *Collector) Add(name string, cache *lfucache.Cache, lock sync.Locker) {
+	c.mut.Lock()
+	c.caches = append(c.caches, collected{name, cache, lock})
+	c.mut.Unlock()
+}
+
+// ServeHTTP serves the metrics in the text exposition format.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.WriteTo(w)
+}
+
+// WriteTo writes the metrics for all caches to w in the text exposition
+// format.
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	c.mut.Lock()
+	caches := append([]collected(nil), c.caches...)
+	c.mut.Unlock()
+
+	hits := &family{name: "lfucache_hits_total", typ: "counter", help: "Number of accesses to items in the cache."}
+	misses := &family{name: "lfucache_misses_total", typ: "counter", help: "Number of accesses to keys not in the cache."}
+	inserts := &family{name: "lfucache_inserts_total", typ: "counter", help: "Number of inserted items."}
+	evictions := &family{name: "lfucache_evictions_total", typ: "counter", help: "Number of evicted items, by reason."}
+	deletes := &family{name: "lfucache_deletes_total", typ: "counter", help: "Number of deleted items."}
+	items := &family{name: "lfucache_items", typ: "gauge", help: "Number of items currently in the cache."}
+	capacity := &family{name: "lfucache_capacity", typ: "gauge", help: "Maximum number of items in the cache."}
+	buckets := &family{name: "lfucache_frequency_buckets", typ: "gauge", help: "Number of distinct usage levels among the items in the cache."}
+	ratio := &family{name: "lfucache_hit_ratio", typ: "gauge", help: "Ratio of hits to all accesses since the cache was created."}
+
+	for _, cc := range caches {
+		if cc.lock != nil {
+			cc.lock.Lock()
+		}
+		s := cc.cache.Statistics()
+		length, cp := cc.cache.Len(), cc.cache.Cap()
+		if cc.lock != nil {
+			cc.lock.Unlock()
+		}
+
+		l := labels(cc.name, "")
+		hits.add(l, float64(s.Hits))
+		misses.add(l, float64(s.Misses))
+		inserts.add(l, float64(s.Inserts))
+		for r, n := range s.EvictionsByReason {
+			evictions.add(labels(cc.name, lfucache.EvictReason(r).String()), float64(n))
+		}
+		deletes.add(l, float64(s.Deletes))
+		items.add(l, float64(length))
+		capacity.add(l, float64(cp))
+		buckets.add(l, float64(s.FreqListLen))
+		if s.Hits+s.Misses > 0 {
+			ratio.add(l, float64(s.Hits)/float64(s.Hits+s.Misses))
+		} else {
+			ratio.add(l, 0)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	cw := &countingWriter{w: bw}
+	for _, f := range []*family{hits, misses, inserts, evictions, deletes, items, capacity, buckets, ratio} {
+		fmt.Fprintf(cw, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(cw, "# TYPE %s %s\n", f.name, f.typ)
+		for _, s := range f.samples {
+			fmt.Fprintf(cw, "%s%s %v\n", f.name, s.labels, s.value)
+		}
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+type family struct {
+	name    string
+	typ     string
+	help    string
+	samples []sample
+}
+
+type sample struct {
+	labels string
+	value  float64
+}
+
+func (f *family) add(labels string, value float64) {
+	f.samples = append(f.samples, sample{labels, value})
+}
+
+// labels returns the label set for the given cache name and eviction
+// reason, either of which may be empty
+func labels(name, reason string) string {
+	var ls []string
+	if name != "" {
+		ls = append(ls, `cache="`+escape(name)+`"`)
+	}
+	if reason != "" {
+		ls = append(ls, `reason="`+escape(reason)+`"`)
+	}
+	if len(ls) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(ls, ",") + "}"
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escape escapes a label value as required by the exposition format
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(bs []byte) (int, error) {
+	n, err := w.w.Write(bs)
+	w.n += int64(n)
+	return n, err
+}
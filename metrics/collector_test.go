@@ -0,0 +1,70 @@
+package metrics_test
+
+import (
+	"bytes"
+	"flag"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/calmh/deprecated_lfucache"
+	"github.com/calmh/deprecated_lfucache/metrics"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestCollectorGolden(t *testing.T) {
+	a := lfucache.New(3)
+	a.Access("test1") // miss
+	a.Insert("test1", 42)
+	a.Access("test1")
+	a.Insert("test2", 43)
+	a.Insert("test3", 44)
+	a.Insert("test4", 45) // evicts test2
+	a.Insert("test4", 46) // replaces test4
+	a.Delete("test3")
+
+	b := lfucache.New(10)
+	b.Insert("test1", 42)
+
+	var mut sync.Mutex
+	c := metrics.NewCollector()
+	c.Add("a", a, &mut)
+	c.Add(`b "quoted"`, b, nil)
+
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	const golden = "testdata/collector.golden"
+	if *update {
+		if err := os.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("Output does not match %s:\n%s", golden, buf.String())
+	}
+}
+
+func TestCollectorHTTP(t *testing.T) {
+	c := metrics.NewCollector()
+	c.Add("", lfucache.New(10), nil)
+
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+	if !bytes.Contains(rec.Body.Bytes(), []byte("\nlfucache_capacity 10\n")) {
+		t.Errorf("Missing unlabeled capacity in:\n%s", rec.Body.String())
+	}
+}
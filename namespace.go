@@ -0,0 +1,179 @@
+package lfucache
+
+// Namespace is a handle to a separate key space within a Cache. Items in all
+// namespaces share the capacity of the cache and compete for it on equal LFU
+// terms, while each namespace keeps its own statistics and may be given
+// minimum and maximum quotas.
+type Namespace struct {
+	cache  *Cache
+	name   string
+	min    int
+	max    int
+	length int
+	stats  Statistics
+}
+
+// nsKey is the index key for items stored in a namespace. Being unexported,
+// it can never collide with keys inserted directly into the cache.
+type nsKey struct {
+	ns  *Namespace
+	key interface{}
+}
+
+// Namespace returns the namespace with the given name, creating it if it
+// does not already exist.
+func (c *Cache) Namespace(name string) *Namespace {
+	if ns, ok := c.namespaces[name]; ok {
+		return ns
+	}
+
+	if c.namespaces == nil {
+		c.namespaces = make(map[string]*Namespace)
+	}
+
+	ns := &Namespace{cache: c, name: name}
+	c.namespaces[name] = ns
+	return ns
+}
+
+// Name returns the name of the namespace.
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+// SetQuota sets the minimum and maximum number of items held by the
+// namespace. Items are not evicted from a namespace holding min items or
+// less, unless every item in the cache is protected this way. A namespace
+// holding max items evicts its own least frequently used item on Insert. A
+// max of zero means no upper limit. Lowering max does not by itself evict
+// any items. Returns ErrInvalidQuota, leaving the quota unchanged, if min
+// or max is negative or min is larger than a non zero max.
+func (ns *Namespace) SetQuota(min, max int) error {
+	if min < 0 || max < 0 || (max > 0 && min > max) {
+		return ErrInvalidQuota
+	}
+
+	ns.min = min
+	ns.max = max
+	return nil
+}
+
+// Insert inserts an item into the namespace. See Cache.Insert.
+func (ns *Namespace) Insert(key interface{}, value interface{}) {
+	c := ns.cache
+	if debug {
+		c.check()
+	}
+
+	c.insert(ns, nsKey{ns, key}, value, 0)
+
+	if debug {
+		c.check()
+	}
+}
+
+// Access an item in the namespace. See Cache.Access.
+func (ns *Namespace) Access(key interface{}) (interface{}, bool) {
+	c := ns.cache
+	if debug {
+		c.check()
+	}
+
+	v, ok := c.access(ns, nsKey{ns, key})
+
+	if debug {
+		c.check()
+	}
+
+	return v, ok
+}
+
+// Delete deletes an item from the namespace. See Cache.Delete.
+func (ns *Namespace) Delete(key interface{}) bool {
+	c := ns.cache
+	if debug {
+		c.check()
+	}
+
+	ok := c.delete(nsKey{ns, key})
+
+	if debug {
+		c.check()
+	}
+
+	return ok
+}
+
+// EvictIf applies test to each item in the namespace and evicts it if the
+// test returns true. Returns the number of items that were evicted.
+func (ns *Namespace) EvictIf(test func(interface{}) bool) int {
+	c := ns.cache
+	if debug {
+		c.check()
+	}
+
+	cnt := c.evictIf(ns, test)
+
+	if debug {
+		c.check()
+	}
+
+	return cnt
+}
+
+// Len returns the number of items currently stored in the namespace.
+func (ns *Namespace) Len() int {
+	return ns.length
+}
+
+// Statistics returns the namespace statistics. The operation counters cover
+// operations on the namespace only, while FreqListLen is the number of
+// distinct usage levels among the namespace's items. Bytes is the estimated
+// size of the namespace's items, excluding the frequency list and freelists
+// shared with the rest of the cache. Unlike Cache.Statistics, this walks all
+// items in the cache.
+func (ns *Namespace) Statistics() Statistics {
+	c := ns.cache
+	if debug {
+		c.check()
+	}
+
+	s := ns.stats
+	for fn := c.frequencyList; fn != nil; fn = fn.next {
+		found := false
+		for n := fn.head; n != nil; n = n.next {
+			if n.ns != ns {
+				continue
+			}
+			found = true
+			s.Bytes += n.size
+			if fn.usage == 0 {
+				s.LenFreq0++
+			}
+		}
+		if found {
+			s.FreqListLen++
+		}
+	}
+	return s
+}
+
+// owns returns true if the node belongs to the namespace
+func (ns *Namespace) owns(n *node) bool {
+	return n.ns == ns
+}
+
+// userKey returns the key of the node as given by the user, without any
+// namespace wrapping
+func (n *node) userKey() interface{} {
+	return unwrapKey(n.key)
+}
+
+// unwrapKey returns the index key as given by the user, without any
+// namespace wrapping
+func unwrapKey(key interface{}) interface{} {
+	if k, ok := key.(nsKey); ok {
+		return k.key
+	}
+	return key
+}
@@ -0,0 +1,166 @@
+package lfucache_test
+
+import (
+	"github.com/calmh/lfucache"
+	"testing"
+)
+
+func TestNamespaceKeySpaces(t *testing.T) {
+	c := lfucache.New(10)
+	a := c.Namespace("a")
+	b := c.Namespace("b")
+
+	if c.Namespace("a") != a {
+		t.Error("Namespace did not return the existing namespace")
+	}
+
+	c.Insert("test", 1)
+	a.Insert("test", 2)
+	b.Insert("test", 3)
+
+	if c.Len() != 3 {
+		t.Errorf("Unexpected size %d", c.Len())
+	}
+
+	if v, _ := c.Access("test"); v.(int) != 1 {
+		t.Error("Didn't get the right value back from the cache")
+	}
+	if v, _ := a.Access("test"); v.(int) != 2 {
+		t.Error("Didn't get the right value back from namespace a")
+	}
+	if v, _ := b.Access("test"); v.(int) != 3 {
+		t.Error("Didn't get the right value back from namespace b")
+	}
+
+	if !a.Delete("test") {
+		t.Error("Delete in namespace a failed")
+	}
+	if _, ok := a.Access("test"); ok {
+		t.Error("test was not deleted from namespace a")
+	}
+	if _, ok := b.Access("test"); !ok {
+		t.Error("test was deleted from namespace b")
+	}
+}
+
+func TestNamespaceSharedEviction(t *testing.T) {
+	c := lfucache.New(3)
+	a := c.Namespace("a")
+	b := c.Namespace("b")
+
+	a.Insert("test1", 42)
+	a.Access("test1")
+	b.Insert("test2", 43)
+	b.Insert("test3", 44)
+	b.Access("test3")
+
+	// Will evict test2, the lfu across both namespaces
+	a.Insert("test4", 45)
+
+	if _, ok := b.Access("test2"); ok {
+		t.Error("test2 was not removed")
+	}
+
+	if s := b.Statistics(); s.Evictions != 1 {
+		t.Errorf("Namespace b evictions incorrect, %d", s.Evictions)
+	}
+	if s := a.Statistics(); s.Evictions != 0 {
+		t.Errorf("Namespace a evictions incorrect, %d", s.Evictions)
+	}
+}
+
+func TestNamespaceQuotas(t *testing.T) {
+	c := lfucache.New(4)
+	a := c.Namespace("a")
+	b := c.Namespace("b")
+	a.SetQuota(2, 0)
+	b.SetQuota(0, 2)
+
+	a.Insert("test1", 42)
+	a.Insert("test2", 43)
+	b.Insert("test3", 44)
+	b.Access("test3")
+	b.Insert("test4", 45)
+	b.Access("test4")
+
+	// Namespace b is at max, so its own lfu test3 is evicted
+	b.Insert("test5", 46)
+	if _, ok := b.Access("test3"); ok {
+		t.Error("test3 was not removed")
+	}
+	if b.Len() != 2 {
+		t.Errorf("Namespace b has incorrect length %d", b.Len())
+	}
+
+	// Namespace a is at min, so b's test5 is evicted instead of test1
+	c.Insert("test6", 47)
+	if _, ok := a.Access("test1"); !ok {
+		t.Error("test1 was removed despite min quota")
+	}
+	if _, ok := b.Access("test5"); ok {
+		t.Error("test5 was not removed")
+	}
+}
+
+func TestNamespaceEvictIf(t *testing.T) {
+	c := lfucache.New(10)
+	a := c.Namespace("a")
+	b := c.Namespace("b")
+
+	a.Insert("test1", 42)
+	a.Insert("test2", 43)
+	b.Insert("test3", 44)
+
+	if ev := a.EvictIf(func(v interface{}) bool { return true }); ev != 2 {
+		t.Error("Incorrect number of items evicted", ev)
+	}
+
+	if a.Len() != 0 || b.Len() != 1 || c.Len() != 1 {
+		t.Errorf("Unexpected sizes %d, %d, %d", a.Len(), b.Len(), c.Len())
+	}
+
+	s := a.Statistics()
+	if s.Inserts != 2 || s.Evictions != 2 {
+		t.Errorf("Namespace statistics incorrect, %+v", s)
+	}
+}
+
+func TestNamespaceRange(t *testing.T) {
+	c := lfucache.New(10)
+	a := c.Namespace("a")
+
+	c.Insert("test1", 42)
+	c.Insert("test2", 43)
+	c.Access("test1")
+	a.Insert("test1", 44)
+	a.Insert("test3", 45)
+
+	var got []lfucache.KeyUsage
+	c.Range(func(key, value interface{}, usage int) bool {
+		got = append(got, lfucache.KeyUsage{Key: key, Usage: usage})
+		return true
+	})
+	if len(got) != 2 || got[0] != (lfucache.KeyUsage{Key: "test2", Usage: 0}) || got[1] != (lfucache.KeyUsage{Key: "test1", Usage: 1}) {
+		t.Errorf("Unexpected items %v", got)
+	}
+
+	var values []interface{}
+	a.Range(func(key, value interface{}, usage int) bool {
+		values = append(values, value)
+		return false
+	})
+	if len(values) != 1 || values[0] != 44 {
+		t.Errorf("Unexpected values %v", values)
+	}
+}
+
+func TestNamespaceInvalidQuota(t *testing.T) {
+	ns := lfucache.New(10).Namespace("a")
+	ns.SetQuota(1, 2)
+
+	for _, q := range [][2]int{{-1, 0}, {0, -1}, {3, 2}} {
+		if err := ns.SetQuota(q[0], q[1]); err != lfucache.ErrInvalidQuota {
+			t.Errorf("Unexpected error %v for quota %v", err, q)
+		}
+	}
+}
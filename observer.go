@@ -0,0 +1,194 @@
+package lfucache
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Observer receives a callback for every cache operation. The callbacks are
+// made synchronously, after the operation has been carried out but before
+// it returns, so they must be fast and must not call back into the cache.
+type Observer interface {
+	OnInsert(Event)
+	OnHit(Event)
+	OnMiss(Event)
+	OnEvict(Event)
+	OnDelete(Event)
+	OnResize(ResizeEvent)
+}
+
+// Event describes an operation on a single item.
+type Event struct {
+	Key         interface{}   // The key, as given by the user
+	Value       interface{}   // The value of the item, nil for misses
+	Namespace   string        // The namespace name, or empty for the root key space
+	UsageBefore int           // The item's usage count before the operation
+	UsageAfter  int           // The item's usage count after the operation
+	Reason      EvictReason   // The cause of an eviction
+	Duration    time.Duration // Time spent in the operation, zero for evictions
+}
+
+// ResizeEvent describes a call to Resize.
+type ResizeEvent struct {
+	OldCapacity int
+	NewCapacity int
+	Duration    time.Duration
+}
+
+// WithObserver sets an observer to be notified of all cache operations.
+// Without an observer, the cost of the notifications is a single nil check
+// per operation.
+func WithObserver(o Observer) Option {
+	return func(c *Cache) {
+		c.observer = o
+	}
+}
+
+// Observer returns the observer of the cache, or nil if there is none.
+func (c *Cache) Observer() Observer {
+	return c.observer
+}
+
+// SetObserver replaces the observer of the cache. Use MultiObserver to add
+// an observer to the one already set.
+func (c *Cache) SetObserver(o Observer) {
+	c.observer = o
+}
+
+// MultiObserver returns an Observer passing every event to each of the
+// observers in turn. Nil observers are skipped, and nil is returned if
+// there are no others.
+func MultiObserver(observers ...Observer) Observer {
+	var m multiObserver
+	for _, o := range observers {
+		if o != nil {
+			m = append(m, o)
+		}
+	}
+	switch len(m) {
+	case 0:
+		return nil
+	case 1:
+		return m[0]
+	}
+	return m
+}
+
+type multiObserver []Observer
+
+func (m multiObserver) OnInsert(e Event) {
+	for _, o := range m {
+		o.OnInsert(e)
+	}
+}
+
+func (m multiObserver) OnHit(e Event) {
+	for _, o := range m {
+		o.OnHit(e)
+	}
+}
+
+func (m multiObserver) OnMiss(e Event) {
+	for _, o := range m {
+		o.OnMiss(e)
+	}
+}
+
+func (m multiObserver) OnEvict(e Event) {
+	for _, o := range m {
+		o.OnEvict(e)
+	}
+}
+
+func (m multiObserver) OnDelete(e Event) {
+	for _, o := range m {
+		o.OnDelete(e)
+	}
+}
+
+func (m multiObserver) OnResize(e ResizeEvent) {
+	for _, o := range m {
+		o.OnResize(e)
+	}
+}
+
+// NopObserver implements Observer by ignoring all events. It can be
+// embedded by observers only interested in some of them.
+type NopObserver struct{}
+
+func (NopObserver) OnInsert(Event)       {}
+func (NopObserver) OnHit(Event)          {}
+func (NopObserver) OnMiss(Event)         {}
+func (NopObserver) OnEvict(Event)        {}
+func (NopObserver) OnDelete(Event)       {}
+func (NopObserver) OnResize(ResizeEvent) {}
+
+// SlogObserver is an Observer logging all cache operations to a
+// slog.Logger.
+type SlogObserver struct {
+	Logger *slog.Logger
+	Level  slog.Level
+}
+
+// NewSlogObserver returns an observer logging to l at the given level.
+func NewSlogObserver(l *slog.Logger, level slog.Level) *SlogObserver {
+	return &SlogObserver{Logger: l, Level: level}
+}
+
+func (o *SlogObserver) OnInsert(e Event) { o.log("insert", e) }
+func (o *SlogObserver) OnHit(e Event)    { o.log("hit", e) }
+func (o *SlogObserver) OnMiss(e Event)   { o.log("miss", e) }
+func (o *SlogObserver) OnDelete(e Event) { o.log("delete", e) }
+
+func (o *SlogObserver) OnEvict(e Event) {
+	o.log("evict", e, slog.String("reason", e.Reason.String()))
+}
+
+func (o *SlogObserver) OnResize(e ResizeEvent) {
+	o.Logger.LogAttrs(context.Background(), o.Level, "lfucache resize",
+		slog.Int("old", e.OldCapacity),
+		slog.Int("new", e.NewCapacity),
+		slog.Duration("duration", e.Duration))
+}
+
+func (o *SlogObserver) log(op string, e Event, extra ...slog.Attr) {
+	if !o.Logger.Enabled(context.Background(), o.Level) {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.Any("key", e.Key),
+		slog.Int("usageBefore", e.UsageBefore),
+		slog.Int("usageAfter", e.UsageAfter),
+		slog.Duration("duration", e.Duration),
+	}
+	if e.Namespace != "" {
+		attrs = append(attrs, slog.String("namespace", e.Namespace))
+	}
+	attrs = append(attrs, extra...)
+	o.Logger.LogAttrs(context.Background(), o.Level, "lfucache "+op, attrs...)
+}
+
+// event returns an event for the node, with the usage before and after
+// both set to the node's current usage
+func (n *node) event() Event {
+	e := Event{
+		Key:         n.userKey(),
+		Value:       n.value,
+		UsageBefore: n.parent.usage,
+		UsageAfter:  n.parent.usage,
+	}
+	if n.ns != nil {
+		e.Namespace = n.ns.name
+	}
+	return e
+}
+
+// missEvent returns an event for a miss on the given index key
+func missEvent(ns *Namespace, key interface{}) Event {
+	e := Event{Key: unwrapKey(key)}
+	if ns != nil {
+		e.Namespace = ns.name
+	}
+	return e
+}
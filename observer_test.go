@@ -0,0 +1,101 @@
+package lfucache_test
+
+import (
+	"bytes"
+	"github.com/calmh/lfucache"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+type recordingObserver struct {
+	events []string
+	last   map[string]lfucache.Event
+}
+
+func (o *recordingObserver) record(op string, e lfucache.Event) {
+	o.events = append(o.events, op)
+	if o.last == nil {
+		o.last = make(map[string]lfucache.Event)
+	}
+	o.last[op] = e
+}
+
+func (o *recordingObserver) OnInsert(e lfucache.Event) { o.record("insert", e) }
+func (o *recordingObserver) OnHit(e lfucache.Event)    { o.record("hit", e) }
+func (o *recordingObserver) OnMiss(e lfucache.Event)   { o.record("miss", e) }
+func (o *recordingObserver) OnEvict(e lfucache.Event)  { o.record("evict", e) }
+func (o *recordingObserver) OnDelete(e lfucache.Event) { o.record("delete", e) }
+func (o *recordingObserver) OnResize(e lfucache.ResizeEvent) {
+	o.record("resize", lfucache.Event{UsageBefore: e.OldCapacity, UsageAfter: e.NewCapacity})
+}
+
+func TestObserver(t *testing.T) {
+	o := &recordingObserver{}
+	c := lfucache.New(2, lfucache.WithObserver(o))
+
+	c.Insert("test1", 42)
+	c.Access("test1")
+	c.Access("test1")
+	c.Namespace("ns").Access("test2")
+	c.Insert("test2", 43)
+	c.Insert("test3", 44) // evicts test2
+	c.Delete("test1")
+	c.Resize(1)
+
+	expected := "insert hit hit miss insert evict insert delete resize"
+	if got := strings.Join(o.events, " "); got != expected {
+		t.Errorf("Unexpected events %q", got)
+	}
+
+	if e := o.last["hit"]; e.Key != "test1" || e.Value != 42 || e.UsageBefore != 1 || e.UsageAfter != 2 {
+		t.Errorf("Unexpected hit event %+v", e)
+	}
+	if e := o.last["miss"]; e.Key != "test2" || e.Namespace != "ns" {
+		t.Errorf("Unexpected miss event %+v", e)
+	}
+	if e := o.last["evict"]; e.Key != "test2" || e.Reason != lfucache.EvictCapacity || e.UsageBefore != 0 {
+		t.Errorf("Unexpected evict event %+v", e)
+	}
+	if e := o.last["delete"]; e.Key != "test1" || e.UsageBefore != 2 || e.UsageAfter != 0 {
+		t.Errorf("Unexpected delete event %+v", e)
+	}
+	if e := o.last["resize"]; e.UsageBefore != 2 || e.UsageAfter != 1 {
+		t.Errorf("Unexpected resize event %+v", e)
+	}
+}
+
+func TestMultiObserver(t *testing.T) {
+	a, b := &recordingObserver{}, &recordingObserver{}
+	c := lfucache.New(1, lfucache.WithObserver(a))
+	c.SetObserver(lfucache.MultiObserver(c.Observer(), nil, b))
+
+	c.Insert("test1", 42)
+	c.Insert("test2", 43)
+
+	for _, o := range []*recordingObserver{a, b} {
+		if strings.Join(o.events, ",") != "insert,evict,insert" {
+			t.Errorf("Unexpected events %v", o.events)
+		}
+	}
+	if lfucache.MultiObserver(nil) != nil {
+		t.Error("MultiObserver of nil observers is not nil")
+	}
+	if lfucache.MultiObserver(a, nil) != a {
+		t.Error("MultiObserver of a single observer is not that observer")
+	}
+}
+
+func TestSlogObserver(t *testing.T) {
+	var buf bytes.Buffer
+	l := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	c := lfucache.New(1, lfucache.WithObserver(lfucache.NewSlogObserver(l, slog.LevelDebug)))
+
+	c.Insert("test1", 42)
+	c.Insert("test2", 43)
+
+	out := buf.String()
+	if !strings.Contains(out, `msg="lfucache evict" key=test1`) || !strings.Contains(out, "reason=capacity") {
+		t.Errorf("Unexpected log output:\n%s", out)
+	}
+}
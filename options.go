@@ -0,0 +1,47 @@
+package lfucache
+
+// Option is a setting for New and NewWithOptions.
+type Option func(*Cache)
+
+// WithCapacity sets the maximum number of items the cache will hold. It is
+// required by NewWithOptions.
+func WithCapacity(capacity int) Option {
+	return func(c *Cache) {
+		c.capacity = capacity
+	}
+}
+
+// WithEvictions registers a channel used to report evicted items, as by
+// Evictions.
+func WithEvictions(e chan<- interface{}) Option {
+	return func(c *Cache) {
+		c.evictedChans = append(c.evictedChans, e)
+	}
+}
+
+// validateOptions checks the configuration resulting from the options
+func (c *Cache) validateOptions() error {
+	if c.capacity <= 0 {
+		return ErrInvalidCapacity
+	}
+	if c.policy == nil {
+		return ErrNilPolicy
+	}
+	if c.tieBreakSet && (c.tieBreak < 0 || c.tieBreak >= numTieBreaks) {
+		return ErrInvalidTieBreak
+	}
+	if !c.tieBreakSet {
+		if tb := c.policy.TieBreak(); tb < 0 || tb >= numTieBreaks {
+			return ErrInvalidTieBreak
+		}
+	}
+	if c.maxBytesSet && c.maxBytes <= 0 {
+		return ErrInvalidMaxBytes
+	}
+	for _, e := range c.evictedChans {
+		if e == nil {
+			return ErrNilChannel
+		}
+	}
+	return nil
+}
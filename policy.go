@@ -0,0 +1,234 @@
+package lfucache
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Policy decides how the usage count of an item changes when it is
+// accessed, and which of the items sharing the lowest usage count is
+// evicted first. Unless the policy is also a Selector, eviction always
+// happens from the lowest usage count present in the cache, so a policy
+// shapes the eviction order through the usage counts it moves items
+// between. Insert, Delete, eviction events and statistics behave the same
+// regardless of policy.
+type Policy interface {
+	// Promote returns the new usage count of an accessed item with the
+	// given usage count. Returning the same count moves the item behind
+	// the other items with that count. A negative result is taken as zero.
+	Promote(usage int) int
+
+	// TieBreak returns which item to evict among those sharing the lowest
+	// usage count.
+	TieBreak() TieBreak
+}
+
+// Selector is a Policy choosing the items to evict itself, in any order,
+// rather than through usage counts and a TieBreak. The cache tells it about
+// every item added, accessed and removed, so that it can keep the items in
+// the order it needs. Usage counts are still maintained by Promote and
+// reported as usual.
+//
+// Evictions within a namespace to respect its maximum quota, and evictions
+// where the victim would bring its namespace below its minimum quota, fall
+// back to LFU order. A Selector keeps state for a single cache and must not
+// be shared between caches.
+type Selector interface {
+	Policy
+
+	// Added is called for an item inserted into the cache.
+	Added(e Entry)
+
+	// Accessed is called for an item accessed, after its usage count has
+	// been updated.
+	Accessed(e Entry)
+
+	// Removed is called for an item leaving the cache for any reason,
+	// before its Entry is reused for another item.
+	Removed(e Entry)
+
+	// Victim returns the item to evict to make room. It is only called
+	// when the cache is not empty. Returning the zero Entry leaves the
+	// choice to the TieBreak.
+	Victim() Entry
+}
+
+// Entry is an item in the cache, as seen by a Selector. Entries are
+// comparable, and valid from the call to Added until the call to Removed.
+type Entry struct {
+	n *node
+}
+
+// Key returns the key of the item, without any namespace.
+func (e Entry) Key() interface{} {
+	return e.n.userKey()
+}
+
+// Namespace returns the name of the namespace of the item, or "" for the
+// root key space.
+func (e Entry) Namespace() string {
+	if e.n.ns == nil {
+		return ""
+	}
+	return e.n.ns.name
+}
+
+// Usage returns the usage count of the item.
+func (e Entry) Usage() int {
+	return e.n.parent.usage
+}
+
+// TieBreak selects between items with the same usage count on eviction.
+// To keep eviction O(1), TieBreakRandom and TieBreakLargestCost consider
+// only the oldest few items with the lowest usage count.
+type TieBreak int
+
+const (
+	TieBreakOldest      TieBreak = iota // The item that got its usage count first, i.e. the least recently used
+	TieBreakNewest                      // The item that got its usage count last, i.e. the most recently used
+	TieBreakRandom                      // A random item among the oldest ones
+	TieBreakLargestCost                 // The item with the largest cost among the oldest ones, as given by WithCost
+	numTieBreaks
+)
+
+// tieBreakSample is the number of items considered by TieBreakRandom and
+// TieBreakLargestCost
+const tieBreakSample = 8
+
+// CostFunc returns the cost of keeping an item in the cache, in arbitrary
+// units.
+type CostFunc func(key, value interface{}) int
+
+// The built in policies.
+var (
+	// LFU evicts the least frequently used item, and the least recently
+	// used one among those. This is the default.
+	LFU Policy = lfuPolicy{TieBreakOldest}
+
+	// LFUMRU evicts the least frequently used item, and the most recently
+	// used one among those.
+	LFUMRU Policy = lfuPolicy{TieBreakNewest}
+
+	// LRU evicts the least recently used item. All items stay at usage
+	// count zero, which is also what statistics and events report.
+	LRU Policy = lruPolicy{}
+)
+
+type lfuPolicy struct {
+	tieBreak TieBreak
+}
+
+func (p lfuPolicy) Promote(usage int) int {
+	return usage + 1
+}
+
+func (p lfuPolicy) TieBreak() TieBreak {
+	return p.tieBreak
+}
+
+type lruPolicy struct{}
+
+func (lruPolicy) Promote(usage int) int {
+	return usage
+}
+
+func (lruPolicy) TieBreak() TieBreak {
+	return TieBreakOldest
+}
+
+// WithPolicy sets the eviction policy. The default is LFU.
+func WithPolicy(p Policy) Option {
+	return func(c *Cache) {
+		c.policy = p
+	}
+}
+
+// WithTieBreak overrides the tie break of the eviction policy.
+func WithTieBreak(tb TieBreak) Option {
+	return func(c *Cache) {
+		c.tieBreak = tb
+		c.tieBreakSet = true
+	}
+}
+
+// WithCost sets the function giving the cost of items, as used by
+// TieBreakLargestCost. Without it, the cost of an item is its estimated
+// size with WithMemoryEstimate or WithMaxBytes; otherwise all items have
+// the same cost and the oldest one is evicted.
+func WithCost(f CostFunc) Option {
+	return func(c *Cache) {
+		c.cost = f
+	}
+}
+
+// initPolicy resolves the tie break once all options have been applied
+func (c *Cache) initPolicy() {
+	c.selector, _ = c.policy.(Selector)
+	if !c.tieBreakSet {
+		c.tieBreak = c.policy.TieBreak()
+	}
+	if c.tieBreak == TieBreakRandom {
+		c.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+}
+
+// pick returns the node to evict among the nodes of fn, or nil if fn is
+// empty
+func (c *Cache) pick(fn *frequencyNode) *node {
+	switch c.tieBreak {
+	case TieBreakNewest:
+		return fn.tail
+
+	case TieBreakRandom:
+		cnt := 0
+		for n := fn.head; n != nil && cnt < tieBreakSample; n = n.next {
+			cnt++
+		}
+		if cnt == 0 {
+			return nil
+		}
+		n := fn.head
+		for i := c.rand.Intn(cnt); i > 0; i-- {
+			n = n.next
+		}
+		return n
+
+	case TieBreakLargestCost:
+		if c.cost == nil && !c.estimate {
+			return fn.head
+		}
+		var pick *node
+		var max int64
+		cnt := 0
+		for n := fn.head; n != nil && cnt < tieBreakSample; n = n.next {
+			cost := n.size
+			if c.cost != nil {
+				cost = int64(c.cost(n.userKey(), n.value))
+			}
+			if pick == nil || cost > max {
+				pick = n
+				max = cost
+			}
+			cnt++
+		}
+		return pick
+	}
+
+	return fn.head
+}
+
+// frequencyNodeFor returns the frequency node for the given usage count,
+// creating it if necessary, searching from fn. The search is O(1) when the
+// usage count is that of fn or the one following it.
+func (c *Cache) frequencyNodeFor(usage int, fn *frequencyNode) *frequencyNode {
+	for fn.usage > usage {
+		fn = fn.prev
+	}
+	for fn.next != nil && fn.next.usage <= usage {
+		fn = fn.next
+	}
+	if fn.usage == usage {
+		return fn
+	}
+	return c.newFrequencyNode(usage, fn)
+}
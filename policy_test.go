@@ -0,0 +1,291 @@
+package lfucache_test
+
+import (
+	"container/list"
+	"github.com/calmh/lfucache"
+	"testing"
+)
+
+// cappedPolicy is LFU with usage counts saturating at max
+type cappedPolicy struct {
+	max int
+}
+
+func (p cappedPolicy) Promote(usage int) int {
+	if usage >= p.max {
+		return p.max
+	}
+	return usage + 1
+}
+
+func (p cappedPolicy) TieBreak() lfucache.TieBreak {
+	return lfucache.TieBreakOldest
+}
+
+func TestCustomPolicy(t *testing.T) {
+	c := lfucache.New(3, lfucache.WithPolicy(cappedPolicy{2}))
+
+	c.Insert("test1", 42)
+	for i := 0; i < 10; i++ {
+		c.Access("test1") // usage=2
+	}
+	c.Insert("test2", 43)
+	c.Access("test2")
+	c.Access("test2") // usage=2, after test1
+	c.Insert("test3", 44)
+	c.Access("test3") // usage=1
+
+	if s := c.Statistics(); s.FreqListLen != 3 {
+		t.Errorf("Unexpected number of frequency nodes %d", s.FreqListLen)
+	}
+
+	c.Delete("test3")
+	c.Insert("test4", 45)
+	c.Access("test4")
+	c.Access("test4") // usage=2, after test2
+	c.Insert("test5", 46)
+
+	// Every item is at the cap, so the oldest one there goes first
+	if _, ok := c.Access("test1"); ok {
+		t.Error("test1 was not removed")
+	}
+	if err := c.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+// fifoPolicy counts usage like LFU, but evicts the oldest inserted item
+// regardless of usage
+type fifoPolicy struct {
+	order *list.List
+	elems map[lfucache.Entry]*list.Element
+}
+
+func newFIFOPolicy() *fifoPolicy {
+	return &fifoPolicy{order: list.New(), elems: make(map[lfucache.Entry]*list.Element)}
+}
+
+func (p *fifoPolicy) Promote(usage int) int       { return usage + 1 }
+func (p *fifoPolicy) TieBreak() lfucache.TieBreak { return lfucache.TieBreakOldest }
+func (p *fifoPolicy) Added(e lfucache.Entry)      { p.elems[e] = p.order.PushBack(e) }
+func (p *fifoPolicy) Accessed(e lfucache.Entry)   {}
+func (p *fifoPolicy) Victim() lfucache.Entry      { return p.order.Front().Value.(lfucache.Entry) }
+func (p *fifoPolicy) Removed(e lfucache.Entry) {
+	p.order.Remove(p.elems[e])
+	delete(p.elems, e)
+}
+
+// stalePolicy always chooses the first item ever added, even once it has
+// been removed
+type stalePolicy struct {
+	first *lfucache.Entry
+}
+
+func (p *stalePolicy) Promote(usage int) int       { return usage + 1 }
+func (p *stalePolicy) TieBreak() lfucache.TieBreak { return lfucache.TieBreakOldest }
+func (p *stalePolicy) Accessed(e lfucache.Entry)   {}
+func (p *stalePolicy) Removed(e lfucache.Entry)    {}
+func (p *stalePolicy) Victim() lfucache.Entry      { return *p.first }
+func (p *stalePolicy) Added(e lfucache.Entry) {
+	if p.first == nil {
+		p.first = &e
+	}
+}
+
+func TestSelectorStaleVictim(t *testing.T) {
+	c := lfucache.New(2, lfucache.WithPolicy(&stalePolicy{}))
+
+	// The first item is removed while the node freelist is full, so its
+	// node is dropped rather than reused
+	c.Insert("test1", 42)
+	c.Insert("test2", 43)
+	c.Delete("test2")
+	if err := c.Resize(1); err != nil {
+		t.Fatal(err)
+	}
+	c.Delete("test1")
+
+	c.Insert("test3", 44)
+	c.Insert("test4", 45)
+	if _, ok := c.Peek("test4"); !ok || c.Len() != 1 {
+		t.Errorf("Unexpected size %d", c.Len())
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSelectorPolicy(t *testing.T) {
+	p := newFIFOPolicy()
+	c := lfucache.New(3, lfucache.WithPolicy(p))
+
+	c.Insert("test1", 42)
+	c.Insert("test2", 43)
+	c.Insert("test3", 44)
+	for i := 0; i < 10; i++ {
+		c.Access("test1")
+	}
+	c.Access("test2")
+	c.Insert("test4", 45) // should remove test1 which is oldest, despite its usage
+
+	if _, ok := c.Peek("test1"); ok {
+		t.Error("test1 was not removed")
+	}
+	if u, _ := c.Usage("test2"); u != 1 {
+		t.Errorf("Unexpected usage %d", u)
+	}
+
+	c.Delete("test3")
+	c.Insert("test2", 46) // replaced, so now the newest
+	c.Insert("test5", 47)
+	c.Insert("test6", 48) // should remove test4
+
+	for key, exp := range map[string]bool{"test2": true, "test4": false, "test5": true, "test6": true} {
+		if _, ok := c.Peek(key); ok != exp {
+			t.Errorf("Unexpected presence %v of %s", ok, key)
+		}
+	}
+	if len(p.elems) != c.Len() {
+		t.Errorf("Policy tracks %d items, cache has %d", len(p.elems), c.Len())
+	}
+	if err := c.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+// negativePolicy demotes accessed items to a negative usage count
+type negativePolicy struct{}
+
+func (negativePolicy) Promote(usage int) int       { return -1 }
+func (negativePolicy) TieBreak() lfucache.TieBreak { return lfucache.TieBreakOldest }
+
+func TestNegativePromote(t *testing.T) {
+	c := lfucache.New(3, lfucache.WithPolicy(negativePolicy{}))
+
+	c.Insert("test1", 42)
+	if _, ok := c.Access("test1"); !ok {
+		t.Fatal("test1 missing")
+	}
+	if u, _ := c.Usage("test1"); u != 0 {
+		t.Errorf("Unexpected usage %d", u)
+	}
+	if err := c.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLRUPolicy(t *testing.T) {
+	c := lfucache.New(3, lfucache.WithPolicy(lfucache.LRU))
+
+	c.Insert("test1", 42)
+	c.Insert("test2", 43)
+	c.Insert("test3", 44)
+	for i := 0; i < 10; i++ {
+		c.Access("test1")
+	}
+	c.Access("test2")
+	c.Insert("test4", 45) // should remove test3 which is least recently used
+
+	if _, ok := c.Access("test3"); ok {
+		t.Error("test3 was not removed")
+	}
+
+	s := c.Statistics()
+	if s.Hits != 11 || s.Misses != 1 || s.LenFreq0 != 3 || s.FreqListLen != 1 {
+		t.Errorf("Unexpected statistics %+v", s)
+	}
+}
+
+func TestTieBreak(t *testing.T) {
+	cost := lfucache.WithCost(func(key, value interface{}) int {
+		return value.(int)
+	})
+
+	cases := []struct {
+		name     string
+		opts     []lfucache.Option
+		policy   lfucache.Policy
+		expected []string
+		trials   int // Runs of a random tie break, which must pick more than one victim
+	}{
+		{"Oldest", []lfucache.Option{lfucache.WithTieBreak(lfucache.TieBreakOldest)}, lfucache.LFU, []string{"test1"}, 0},
+		{"Newest", []lfucache.Option{lfucache.WithTieBreak(lfucache.TieBreakNewest)}, lfucache.LFU, []string{"test4"}, 0},
+		{"NewestFromPolicy", nil, lfucache.LFUMRU, []string{"test4"}, 0},
+		{"OverridePolicy", []lfucache.Option{lfucache.WithTieBreak(lfucache.TieBreakOldest)}, lfucache.LFUMRU, []string{"test1"}, 0},
+		{"Random", []lfucache.Option{lfucache.WithTieBreak(lfucache.TieBreakRandom)}, lfucache.LFU, []string{"test1", "test2", "test3", "test4"}, 200},
+		{"LargestCost", []lfucache.Option{lfucache.WithTieBreak(lfucache.TieBreakLargestCost), cost}, lfucache.LFU, []string{"test3"}, 0},
+		{"LargestCostWithoutCost", []lfucache.Option{lfucache.WithTieBreak(lfucache.TieBreakLargestCost)}, lfucache.LFU, []string{"test1"}, 0},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			trials := tc.trials
+			if trials == 0 {
+				trials = 1
+			}
+
+			seen := make(map[string]bool)
+			for i := 0; i < trials; i++ {
+				// The policy option goes last, to check that the tie break
+				// option takes precedence regardless of order
+				c := lfucache.New(5, append(tc.opts, lfucache.WithPolicy(tc.policy))...)
+
+				c.Insert("test0", 41)
+				c.Access("test0") // usage=1, never a candidate
+				c.Insert("test1", 42)
+				c.Insert("test2", 43)
+				c.Insert("test3", 47)
+				c.Insert("test4", 44)
+				c.Insert("test5", 45)
+
+				var evicted []string
+				for _, key := range []string{"test0", "test1", "test2", "test3", "test4", "test5"} {
+					if _, ok := c.Access(key); !ok {
+						evicted = append(evicted, key)
+					}
+				}
+
+				if len(evicted) != 1 {
+					t.Fatalf("Unexpected evictions %v", evicted)
+				}
+				found := false
+				for _, key := range tc.expected {
+					found = found || evicted[0] == key
+				}
+				if !found {
+					t.Fatalf("Evicted %s, expected one of %v", evicted[0], tc.expected)
+				}
+				seen[evicted[0]] = true
+			}
+
+			if tc.trials > 1 && len(seen) < 2 {
+				t.Errorf("Random tie break always evicted %v", seen)
+			}
+		})
+	}
+}
+
+func TestTieBreakRandomSamples(t *testing.T) {
+	seen := make(map[interface{}]bool)
+	for i := 0; i < 100; i++ {
+		c := lfucache.New(16, lfucache.WithTieBreak(lfucache.TieBreakRandom))
+		exp := make(chan interface{}, 1)
+		c.Evictions(exp)
+
+		for j := 0; j < 17; j++ {
+			c.Insert(j, j)
+		}
+		seen[<-exp] = true
+	}
+
+	// Only the oldest items are candidates, and more than one of them should
+	// come up
+	for k := range seen {
+		if k.(int) >= 8 {
+			t.Errorf("Evicted %v, outside of the sample", k)
+		}
+	}
+	if len(seen) < 2 {
+		t.Errorf("Random tie break always evicted %v", seen)
+	}
+}
@@ -0,0 +1,70 @@
+package lfucache
+
+// Nodes and frequency nodes removed from the cache are kept on freelists,
+// linked through their next pointers, and reused by later inserts and
+// accesses. Each freelist holds at most as many entries as the cache
+// capacity, which is enough for the cache to reach a steady state where
+// Insert() and Access() do not allocate.
+
+// allocNode returns a zeroed node, from the freelist if possible
+func (c *Cache) allocNode() *node {
+	n := c.freeNodes
+	if n == nil {
+		return &node{}
+	}
+
+	c.freeNodes = n.next
+	c.numFreeNodes--
+	n.next = nil
+	return n
+}
+
+// releaseNode zeroes a node that is no longer part of the cache and puts it
+// on the freelist, unless the freelist is full. It is zeroed either way, as
+// a Selector may still hold an Entry for it.
+func (c *Cache) releaseNode(n *node) {
+	*n = node{}
+	if c.numFreeNodes >= c.capacity {
+		return
+	}
+
+	n.next = c.freeNodes
+	c.freeNodes = n
+	c.numFreeNodes++
+}
+
+// allocFrequencyNode returns a zeroed frequency node, from the freelist if
+// possible
+func (c *Cache) allocFrequencyNode() *frequencyNode {
+	fn := c.freeFrequencyNodes
+	if fn == nil {
+		return &frequencyNode{}
+	}
+
+	c.freeFrequencyNodes = fn.next
+	c.numFreeFrequencyNodes--
+	fn.next = nil
+	return fn
+}
+
+// releaseFrequencyNode puts a frequency node that is no longer part of the
+// frequency list on the freelist, unless the freelist is full
+func (c *Cache) releaseFrequencyNode(fn *frequencyNode) {
+	if c.numFreeFrequencyNodes >= c.capacity {
+		return
+	}
+
+	*fn = frequencyNode{next: c.freeFrequencyNodes}
+	c.freeFrequencyNodes = fn
+	c.numFreeFrequencyNodes++
+}
+
+// trimFreelists drops freelist entries in excess of the capacity
+func (c *Cache) trimFreelists() {
+	for c.numFreeNodes > c.capacity {
+		c.allocNode()
+	}
+	for c.numFreeFrequencyNodes > c.capacity {
+		c.allocFrequencyNode()
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// ErrServerClosed is returned by the Serve methods of the protocol servers
+// after they have been closed.
+var ErrServerClosed = errors.New("server closed")
+
+// Acceptor accepts connections on any number of listeners, serving each
+// connection in a new goroutine, and closes all of them on Close. It is
+// the connection handling shared by the protocol servers. The zero value is
+// ready for use.
+type Acceptor struct {
+	mut       sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+// Serve accepts connections on the listener and calls serve for each of
+// them in a new goroutine. The connection is closed when serve returns. It
+// always returns a non-nil error, ErrServerClosed after Close.
+func (a *Acceptor) Serve(l net.Listener, serve func(net.Conn)) error {
+	if !a.track(l, nil) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer a.untrack(l, nil)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if a.isClosed() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		if !a.track(nil, conn) {
+			conn.Close()
+			return ErrServerClosed
+		}
+
+		go func() {
+			defer func() {
+				a.untrack(nil, conn)
+				conn.Close()
+			}()
+			serve(conn)
+		}()
+	}
+}
+
+// Close closes all listeners and connections.
+func (a *Acceptor) Close() error {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	a.closed = true
+	var err error
+	for l := range a.listeners {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	for c := range a.conns {
+		c.Close()
+	}
+	return err
+}
+
+// track adds a listener or connection to the set closed by Close. Returns
+// false if the acceptor is already closed.
+func (a *Acceptor) track(l net.Listener, c net.Conn) bool {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	if a.closed {
+		return false
+	}
+	if l != nil {
+		if a.listeners == nil {
+			a.listeners = make(map[net.Listener]struct{})
+		}
+		a.listeners[l] = struct{}{}
+	}
+	if c != nil {
+		if a.conns == nil {
+			a.conns = make(map[net.Conn]struct{})
+		}
+		a.conns[c] = struct{}{}
+	}
+	return true
+}
+
+func (a *Acceptor) untrack(l net.Listener, c net.Conn) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	if l != nil {
+		delete(a.listeners, l)
+	}
+	if c != nil {
+		delete(a.conns, c)
+	}
+}
+
+func (a *Acceptor) isClosed() bool {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	return a.closed
+}
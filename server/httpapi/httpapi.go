@@ -0,0 +1,327 @@
+// Package httpapi serves a server.Store over HTTP, for running the cache as
+// a sidecar.
+//
+// The following endpoints are provided:
+//
+//	GET    /keys/{key}  the value of the item, 404 if there is none
+//	PUT    /keys/{key}  stores the request body, expiring after the ttl query parameter if given
+//	DELETE /keys/{key}  deletes the item, 404 if there is none
+//	GET    /stats       the store statistics as JSON
+//	POST   /resize      sets the capacity given as {"Capacity": n}
+//	GET    /evictions   a Server-Sent Events stream of evicted items
+//
+// Eviction events are delivered without blocking the cache. A client that
+// does not keep up misses events rather than stalling the store; the
+// number of missed events is available as Dropped in the statistics.
+package httpapi // import "github.com/calmh/deprecated_lfucache/server/httpapi"
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/calmh/deprecated_lfucache"
+	"github.com/calmh/deprecated_lfucache/server"
+)
+
+const (
+	// DefaultMaxItemSize is the largest value accepted unless overridden
+	// by Handler.MaxItemSize.
+	DefaultMaxItemSize = 1 << 20
+
+	// DefaultFeedBuffer is the number of eviction events buffered per
+	// client unless overridden by Handler.FeedBuffer.
+	DefaultFeedBuffer = 1024
+
+	// DefaultKeepAlive is the interval between keepalive comments on idle
+	// eviction streams unless overridden by Handler.KeepAlive.
+	DefaultKeepAlive = 15 * time.Second
+)
+
+const keysPrefix = "/keys/"
+
+// Handler serves the HTTP API on behalf of a store.
+type Handler struct {
+	MaxItemSize int           // Largest value accepted, DefaultMaxItemSize if zero
+	FeedBuffer  int           // Eviction events buffered per client, DefaultFeedBuffer if zero
+	KeepAlive   time.Duration // Keepalive interval of eviction streams, DefaultKeepAlive if zero
+
+	store     *server.Store
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Stats is the JSON representation of the store statistics returned by
+// /stats.
+type Stats struct {
+	Len               int
+	Cap               int
+	Inserts           int
+	Hits              int
+	Misses            int
+	Deletes           int
+	Expired           int
+	Evictions         int
+	EvictionsByReason map[string]int
+	Dropped           int
+	FreqListLen       int
+	LenFreq0          int
+}
+
+// Eviction is the JSON representation of an evicted item, sent as the data
+// of "eviction" events on /evictions.
+type Eviction struct {
+	Key    string
+	Size   int    // Length of the value
+	CAS    uint64 // Identifies the version of the item evicted
+	Reason string // Why the item was evicted, such as "capacity" or "replace"
+}
+
+// ResizeRequest is the request body expected by /resize.
+type ResizeRequest struct {
+	Capacity int
+}
+
+// NewHandler returns a handler for the store.
+func NewHandler(store *server.Store) *Handler {
+	return &Handler{
+		store:  store,
+		closed: make(chan struct{}),
+	}
+}
+
+// Close ends all eviction streams, current and future. Long lived streams
+// otherwise prevent http.Server.Shutdown from completing.
+func (h *Handler) Close() error {
+	h.closeOnce.Do(func() {
+		close(h.closed)
+	})
+	return nil
+}
+
+// ServeHTTP routes the request to the endpoint.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, keysPrefix):
+		key := strings.TrimPrefix(r.URL.Path, keysPrefix)
+		if key == "" {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			h.get(w, key)
+		case http.MethodPut:
+			h.put(w, r, key)
+		case http.MethodDelete:
+			h.delete(w, key)
+		default:
+			methodNotAllowed(w, "GET, HEAD, PUT, DELETE")
+		}
+
+	case r.URL.Path == "/stats":
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			methodNotAllowed(w, "GET, HEAD")
+			return
+		}
+		writeJSON(w, h.stats())
+
+	case r.URL.Path == "/resize":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, "POST")
+			return
+		}
+		h.resize(w, r)
+
+	case r.URL.Path == "/evictions":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, "GET")
+			return
+		}
+		h.evictions(w, r)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *Handler) get(w http.ResponseWriter, key string) {
+	it, ok := h.store.Get(key)
+	if !ok {
+		http.Error(w, "no such key", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if !it.Expires.IsZero() {
+		w.Header().Set("Expires", it.Expires.UTC().Format(http.TimeFormat))
+	}
+	w.Write(it.Value)
+}
+
+func (h *Handler) put(w http.ResponseWriter, r *http.Request, key string) {
+	it := server.Item{Key: key}
+	if ttl := r.URL.Query().Get("ttl"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid ttl", http.StatusBadRequest)
+			return
+		}
+		it.Expires = time.Now().Add(d)
+	}
+
+	value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(h.maxItemSize())))
+	if err != nil {
+		var mberr *http.MaxBytesError
+		if errors.As(err, &mberr) {
+			http.Error(w, "value too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	it.Value = value
+
+	if _, err := h.store.Set(it); err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) delete(w http.ResponseWriter, key string) {
+	if !h.store.Delete(key) {
+		http.Error(w, "no such key", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) stats() Stats {
+	st := h.store.Statistics()
+	s := Stats{
+		Len:               st.Len,
+		Cap:               st.Cap,
+		Inserts:           st.Inserts,
+		Hits:              st.Hits,
+		Misses:            st.Misses,
+		Deletes:           st.Deletes,
+		Expired:           st.Expired,
+		Evictions:         st.Evictions,
+		EvictionsByReason: make(map[string]int, len(st.EvictionsByReason)),
+		Dropped:           st.Dropped,
+		FreqListLen:       st.FreqListLen,
+		LenFreq0:          st.LenFreq0,
+	}
+	for r, n := range st.EvictionsByReason {
+		s.EvictionsByReason[lfucache.EvictReason(r).String()] = n
+	}
+	return s
+}
+
+func (h *Handler) resize(w http.ResponseWriter, r *http.Request) {
+	var req ResizeRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&req); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.store.Resize(req.Capacity); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// evictions streams eviction events until the client goes away or the
+// handler is closed
+func (h *Handler) evictions(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	feed := make(chan server.Eviction, h.feedBuffer())
+	h.store.Evictions(feed)
+	defer h.store.UnregisterEvictions(feed)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(h.keepAlive())
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case ev := <-feed:
+			if err := writeEviction(w, ev); err != nil {
+				return
+			}
+			// Events already waiting are sent together
+			for n := len(feed); n > 0; n-- {
+				if err := writeEviction(w, <-feed); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-r.Context().Done():
+			return
+
+		case <-h.closed:
+			return
+		}
+	}
+}
+
+func (h *Handler) maxItemSize() int {
+	if h.MaxItemSize > 0 {
+		return h.MaxItemSize
+	}
+	return DefaultMaxItemSize
+}
+
+func (h *Handler) feedBuffer() int {
+	if h.FeedBuffer > 0 {
+		return h.FeedBuffer
+	}
+	return DefaultFeedBuffer
+}
+
+func (h *Handler) keepAlive() time.Duration {
+	if h.KeepAlive > 0 {
+		return h.KeepAlive
+	}
+	return DefaultKeepAlive
+}
+
+func writeEviction(w io.Writer, ev server.Eviction) error {
+	data, err := json.Marshal(Eviction{Key: ev.Item.Key, Size: len(ev.Item.Value), CAS: ev.Item.CAS, Reason: ev.Reason.String()})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: eviction\ndata: %s\n\n", data)
+	return err
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
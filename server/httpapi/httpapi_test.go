@@ -0,0 +1,143 @@
+package httpapi_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/calmh/deprecated_lfucache"
+	"github.com/calmh/deprecated_lfucache/server"
+	"github.com/calmh/deprecated_lfucache/server/httpapi"
+)
+
+func startServer(t *testing.T, capacity int) (*httptest.Server, *httpapi.Handler) {
+	h := httpapi.NewHandler(server.NewStore(lfucache.New(capacity)))
+	h.MaxItemSize = 16
+	srv := httptest.NewServer(h)
+	t.Cleanup(func() {
+		h.Close()
+		srv.Close()
+	})
+	return srv, h
+}
+
+// do performs the request and returns the status code and body
+func do(t *testing.T, method, url, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	bs, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(bs)
+}
+
+func TestKeys(t *testing.T) {
+	srv, _ := startServer(t, 10)
+
+	cases := []struct {
+		method, path, body string
+		status             int
+		response           string
+	}{
+		{"GET", "/keys/test1", "", 404, "no such key\n"},
+		{"PUT", "/keys/test1", "a", 204, ""},
+		{"GET", "/keys/test1", "", 200, "a"},
+		{"PUT", "/keys/test1", "b", 204, ""},
+		{"GET", "/keys/test1", "", 200, "b"},
+		{"PUT", "/keys/test2?ttl=1m", "c", 204, ""},
+		{"PUT", "/keys/test2?ttl=-1s", "c", 400, "invalid ttl\n"},
+		{"PUT", "/keys/test3", "01234567890123456", 413, "value too large\n"},
+		{"DELETE", "/keys/test1", "", 204, ""},
+		{"DELETE", "/keys/test1", "", 404, "no such key\n"},
+		{"POST", "/keys/test1", "", 405, "Method Not Allowed\n"},
+		{"GET", "/keys/", "", 404, "404 page not found\n"},
+		{"GET", "/bogus", "", 404, "404 page not found\n"},
+	}
+	for _, tc := range cases {
+		status, body := do(t, tc.method, srv.URL+tc.path, tc.body)
+		if status != tc.status || body != tc.response {
+			t.Errorf("%s %s: got %d %q, expected %d %q", tc.method, tc.path, status, body, tc.status, tc.response)
+		}
+	}
+}
+
+func TestStatsAndResize(t *testing.T) {
+	srv, _ := startServer(t, 10)
+
+	for _, key := range []string{"test1", "test2", "test3"} {
+		do(t, "PUT", srv.URL+"/keys/"+key, "a")
+	}
+	do(t, "GET", srv.URL+"/keys/test3", "")
+	do(t, "GET", srv.URL+"/keys/test4", "")
+
+	if status, body := do(t, "POST", srv.URL+"/resize", `{"Capacity": 0}`); status != 400 {
+		t.Errorf("Invalid resize: got %d %q", status, body)
+	}
+	if status, body := do(t, "POST", srv.URL+"/resize", `{"Capacity": 1}`); status != 204 {
+		t.Errorf("Resize: got %d %q", status, body)
+	}
+
+	status, body := do(t, "GET", srv.URL+"/stats", "")
+	if status != 200 {
+		t.Fatalf("Stats: got %d %q", status, body)
+	}
+	var st httpapi.Stats
+	if err := json.Unmarshal([]byte(body), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Len != 1 || st.Cap != 1 || st.Inserts != 3 || st.Hits != 1 || st.Misses != 1 || st.EvictionsByReason["resize"] != 2 {
+		t.Errorf("Unexpected statistics %+v", st)
+	}
+}
+
+func TestEvictions(t *testing.T) {
+	srv, h := startServer(t, 1)
+	h.FeedBuffer = 2
+
+	resp, err := http.Get(srv.URL + "/evictions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Unexpected content type %q", ct)
+	}
+
+	do(t, "PUT", srv.URL+"/keys/test1", "a")
+	do(t, "PUT", srv.URL+"/keys/test2", "bb")
+
+	r := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 3 {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	expected := []string{"event: eviction\n", `data: {"Key":"test1","Size":1,"CAS":1,"Reason":"capacity"}` + "\n", "\n"}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Errorf("Line %d is %q, expected %q", i, lines[i], expected[i])
+		}
+	}
+
+	// Close ends the stream
+	h.Close()
+	if _, err := io.ReadAll(r); err != nil {
+		t.Error(err)
+	}
+}
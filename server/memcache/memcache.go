@@ -0,0 +1,507 @@
+// Package memcache serves a server.Store over the memcached text protocol.
+//
+// The storage commands set, add, replace and cas, the retrieval commands
+// get and gets, and delete, touch, flush_all, stats, version, verbosity
+// and quit are supported. Expiry times follow memcached: zero means never,
+// values up to thirty days are relative to now and larger values are
+// absolute Unix times.
+package memcache // import "github.com/calmh/deprecated_lfucache/server/memcache"
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/calmh/deprecated_lfucache/server"
+)
+
+// DefaultMaxItemSize is the largest value accepted unless overridden by
+// Server.MaxItemSize.
+const DefaultMaxItemSize = 1 << 20
+
+// Version is reported by the version command and in stats.
+const Version = "1.6.0-lfucache"
+
+const (
+	maxKeyLen      = 250
+	maxLineLen     = 64 << 10
+	maxRelativeExp = 30 * 24 * 60 * 60
+)
+
+// ErrServerClosed is returned by Serve after Close has been called.
+var ErrServerClosed = server.ErrServerClosed
+
+// Server speaks the memcached text protocol on behalf of a store.
+type Server struct {
+	MaxItemSize int // Largest value accepted, DefaultMaxItemSize if zero
+
+	store    *server.Store
+	started  time.Time
+	acceptor server.Acceptor
+
+	flushMut    sync.Mutex
+	flushTimer  *time.Timer // Pending delayed flush_all, if any
+	flushClosed bool
+
+	currConns  int64
+	totalConns uint64
+	cmdGet     uint64
+	cmdSet     uint64
+	cmdTouch   uint64
+	cmdFlush   uint64
+	touchHits  uint64
+	deleteHits uint64
+	deleteMiss uint64
+	casHits    uint64
+	casBadval  uint64
+}
+
+// NewServer returns a server for the store.
+func NewServer(store *server.Store) *Server {
+	return &Server{
+		store:   store,
+		started: time.Now(),
+	}
+}
+
+// ListenAndServe listens on the TCP address and serves connections on it.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on the listener and serves each of them in a
+// new goroutine. It always returns a non-nil error, ErrServerClosed after
+// Close.
+func (s *Server) Serve(l net.Listener) error {
+	return s.acceptor.Serve(l, s.ServeConn)
+}
+
+// Close closes all listeners and connections and cancels any delayed
+// flush_all. Serve returns ErrServerClosed from then on.
+func (s *Server) Close() error {
+	s.flushMut.Lock()
+	s.flushClosed = true
+	s.scheduleFlush(0)
+	s.flushMut.Unlock()
+
+	return s.acceptor.Close()
+}
+
+// ServeConn serves a single connection until the client quits, the
+// connection fails or the server is closed.
+func (s *Server) ServeConn(conn net.Conn) {
+	atomic.AddInt64(&s.currConns, 1)
+	atomic.AddUint64(&s.totalConns, 1)
+	defer func() {
+		atomic.AddInt64(&s.currConns, -1)
+		conn.Close()
+	}()
+
+	r := bufio.NewReaderSize(conn, maxLineLen)
+	w := bufio.NewWriter(conn)
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			if err == errLineTooLong {
+				w.WriteString("CLIENT_ERROR line too long\r\n")
+				w.Flush()
+			}
+			return
+		}
+
+		if quit := s.handle(line, r, w); quit {
+			w.Flush()
+			return
+		}
+
+		// Responses to pipelined commands are sent together
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handle executes a single command and writes the response. Returns true
+// when the connection should be closed.
+func (s *Server) handle(line []byte, r *bufio.Reader, w *bufio.Writer) bool {
+	fields := bytes.Fields(line)
+	if len(fields) == 0 {
+		w.WriteString("ERROR\r\n")
+		return false
+	}
+
+	args := fields[1:]
+	switch string(fields[0]) {
+	case "get":
+		return s.get(args, w, false)
+	case "gets":
+		return s.get(args, w, true)
+	case "set", "add", "replace", "cas":
+		return s.storage(string(fields[0]), args, r, w)
+	case "delete":
+		return s.delete(args, w)
+	case "touch":
+		return s.touch(args, w)
+	case "flush_all":
+		return s.flushAll(args, w)
+	case "stats":
+		return s.stats(args, w)
+	case "version":
+		w.WriteString("VERSION " + Version + "\r\n")
+	case "verbosity":
+		reply(w, noreply(args), "OK")
+	case "quit":
+		return true
+	default:
+		w.WriteString("ERROR\r\n")
+	}
+	return false
+}
+
+func (s *Server) get(keys [][]byte, w *bufio.Writer, withCAS bool) bool {
+	if len(keys) == 0 {
+		w.WriteString("ERROR\r\n")
+		return false
+	}
+
+	for _, key := range keys {
+		atomic.AddUint64(&s.cmdGet, 1)
+		it, ok := s.store.Get(string(key))
+		if !ok {
+			continue
+		}
+
+		w.WriteString("VALUE ")
+		w.Write(key)
+		w.WriteByte(' ')
+		w.WriteString(strconv.FormatUint(uint64(it.Flags), 10))
+		w.WriteByte(' ')
+		w.WriteString(strconv.Itoa(len(it.Value)))
+		if withCAS {
+			w.WriteByte(' ')
+			w.WriteString(strconv.FormatUint(it.CAS, 10))
+		}
+		w.WriteString("\r\n")
+		w.Write(it.Value)
+		w.WriteString("\r\n")
+	}
+	w.WriteString("END\r\n")
+	return false
+}
+
+// storage handles the storage commands, of the form
+//
+//	<command> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply]
+func (s *Server) storage(cmd string, args [][]byte, r *bufio.Reader, w *bufio.Writer) bool {
+	n := 4
+	if cmd == "cas" {
+		n = 5
+	}
+	if len(args) < n || len(args) > n+1 || (len(args) == n+1 && string(args[n]) != "noreply") {
+		w.WriteString("ERROR\r\n")
+		return false
+	}
+
+	// The arguments refer to the read buffer, so the key must be copied
+	// before reading the data
+	key := string(args[0])
+	flags, err1 := strconv.ParseUint(string(args[1]), 10, 32)
+	exptime, err2 := strconv.ParseInt(string(args[2]), 10, 64)
+	size, err3 := strconv.Atoi(string(args[3]))
+	var cas uint64
+	var err4 error
+	if cmd == "cas" {
+		cas, err4 = strconv.ParseUint(string(args[4]), 10, 64)
+	}
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || size < 0 {
+		w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return true
+	}
+
+	if size > s.maxItemSize() {
+		// Skip the data, so that the connection can be kept
+		if _, err := r.Discard(size + 2); err != nil {
+			return true
+		}
+		w.WriteString("SERVER_ERROR object too large for cache\r\n")
+		return false
+	}
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return true
+	}
+	if data[size] != '\r' || data[size+1] != '\n' {
+		w.WriteString("CLIENT_ERROR bad data chunk\r\n")
+		return true
+	}
+
+	if !validKey(key) {
+		w.WriteString("CLIENT_ERROR bad key\r\n")
+		return false
+	}
+
+	atomic.AddUint64(&s.cmdSet, 1)
+	it := server.Item{
+		Key:     key,
+		Value:   data[:size:size],
+		Flags:   uint32(flags),
+		Expires: expires(exptime),
+	}
+
+	var res string
+	var err error
+	switch cmd {
+	case "set":
+		_, err = s.store.Set(it)
+		res = "STORED"
+	case "add":
+		var ok bool
+		_, ok, err = s.store.Add(it)
+		res = storedOr(ok)
+	case "replace":
+		var ok bool
+		_, ok, err = s.store.Replace(it)
+		res = storedOr(ok)
+	case "cas":
+		var result server.CASResult
+		switch _, result, err = s.store.CompareAndSwap(it, cas); result {
+		case server.CASStored:
+			atomic.AddUint64(&s.casHits, 1)
+			res = "STORED"
+		case server.CASExists:
+			atomic.AddUint64(&s.casBadval, 1)
+			res = "EXISTS"
+		default:
+			res = "NOT_FOUND"
+		}
+	}
+	if err != nil {
+		res = "SERVER_ERROR " + err.Error()
+	}
+
+	reply(w, len(args) == n+1, res)
+	return false
+}
+
+func storedOr(ok bool) string {
+	if ok {
+		return "STORED"
+	}
+	return "NOT_STORED"
+}
+
+// delete handles "delete <key> [0] [noreply]", the zero being accepted for
+// compatibility with old clients
+func (s *Server) delete(args [][]byte, w *bufio.Writer) bool {
+	nr := noreply(args)
+	if nr {
+		args = args[:len(args)-1]
+	}
+	if len(args) == 2 && string(args[1]) == "0" {
+		args = args[:1]
+	}
+	if len(args) != 1 {
+		w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return false
+	}
+
+	if s.store.Delete(string(args[0])) {
+		atomic.AddUint64(&s.deleteHits, 1)
+		reply(w, nr, "DELETED")
+	} else {
+		atomic.AddUint64(&s.deleteMiss, 1)
+		reply(w, nr, "NOT_FOUND")
+	}
+	return false
+}
+
+// touch handles "touch <key> <exptime> [noreply]"
+func (s *Server) touch(args [][]byte, w *bufio.Writer) bool {
+	nr := noreply(args)
+	if nr {
+		args = args[:len(args)-1]
+	}
+	if len(args) != 2 {
+		w.WriteString("ERROR\r\n")
+		return false
+	}
+	exptime, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		w.WriteString("CLIENT_ERROR invalid exptime argument\r\n")
+		return false
+	}
+
+	atomic.AddUint64(&s.cmdTouch, 1)
+	if s.store.Touch(string(args[0]), expires(exptime)) {
+		atomic.AddUint64(&s.touchHits, 1)
+		reply(w, nr, "TOUCHED")
+	} else {
+		reply(w, nr, "NOT_FOUND")
+	}
+	return false
+}
+
+// flushAll handles "flush_all [delay] [noreply]"
+func (s *Server) flushAll(args [][]byte, w *bufio.Writer) bool {
+	nr := noreply(args)
+	if nr {
+		args = args[:len(args)-1]
+	}
+	if len(args) > 1 {
+		w.WriteString("ERROR\r\n")
+		return false
+	}
+
+	atomic.AddUint64(&s.cmdFlush, 1)
+	if len(args) == 1 {
+		delay, err := strconv.Atoi(string(args[0]))
+		if err != nil || delay < 0 {
+			w.WriteString("CLIENT_ERROR bad command line format\r\n")
+			return false
+		}
+		if delay > 0 {
+			s.flushMut.Lock()
+			s.scheduleFlush(time.Duration(delay) * time.Second)
+			s.flushMut.Unlock()
+			reply(w, nr, "OK")
+			return false
+		}
+	}
+
+	// Like a delayed flush, an immediate one replaces any pending flush
+	s.flushMut.Lock()
+	s.scheduleFlush(0)
+	s.flushMut.Unlock()
+	s.store.Flush()
+	reply(w, nr, "OK")
+	return false
+}
+
+// scheduleFlush replaces any pending delayed flush with one after delay,
+// or with none if delay is zero or the server is closed. Called with
+// flushMut held.
+func (s *Server) scheduleFlush(delay time.Duration) {
+	if s.flushTimer != nil {
+		s.flushTimer.Stop()
+		s.flushTimer = nil
+	}
+	if delay > 0 && !s.flushClosed {
+		s.flushTimer = time.AfterFunc(delay, s.store.Flush)
+	}
+}
+
+func (s *Server) stats(args [][]byte, w *bufio.Writer) bool {
+	if len(args) != 0 {
+		w.WriteString("ERROR\r\n")
+		return false
+	}
+
+	st := s.store.Statistics()
+	now := time.Now()
+	stat := func(name string, value interface{}) {
+		fmt.Fprintf(w, "STAT %s %v\r\n", name, value)
+	}
+
+	stat("pid", os.Getpid())
+	stat("uptime", int64(now.Sub(s.started)/time.Second))
+	stat("time", now.Unix())
+	stat("version", Version)
+	stat("curr_connections", atomic.LoadInt64(&s.currConns))
+	stat("total_connections", atomic.LoadUint64(&s.totalConns))
+	stat("cmd_get", atomic.LoadUint64(&s.cmdGet))
+	stat("cmd_set", atomic.LoadUint64(&s.cmdSet))
+	stat("cmd_flush", atomic.LoadUint64(&s.cmdFlush))
+	stat("cmd_touch", atomic.LoadUint64(&s.cmdTouch))
+	stat("get_hits", st.Hits)
+	stat("get_misses", st.Misses)
+	stat("get_expired", st.Expired)
+	stat("delete_hits", atomic.LoadUint64(&s.deleteHits))
+	stat("delete_misses", atomic.LoadUint64(&s.deleteMiss))
+	stat("cas_hits", atomic.LoadUint64(&s.casHits))
+	stat("cas_badval", atomic.LoadUint64(&s.casBadval))
+	stat("touch_hits", atomic.LoadUint64(&s.touchHits))
+	stat("touch_misses", atomic.LoadUint64(&s.cmdTouch)-atomic.LoadUint64(&s.touchHits))
+	stat("curr_items", st.Len)
+	stat("total_items", st.Inserts)
+	stat("evictions", st.Evicted())
+	stat("limit_maxitems", st.Cap)
+	stat("lfu_frequency_buckets", st.FreqListLen)
+	stat("lfu_items_unused", st.LenFreq0)
+	w.WriteString("END\r\n")
+	return false
+}
+
+func (s *Server) maxItemSize() int {
+	if s.MaxItemSize > 0 {
+		return s.MaxItemSize
+	}
+	return DefaultMaxItemSize
+}
+
+var errLineTooLong = errors.New("line too long")
+
+// readLine returns the next command line without the line terminator
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, errLineTooLong
+	}
+	if err != nil {
+		return nil, err
+	}
+	line = bytes.TrimSuffix(line[:len(line)-1], []byte{'\r'})
+	return line, nil
+}
+
+func noreply(args [][]byte) bool {
+	return len(args) > 0 && string(args[len(args)-1]) == "noreply"
+}
+
+func reply(w *bufio.Writer, noreply bool, msg string) {
+	if noreply {
+		return
+	}
+	w.WriteString(msg)
+	w.WriteString("\r\n")
+}
+
+func validKey(key string) bool {
+	if len(key) == 0 || len(key) > maxKeyLen {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if b := key[i]; b <= ' ' || b == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// expires converts a memcached expiry time to an absolute time
+func expires(exptime int64) time.Time {
+	switch {
+	case exptime == 0:
+		return time.Time{}
+	case exptime < 0:
+		return time.Unix(1, 0)
+	case exptime <= maxRelativeExp:
+		return time.Now().Add(time.Duration(exptime) * time.Second)
+	default:
+		return time.Unix(exptime, 0)
+	}
+}
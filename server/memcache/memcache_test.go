@@ -0,0 +1,207 @@
+package memcache_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/calmh/deprecated_lfucache"
+	"github.com/calmh/deprecated_lfucache/server"
+	"github.com/calmh/deprecated_lfucache/server/memcache"
+)
+
+// client is a minimal memcached text protocol client
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func startServer(t *testing.T, capacity int, opts ...lfucache.Option) *client {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := memcache.NewServer(server.NewStore(lfucache.New(capacity, opts...)))
+	s.MaxItemSize = 16
+	done := make(chan error)
+	go func() { done <- s.Serve(l) }()
+	t.Cleanup(func() {
+		s.Close()
+		if err := <-done; err != memcache.ErrServerClosed {
+			t.Errorf("Unexpected Serve error %v", err)
+		}
+	})
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *client) send(req string) {
+	c.t.Helper()
+	if _, err := fmt.Fprint(c.conn, req); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// do sends the request and returns the response lines up to and including
+// the first line starting with one of the terminators
+func (c *client) do(req string, terminators ...string) []string {
+	c.t.Helper()
+	c.send(req)
+
+	var lines []string
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("Reading response to %q: %v", req, err)
+		}
+		line = strings.TrimSuffix(line, "\r\n")
+		lines = append(lines, line)
+		for _, term := range terminators {
+			if strings.HasPrefix(line, term) {
+				return lines
+			}
+		}
+	}
+}
+
+// expect sends a request with a single line response and checks it
+func (c *client) expect(req, resp string) {
+	c.t.Helper()
+	if got := c.do(req, "")[0]; got != resp {
+		c.t.Errorf("Response to %q was %q, expected %q", req, got, resp)
+	}
+}
+
+func (c *client) stats() map[string]string {
+	c.t.Helper()
+	stats := make(map[string]string)
+	for _, line := range c.do("stats\r\n", "END") {
+		if fields := strings.Fields(line); len(fields) == 3 {
+			stats[fields[1]] = fields[2]
+		}
+	}
+	return stats
+}
+
+func TestStorageCommands(t *testing.T) {
+	c := startServer(t, 10)
+
+	c.expect("set test1 5 0 2\r\nab\r\n", "STORED")
+	c.expect("add test1 0 0 2\r\ncd\r\n", "NOT_STORED")
+	c.expect("replace test2 0 0 2\r\ncd\r\n", "NOT_STORED")
+	c.expect("add test2 0 0 2\r\ncd\r\n", "STORED")
+	c.expect("replace test2 7 0 3\r\nefg\r\n", "STORED")
+
+	lines := c.do("get test1 test2 test3\r\n", "END")
+	expected := []string{"VALUE test1 5 2", "ab", "VALUE test2 7 3", "efg", "END"}
+	if strings.Join(lines, "|") != strings.Join(expected, "|") {
+		t.Errorf("Unexpected get response %q", lines)
+	}
+
+	lines = c.do("gets test1\r\n", "END")
+	var cas uint64
+	if len(lines) != 3 {
+		t.Fatalf("Unexpected gets response %q", lines)
+	}
+	if _, err := fmt.Sscanf(lines[0], "VALUE test1 5 2 %d", &cas); err != nil {
+		t.Fatalf("Unexpected gets response %q", lines)
+	}
+	c.expect(fmt.Sprintf("cas test1 0 0 1 %d\r\nx\r\n", cas+100), "EXISTS")
+	c.expect(fmt.Sprintf("cas test1 0 0 1 %d\r\nx\r\n", cas), "STORED")
+	c.expect(fmt.Sprintf("cas test1 0 0 1 %d\r\nx\r\n", cas), "EXISTS")
+	c.expect("cas test3 0 0 1 1\r\nx\r\n", "NOT_FOUND")
+
+	c.expect("delete test1\r\n", "DELETED")
+	c.expect("delete test1\r\n", "NOT_FOUND")
+	c.expect("touch test2 100\r\n", "TOUCHED")
+	c.expect("touch test1 100\r\n", "NOT_FOUND")
+
+	// Negative expiry times expire items immediately
+	c.expect("set test3 0 -1 1\r\nx\r\n", "STORED")
+	c.expect("get test3\r\n", "END")
+
+	// noreply suppresses the response, so the next response is for get
+	c.send("set test4 0 0 1 noreply\r\nx\r\n")
+	c.expect("get test4\r\n", "VALUE test4 0 1")
+	c.do("", "END")
+
+	c.expect("flush_all\r\n", "OK")
+	c.expect("get test2\r\n", "END")
+}
+
+func TestDelayedFlush(t *testing.T) {
+	c := startServer(t, 10)
+
+	// A later flush_all replaces the pending delayed one
+	c.expect("flush_all 1\r\n", "OK")
+	c.expect("flush_all 100\r\n", "OK")
+	c.expect("set test1 0 0 1\r\nx\r\n", "STORED")
+	time.Sleep(1500 * time.Millisecond)
+	c.expect("get test1\r\n", "VALUE test1 0 1")
+	c.do("", "END")
+}
+
+func TestTooLarge(t *testing.T) {
+	// Fits an item with a short value but not one of MaxItemSize
+	c := startServer(t, 10, lfucache.WithMaxBytes(235))
+
+	c.expect("set test1 0 0 1\r\na\r\n", "STORED")
+	c.expect("set test1 0 0 16\r\n0123456789abcdef\r\n", "SERVER_ERROR object too large for cache")
+	c.expect("get test1\r\n", "END")
+	c.expect("add test1 0 0 16\r\n0123456789abcdef\r\n", "SERVER_ERROR object too large for cache")
+}
+
+func TestErrors(t *testing.T) {
+	c := startServer(t, 10)
+
+	c.expect("bogus\r\n", "ERROR")
+	c.expect("set test1 0 0 17\r\n01234567890123456\r\n", "SERVER_ERROR object too large for cache")
+	c.expect("set test1 0 0 1\r\nx\r\n", "STORED")
+	c.expect("set test1 0 0 1\r\nxy\r\n", "CLIENT_ERROR bad data chunk")
+}
+
+func TestStats(t *testing.T) {
+	c := startServer(t, 2)
+
+	c.expect("set test1 0 0 1\r\na\r\n", "STORED")
+	c.expect("set test2 0 0 1\r\nb\r\n", "STORED")
+	c.do("get test1 test2 test1\r\n", "END")
+	c.expect("set test3 0 0 1\r\nc\r\n", "STORED") // evicts test2
+	c.expect("get test2\r\n", "END")
+
+	stats := c.stats()
+	expected := map[string]string{
+		"cmd_get":          "4",
+		"cmd_set":          "3",
+		"get_hits":         "3",
+		"get_misses":       "1",
+		"curr_items":       "2",
+		"total_items":      "3",
+		"evictions":        "1",
+		"limit_maxitems":   "2",
+		"curr_connections": "1",
+	}
+	for k, v := range expected {
+		if stats[k] != v {
+			t.Errorf("Stat %s is %q, expected %q", k, stats[k], v)
+		}
+	}
+}
+
+func TestPipelining(t *testing.T) {
+	c := startServer(t, 10)
+
+	lines := c.do("set test1 0 0 1\r\na\r\nget test1\r\nversion\r\n", "VERSION")
+	if len(lines) != 5 || lines[0] != "STORED" || lines[1] != "VALUE test1 0 1" || lines[3] != "END" {
+		t.Errorf("Unexpected pipelined responses %q", lines)
+	}
+}
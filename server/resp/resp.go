@@ -0,0 +1,472 @@
+// Package resp serves a server.Store over RESP, the Redis protocol.
+//
+// A subset of the Redis commands is supported: GET, SET (with the EX, PX,
+// NX and XX options), DEL, EXISTS, TTL, PTTL, INFO, PING, COMMAND and
+// QUIT, and OBJECT FREQ, which returns the LFU use count of the item.
+// Commands are accepted both as RESP arrays and inline, and may be
+// pipelined.
+package resp // import "github.com/calmh/deprecated_lfucache/server/resp"
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/calmh/deprecated_lfucache/server"
+)
+
+// DefaultMaxBulkLen is the longest key or value accepted unless overridden
+// by Server.MaxBulkLen.
+const DefaultMaxBulkLen = 1 << 20
+
+// Version is reported as the server version by INFO.
+const Version = "7.0.0-lfucache"
+
+const (
+	maxArgs    = 1024
+	maxLineLen = 64 << 10
+	maxEchoLen = 128 // Longest client supplied name echoed in an error
+)
+
+// ErrServerClosed is returned by Serve after Close has been called.
+var ErrServerClosed = server.ErrServerClosed
+
+// Server speaks RESP on behalf of a store.
+type Server struct {
+	MaxBulkLen int // Longest key or value accepted, DefaultMaxBulkLen if zero
+
+	store    *server.Store
+	started  time.Time
+	acceptor server.Acceptor
+
+	currConns  int64
+	totalConns uint64
+	commands   uint64
+}
+
+// NewServer returns a server for the store.
+func NewServer(store *server.Store) *Server {
+	return &Server{
+		store:   store,
+		started: time.Now(),
+	}
+}
+
+// ListenAndServe listens on the TCP address and serves connections on it.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on the listener and serves each of them in a
+// new goroutine. It always returns a non-nil error, ErrServerClosed after
+// Close.
+func (s *Server) Serve(l net.Listener) error {
+	return s.acceptor.Serve(l, s.ServeConn)
+}
+
+// Close closes all listeners and connections. Serve returns
+// ErrServerClosed from then on.
+func (s *Server) Close() error {
+	return s.acceptor.Close()
+}
+
+// ServeConn serves a single connection until the client quits, the
+// connection fails or the server is closed.
+func (s *Server) ServeConn(conn net.Conn) {
+	atomic.AddInt64(&s.currConns, 1)
+	atomic.AddUint64(&s.totalConns, 1)
+	defer func() {
+		atomic.AddInt64(&s.currConns, -1)
+		conn.Close()
+	}()
+
+	r := bufio.NewReaderSize(conn, maxLineLen)
+	w := bufio.NewWriter(conn)
+	for {
+		args, err := s.readCommand(r)
+		if err != nil {
+			var perr protocolError
+			if errors.As(err, &perr) {
+				writeError(w, "ERR Protocol error: "+string(perr))
+				w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		atomic.AddUint64(&s.commands, 1)
+		if quit := s.handle(args, w); quit {
+			w.Flush()
+			return
+		}
+
+		// Responses to pipelined commands are sent together
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handle executes a single command and writes the reply. Returns true when
+// the connection should be closed.
+func (s *Server) handle(args [][]byte, w *bufio.Writer) bool {
+	cmd := strings.ToLower(string(args[0]))
+	switch cmd {
+	case "get":
+		if len(args) != 2 {
+			break
+		}
+		if it, ok := s.store.Get(string(args[1])); ok {
+			writeBulk(w, it.Value)
+		} else {
+			writeNull(w)
+		}
+		return false
+
+	case "set":
+		if len(args) < 3 {
+			break
+		}
+		s.set(args, w)
+		return false
+
+	case "del", "exists":
+		if len(args) < 2 {
+			break
+		}
+		n := 0
+		for _, key := range args[1:] {
+			var ok bool
+			if cmd == "del" {
+				ok = s.store.Delete(string(key))
+			} else {
+				_, ok = s.store.Peek(string(key))
+			}
+			if ok {
+				n++
+			}
+		}
+		writeInt(w, int64(n))
+		return false
+
+	case "ttl", "pttl":
+		if len(args) != 2 {
+			break
+		}
+		it, ok := s.store.Peek(string(args[1]))
+		switch {
+		case !ok:
+			writeInt(w, -2)
+		case it.Expires.IsZero():
+			writeInt(w, -1)
+		case cmd == "ttl":
+			writeInt(w, int64((time.Until(it.Expires)+time.Second/2)/time.Second))
+		default:
+			writeInt(w, int64(time.Until(it.Expires)/time.Millisecond))
+		}
+		return false
+
+	case "object":
+		if len(args) < 2 {
+			break
+		}
+		if !strings.EqualFold(string(args[1]), "freq") {
+			writeError(w, fmt.Sprintf("ERR unknown subcommand '%s'", printable(args[1], maxEchoLen)))
+			return false
+		}
+		if len(args) != 3 {
+			writeError(w, "ERR wrong number of arguments for 'object|freq' command")
+			return false
+		}
+		if usage, ok := s.store.Usage(string(args[2])); ok {
+			writeInt(w, int64(usage))
+		} else {
+			writeNull(w)
+		}
+		return false
+
+	case "info":
+		if len(args) > 2 {
+			break
+		}
+		section := "default"
+		if len(args) == 2 {
+			section = strings.ToLower(string(args[1]))
+		}
+		writeBulk(w, s.info(section))
+		return false
+
+	case "ping":
+		switch len(args) {
+		case 1:
+			writeSimple(w, "PONG")
+		case 2:
+			writeBulk(w, args[1])
+		default:
+			writeArgsError(w, cmd)
+		}
+		return false
+
+	case "command":
+		// Clients query the command table on connect; an empty one tells
+		// them nothing, which is fine.
+		w.WriteString("*0\r\n")
+		return false
+
+	case "quit":
+		writeSimple(w, "OK")
+		return true
+
+	default:
+		writeError(w, fmt.Sprintf("ERR unknown command '%s'", printable(args[0], maxEchoLen)))
+		return false
+	}
+
+	writeArgsError(w, cmd)
+	return false
+}
+
+// set handles "SET key value [EX seconds|PX milliseconds] [NX|XX]"
+func (s *Server) set(args [][]byte, w *bufio.Writer) {
+	it := server.Item{Key: string(args[1]), Value: args[2]}
+	var nx, xx bool
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToLower(string(args[i])); opt {
+		case "ex", "px":
+			if !it.Expires.IsZero() || i == len(args)-1 {
+				writeError(w, "ERR syntax error")
+				return
+			}
+			i++
+			n, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				writeError(w, "ERR value is not an integer or out of range")
+				return
+			}
+			unit := time.Second
+			if opt == "px" {
+				unit = time.Millisecond
+			}
+			if n <= 0 || n > math.MaxInt64/int64(unit) {
+				writeError(w, "ERR invalid expire time in 'set' command")
+				return
+			}
+			it.Expires = time.Now().Add(time.Duration(n) * unit)
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		default:
+			writeError(w, "ERR syntax error")
+			return
+		}
+	}
+	if nx && xx {
+		writeError(w, "ERR syntax error")
+		return
+	}
+
+	stored := true
+	var err error
+	switch {
+	case nx:
+		_, stored, err = s.store.Add(it)
+	case xx:
+		_, stored, err = s.store.Replace(it)
+	default:
+		_, err = s.store.Set(it)
+	}
+	if err != nil {
+		writeError(w, "ERR "+err.Error())
+	} else if stored {
+		writeSimple(w, "OK")
+	} else {
+		writeNull(w)
+	}
+}
+
+// info returns the INFO text for the section
+func (s *Server) info(section string) []byte {
+	st := s.store.Statistics()
+	all := section == "default" || section == "all" || section == "everything"
+
+	var buf bytes.Buffer
+	add := func(name string, lines ...interface{}) {
+		if !all && section != strings.ToLower(name) {
+			return
+		}
+		if buf.Len() > 0 {
+			buf.WriteString("\r\n")
+		}
+		fmt.Fprintf(&buf, "# %s\r\n", name)
+		for i := 0; i < len(lines); i += 2 {
+			fmt.Fprintf(&buf, "%s:%v\r\n", lines[i], lines[i+1])
+		}
+	}
+
+	add("Server",
+		"redis_version", Version,
+		"uptime_in_seconds", int64(time.Since(s.started)/time.Second))
+	add("Clients",
+		"connected_clients", atomic.LoadInt64(&s.currConns))
+	add("Stats",
+		"total_connections_received", atomic.LoadUint64(&s.totalConns),
+		"total_commands_processed", atomic.LoadUint64(&s.commands),
+		"keyspace_hits", st.Hits,
+		"keyspace_misses", st.Misses,
+		"expired_keys", st.Expired,
+		"evicted_keys", st.Evicted())
+	add("LFU",
+		"lfu_capacity", st.Cap,
+		"lfu_frequency_buckets", st.FreqListLen,
+		"lfu_items_unused", st.LenFreq0)
+	add("Keyspace",
+		"db0", fmt.Sprintf("keys=%d", st.Len))
+	return buf.Bytes()
+}
+
+func (s *Server) maxBulkLen() int {
+	if s.MaxBulkLen > 0 {
+		return s.MaxBulkLen
+	}
+	return DefaultMaxBulkLen
+}
+
+// protocolError is a malformed request, reported to the client before
+// closing the connection
+type protocolError string
+
+func (e protocolError) Error() string {
+	return string(e)
+}
+
+// readCommand reads the next command, either a RESP array of bulk strings
+// or an inline command line
+func (s *Server) readCommand(r *bufio.Reader) ([][]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) == 0 || line[0] != '*' {
+		// The line refers to the read buffer
+		return bytes.Fields(append([]byte(nil), line...)), nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > maxArgs {
+		return nil, protocolError("invalid multibulk length")
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+
+	args := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, protocolError(fmt.Sprintf("expected '$', got '%c'", firstByte(line)))
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 || size > s.maxBulkLen() {
+			return nil, protocolError("invalid bulk length")
+		}
+
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		if data[size] != '\r' || data[size+1] != '\n' {
+			return nil, protocolError("invalid bulk terminator")
+		}
+		args = append(args, data[:size:size])
+	}
+	return args, nil
+}
+
+// readLine returns the next line without the line terminator
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, protocolError("too big inline request")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(line[:len(line)-1], []byte{'\r'}), nil
+}
+
+func firstByte(bs []byte) byte {
+	if len(bs) == 0 {
+		return ' '
+	}
+	return bs[0]
+}
+
+func writeSimple(w *bufio.Writer, s string) {
+	w.WriteByte('+')
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
+
+// printable returns at most max bytes of the client supplied b, with
+// control characters replaced by spaces so that they cannot break the
+// framing of a reply
+func printable(b []byte, max int) string {
+	if len(b) > max {
+		b = b[:max]
+	}
+	return strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, string(b))
+}
+
+func writeError(w *bufio.Writer, msg string) {
+	w.WriteByte('-')
+	w.WriteString(msg)
+	w.WriteString("\r\n")
+}
+
+func writeArgsError(w *bufio.Writer, cmd string) {
+	writeError(w, fmt.Sprintf("ERR wrong number of arguments for '%s' command", cmd))
+}
+
+func writeInt(w *bufio.Writer, n int64) {
+	w.WriteByte(':')
+	w.WriteString(strconv.FormatInt(n, 10))
+	w.WriteString("\r\n")
+}
+
+func writeBulk(w *bufio.Writer, bs []byte) {
+	w.WriteByte('$')
+	w.WriteString(strconv.Itoa(len(bs)))
+	w.WriteString("\r\n")
+	w.Write(bs)
+	w.WriteString("\r\n")
+}
+
+func writeNull(w *bufio.Writer) {
+	w.WriteString("$-1\r\n")
+}
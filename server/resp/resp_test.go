@@ -0,0 +1,213 @@
+package resp_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/calmh/deprecated_lfucache"
+	"github.com/calmh/deprecated_lfucache/server"
+	"github.com/calmh/deprecated_lfucache/server/resp"
+)
+
+// client is a minimal RESP client. Replies are decoded to strings for
+// simple and bulk strings, int64 for integers, error for errors, nil for
+// null and []interface{} for arrays.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func startServer(t *testing.T, capacity int, opts ...lfucache.Option) *client {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := resp.NewServer(server.NewStore(lfucache.New(capacity, opts...)))
+	s.MaxBulkLen = 16
+	done := make(chan error)
+	go func() { done <- s.Serve(l) }()
+	t.Cleanup(func() {
+		s.Close()
+		if err := <-done; err != resp.ErrServerClosed {
+			t.Errorf("Unexpected Serve error %v", err)
+		}
+	})
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func encode(args ...string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return b.String()
+}
+
+func (c *client) send(req string) {
+	c.t.Helper()
+	if _, err := fmt.Fprint(c.conn, req); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) read() interface{} {
+	c.t.Helper()
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+
+	switch line[0] {
+	case '+':
+		return line[1:]
+	case '-':
+		return fmt.Errorf("%s", line[1:])
+	case ':':
+		n, _ := strconv.ParseInt(line[1:], 10, 64)
+		return n
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := c.r.Read(buf); err != nil {
+			c.t.Fatal(err)
+		}
+		return string(buf[:n])
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		arr := []interface{}{}
+		for i := 0; i < n; i++ {
+			arr = append(arr, c.read())
+		}
+		return arr
+	}
+	c.t.Fatalf("Unexpected reply %q", line)
+	return nil
+}
+
+// expect sends the command and checks the reply
+func (c *client) expect(expected interface{}, args ...string) {
+	c.t.Helper()
+	c.send(encode(args...))
+	got := c.read()
+	if err, ok := got.(error); ok {
+		got = "-" + err.Error()
+	}
+	if !reflect.DeepEqual(got, expected) {
+		c.t.Errorf("Reply to %q was %#v, expected %#v", args, got, expected)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	c := startServer(t, 10)
+
+	c.expect("PONG", "PING")
+	c.expect(nil, "GET", "test1")
+	c.expect("OK", "SET", "test1", "a")
+	c.expect("a", "get", "test1")
+	c.expect(nil, "SET", "test1", "b", "NX")
+	c.expect(nil, "SET", "test2", "b", "XX")
+	c.expect("OK", "SET", "test2", "b", "NX", "EX", "100")
+	c.expect(int64(2), "EXISTS", "test1", "test2", "test3")
+
+	c.expect(int64(-1), "TTL", "test1")
+	c.expect(int64(100), "TTL", "test2")
+	c.expect(int64(-2), "TTL", "test3")
+	c.expect("OK", "SET", "test3", "c", "PX", "1")
+	time.Sleep(5 * time.Millisecond)
+	c.expect(nil, "GET", "test3")
+
+	c.expect(int64(1), "OBJECT", "FREQ", "test1")
+	c.expect("a", "GET", "test1")
+	c.expect(int64(2), "OBJECT", "FREQ", "test1")
+	c.expect(int64(0), "OBJECT", "FREQ", "test2")
+	c.expect(nil, "OBJECT", "FREQ", "test3")
+
+	c.expect(int64(1), "DEL", "test1", "test3")
+	c.expect(int64(0), "EXISTS", "test1")
+	c.expect([]interface{}{}, "COMMAND")
+	c.expect("OK", "QUIT")
+}
+
+func TestErrors(t *testing.T) {
+	c := startServer(t, 10)
+
+	c.expect("-ERR unknown command 'BOGUS'", "BOGUS")
+	c.expect("-ERR wrong number of arguments for 'get' command", "GET")
+	c.expect("-ERR syntax error", "SET", "test1", "a", "NX", "XX")
+	c.expect("-ERR invalid expire time in 'set' command", "SET", "test1", "a", "EX", "0")
+	c.expect("-ERR value is not an integer or out of range", "SET", "test1", "a", "EX", "x")
+	c.expect("-ERR unknown subcommand 'ENCODING'", "OBJECT", "ENCODING", "test1")
+	c.expect("-ERR unknown command 'BOGUS  +OK'", "BOGUS\r\n+OK")
+	c.expect("-ERR invalid expire time in 'set' command", "SET", "test1", "a", "EX", "9223372036854775")
+	c.expect("-ERR invalid expire time in 'set' command", "SET", "test1", "a", "PX", "9223372036854775")
+	c.expect("OK", "SET", "test1", "a", "PX", "9223372036854")
+
+	c.send(encode("SET", "test1", "01234567890123456"))
+	if err, ok := c.read().(error); !ok || err.Error() != "ERR Protocol error: invalid bulk length" {
+		t.Errorf("Unexpected reply %v to oversized value", err)
+	}
+}
+
+func TestTooLarge(t *testing.T) {
+	// Fits an item with a short value but not one of MaxBulkLen
+	c := startServer(t, 10, lfucache.WithMaxBytes(235))
+
+	c.expect("OK", "SET", "test1", "a")
+	c.expect("-ERR object too large for cache", "SET", "test1", "0123456789abcdef")
+	c.expect(nil, "GET", "test1")
+}
+
+func TestPipeliningAndInline(t *testing.T) {
+	c := startServer(t, 10)
+
+	c.send(encode("SET", "test1", "a") + "GET test1\r\n" + encode("DEL", "test1") + "PING\r\n")
+	replies := []interface{}{c.read(), c.read(), c.read(), c.read()}
+	expected := []interface{}{"OK", "a", int64(1), "PONG"}
+	if !reflect.DeepEqual(replies, expected) {
+		t.Errorf("Unexpected pipelined replies %#v", replies)
+	}
+}
+
+func TestInfo(t *testing.T) {
+	c := startServer(t, 1)
+
+	c.expect("OK", "SET", "test1", "a")
+	c.expect("a", "GET", "test1")
+	c.expect("OK", "SET", "test2", "b")
+	c.expect(nil, "GET", "test1")
+
+	c.send(encode("INFO", "stats"))
+	info, _ := c.read().(string)
+	for _, line := range []string{"# Stats", "keyspace_hits:1", "keyspace_misses:1", "evicted_keys:1"} {
+		if !strings.Contains(info, line+"\r\n") {
+			t.Errorf("Missing %q in %q", line, info)
+		}
+	}
+	if strings.Contains(info, "# Server") {
+		t.Errorf("Unexpected section in %q", info)
+	}
+
+	c.send(encode("INFO"))
+	info, _ = c.read().(string)
+	if !strings.Contains(info, "db0:keys=1\r\n") || !strings.Contains(info, "lfu_capacity:1\r\n") {
+		t.Errorf("Unexpected info %q", info)
+	}
+}
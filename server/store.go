@@ -0,0 +1,337 @@
+// Package server provides a concurrency safe store of byte string items on
+// top of an lfucache.Cache. It holds the semantics shared by the network
+// protocol frontends in its subpackages: item flags, expiry times and
+// compare-and-swap tokens.
+//
+// Expired items are removed lazily, when next looked up. Eviction is left
+// to the cache, so a Store holds up to the capacity of the cache regardless
+// of the size of the items, unless the cache also has a memory limit set by
+// lfucache.WithMaxBytes.
+package server // import "github.com/calmh/deprecated_lfucache/server"
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/calmh/deprecated_lfucache"
+)
+
+// ErrTooLarge is returned when storing an item larger than the memory limit
+// of the cache. Any existing item with the same key is removed.
+var ErrTooLarge = errors.New("object too large for cache")
+
+// Store is a concurrency safe key-value store backed by an LFU cache.
+type Store struct {
+	mut     sync.Mutex
+	cache   *lfucache.Cache
+	cas     uint64
+	expired int
+	feeds   []chan<- Eviction
+	dropped int
+	now     func() time.Time
+}
+
+// Item is a value in the store along with its metadata.
+type Item struct {
+	Key     string
+	Value   []byte    // Must not be modified once stored or returned by the store
+	Flags   uint32    // Opaque to the store
+	Expires time.Time // Zero for items that do not expire
+	CAS     uint64    // Unique for each stored version of an item, set by the store
+}
+
+// Eviction is an item evicted from the store and the reason for it, as
+// sent to the channels registered by Evictions.
+type Eviction struct {
+	Item   Item
+	Reason lfucache.EvictReason
+}
+
+// CASResult is the outcome of a CompareAndSwap.
+type CASResult int
+
+const (
+	CASStored   CASResult = iota // The item was stored
+	CASExists                    // The item has been modified since the token was obtained
+	CASNotFound                  // There is no item with the key
+)
+
+// Statistics contains the statistics of the underlying cache and store
+// level counters.
+type Statistics struct {
+	lfucache.Statistics
+	Len     int // Number of items currently stored
+	Cap     int // Maximum number of items stored
+	Expired int // Number of items removed on lookup due to being expired, also counted as Deletes
+}
+
+// Evicted returns the number of items evicted for lack of room, by the
+// capacity, a Resize or the memory limit of the cache, as reported by the
+// protocol servers. Replaced items are not included.
+func (s Statistics) Evicted() int {
+	return s.EvictionsByReason[lfucache.EvictCapacity] +
+		s.EvictionsByReason[lfucache.EvictResize] +
+		s.EvictionsByReason[lfucache.EvictMemory]
+}
+
+// NewStore returns a store keeping its items in the cache. The cache must
+// not be used directly while the store is in use, other than under the
+// lock returned by Locker. The store adds an observer of its own to any
+// observer already set on the cache.
+func NewStore(cache *lfucache.Cache) *Store {
+	s := &Store{
+		cache: cache,
+		now:   time.Now,
+	}
+	cache.SetObserver(lfucache.MultiObserver(cache.Observer(), storeObserver{s: s}))
+	return s
+}
+
+// Locker returns the lock protecting the cache, for use with packages
+// inspecting it such as metrics and debughttp.
+func (s *Store) Locker() sync.Locker {
+	return &s.mut
+}
+
+// Get returns the item for the key and increases its use count.
+func (s *Store) Get(key string) (Item, bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.expire(key)
+	v, ok := s.cache.Access(key)
+	if !ok {
+		return Item{}, false
+	}
+	return *v.(*Item), true
+}
+
+// Peek returns the item for the key without increasing its use count.
+func (s *Store) Peek(key string) (Item, bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	it, ok := s.peek(key)
+	if !ok {
+		return Item{}, false
+	}
+	return *it, true
+}
+
+// Usage returns the use count of the item for the key in the cache.
+func (s *Store) Usage(key string) (int, bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.expire(key)
+	return s.cache.Usage(key)
+}
+
+// Set stores the item, replacing any existing item with the same key, and
+// returns its CAS token. Returns ErrTooLarge, removing any existing item,
+// if the item is larger than the memory limit of the cache.
+func (s *Store) Set(item Item) (uint64, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.set(item)
+}
+
+// Add stores the item unless there is already an item with the same key.
+// Returns the CAS token and true if the item was stored, or ErrTooLarge as
+// for Set.
+func (s *Store) Add(item Item) (uint64, bool, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if _, ok := s.peek(item.Key); ok {
+		return 0, false, nil
+	}
+	cas, err := s.set(item)
+	return cas, err == nil, err
+}
+
+// Replace stores the item only if there is already an item with the same
+// key. Returns the CAS token and true if the item was stored, or
+// ErrTooLarge as for Set.
+func (s *Store) Replace(item Item) (uint64, bool, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if _, ok := s.peek(item.Key); !ok {
+		return 0, false, nil
+	}
+	cas, err := s.set(item)
+	return cas, err == nil, err
+}
+
+// CompareAndSwap stores the item only if the existing item with the same
+// key has the given CAS token. Returns the new CAS token when stored. The
+// error is ErrTooLarge when the item matches but is rejected by the cache.
+func (s *Store) CompareAndSwap(item Item, cas uint64) (uint64, CASResult, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	old, ok := s.peek(item.Key)
+	if !ok {
+		return 0, CASNotFound, nil
+	}
+	if old.CAS != cas {
+		return 0, CASExists, nil
+	}
+	newCAS, err := s.set(item)
+	return newCAS, CASStored, err
+}
+
+// Delete deletes the item with the key and returns true. Returns false if
+// there was no such item.
+func (s *Store) Delete(key string) bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if _, ok := s.peek(key); !ok {
+		return false
+	}
+	return s.cache.Delete(key)
+}
+
+// Touch sets a new expiry time for the item with the key, without
+// affecting its use count, and returns true. Returns false if there was no
+// such item.
+func (s *Store) Touch(key string, expires time.Time) bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	it, ok := s.peek(key)
+	if !ok {
+		return false
+	}
+	it.Expires = expires
+	return true
+}
+
+// Flush removes all items. They are counted as deleted rather than evicted,
+// and are not reported to the channels registered by Evictions.
+func (s *Store) Flush() {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	for _, ku := range s.cache.Hottest(s.cache.Len()) {
+		s.cache.Delete(ku.Key)
+	}
+}
+
+// Resize changes the capacity of the cache, evicting items as necessary.
+func (s *Store) Resize(capacity int) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.cache.Resize(capacity)
+}
+
+// Evictions registers a channel to receive the items evicted from the
+// store. Evictions are sent without blocking and dropped when the channel
+// is full, counting towards the Dropped statistic, as by
+// lfucache.Cache.EvictionsNonBlocking. Items replaced by a new item with
+// the same key are reported as well, with lfucache.EvictReplace as the
+// reason.
+func (s *Store) Evictions(e chan<- Eviction) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.feeds = append(s.feeds, e)
+}
+
+// UnregisterEvictions removes a channel registered by Evictions.
+func (s *Store) UnregisterEvictions(e chan<- Eviction) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	for i := range s.feeds {
+		if s.feeds[i] == e {
+			s.feeds = append(s.feeds[:i], s.feeds[i+1:]...)
+			return
+		}
+	}
+}
+
+// Len returns the number of items in the store, including expired items
+// not yet removed.
+func (s *Store) Len() int {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.cache.Len()
+}
+
+// Statistics returns the store statistics.
+func (s *Store) Statistics() Statistics {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	st := s.cache.Statistics()
+	st.Dropped += s.dropped
+	return Statistics{
+		Statistics: st,
+		Len:        s.cache.Len(),
+		Cap:        s.cache.Cap(),
+		Expired:    s.expired,
+	}
+}
+
+// set stores the item with a new CAS token. Returns ErrTooLarge if the
+// cache rejected the item, leaving no item with the key.
+func (s *Store) set(item Item) (uint64, error) {
+	s.cas++
+	item.CAS = s.cas
+	s.cache.Insert(item.Key, &item)
+	if _, ok := s.cache.Peek(item.Key); !ok {
+		return 0, ErrTooLarge
+	}
+	return item.CAS, nil
+}
+
+// peek returns the unexpired item for the key, without increasing its use
+// count
+func (s *Store) peek(key string) (*Item, bool) {
+	s.expire(key)
+	v, ok := s.cache.Peek(key)
+	if !ok {
+		return nil, false
+	}
+	return v.(*Item), true
+}
+
+// expire removes the item for the key if it has expired
+func (s *Store) expire(key string) {
+	v, ok := s.cache.Peek(key)
+	if !ok {
+		return
+	}
+	if exp := v.(*Item).Expires; !exp.IsZero() && !s.now().Before(exp) {
+		s.cache.Delete(key)
+		s.expired++
+	}
+}
+
+// storeObserver sends the evictions from the cache to the channels
+// registered by Evictions. It is called with the store lock held.
+type storeObserver struct {
+	lfucache.NopObserver
+	s *Store
+}
+
+func (o storeObserver) OnEvict(e lfucache.Event) {
+	if len(o.s.feeds) == 0 {
+		return
+	}
+	ev := Eviction{Item: *e.Value.(*Item), Reason: e.Reason}
+	for _, feed := range o.s.feeds {
+		select {
+		case feed <- ev:
+		default:
+			o.s.dropped++
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/calmh/deprecated_lfucache"
+)
+
+func TestStoreExpiry(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s := NewStore(lfucache.New(10))
+	s.now = func() time.Time { return now }
+
+	s.Set(Item{Key: "test1", Value: []byte("a"), Expires: now.Add(time.Second)})
+	s.Set(Item{Key: "test2", Value: []byte("b")})
+
+	if _, ok := s.Get("test1"); !ok {
+		t.Error("test1 expired early")
+	}
+
+	now = now.Add(time.Second)
+	if _, ok := s.Get("test1"); ok {
+		t.Error("test1 did not expire")
+	}
+	if _, ok := s.Get("test2"); !ok {
+		t.Error("test2 expired")
+	}
+
+	if !s.Touch("test2", now.Add(time.Minute)) {
+		t.Error("Touch of test2 failed")
+	}
+	now = now.Add(time.Minute)
+	if _, ok, _ := s.Add(Item{Key: "test2", Value: []byte("c")}); !ok {
+		t.Error("Add over expired test2 failed")
+	}
+
+	st := s.Statistics()
+	if st.Expired != 2 || st.Len != 1 || st.Hits != 2 || st.Misses != 1 {
+		t.Errorf("Unexpected statistics %+v", st)
+	}
+}
+
+func TestStoreConditionalSets(t *testing.T) {
+	s := NewStore(lfucache.New(10))
+
+	if _, ok, _ := s.Replace(Item{Key: "test1", Value: []byte("a")}); ok {
+		t.Error("Replace of missing item succeeded")
+	}
+	cas, ok, _ := s.Add(Item{Key: "test1", Value: []byte("a")})
+	if !ok {
+		t.Error("Add of missing item failed")
+	}
+	if _, ok, _ := s.Add(Item{Key: "test1", Value: []byte("b")}); ok {
+		t.Error("Add of existing item succeeded")
+	}
+
+	if _, res, _ := s.CompareAndSwap(Item{Key: "test1", Value: []byte("c")}, cas+1); res != CASExists {
+		t.Errorf("Unexpected result %v for stale token", res)
+	}
+	newCAS, res, err := s.CompareAndSwap(Item{Key: "test1", Value: []byte("c")}, cas)
+	if err != nil || res != CASStored || newCAS == cas {
+		t.Errorf("Unexpected result %v, %d for current token", res, newCAS)
+	}
+	if _, res, _ := s.CompareAndSwap(Item{Key: "test2"}, cas); res != CASNotFound {
+		t.Errorf("Unexpected result %v for missing item", res)
+	}
+
+	if it, _ := s.Get("test1"); string(it.Value) != "c" || it.CAS != newCAS {
+		t.Errorf("Unexpected item %+v", it)
+	}
+
+	if u, ok := s.Usage("test1"); !ok || u != 1 {
+		t.Errorf("Unexpected usage %d", u)
+	}
+	if it, ok := s.Peek("test1"); !ok || string(it.Value) != "c" {
+		t.Errorf("Unexpected item %+v", it)
+	}
+	if u, _ := s.Usage("test1"); u != 1 {
+		t.Errorf("Peek changed usage to %d", u)
+	}
+
+	before := s.Statistics()
+	s.Flush()
+	if s.Len() != 0 {
+		t.Error("Flush left items")
+	}
+	if st := s.Statistics(); st.Evictions != before.Evictions || st.Deletes != before.Deletes+1 {
+		t.Errorf("Unexpected statistics %+v after flush", st)
+	}
+}
+
+func TestStoreEvictedMemory(t *testing.T) {
+	s := NewStore(lfucache.New(10, lfucache.WithMaxBytes(2500)))
+
+	value := make([]byte, 1000)
+	for _, key := range []string{"test1", "test2", "test3"} {
+		s.Set(Item{Key: key, Value: value})
+	}
+	if _, err := s.Set(Item{Key: "test3", Value: make([]byte, 5000)}); err != ErrTooLarge {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if it, ok := s.Get("test3"); ok {
+		t.Errorf("Stale item %+v after rejected Set", it)
+	}
+
+	st := s.Statistics()
+	if st.EvictionsByReason[lfucache.EvictMemory] == 0 || st.Evicted() != st.EvictionsByReason[lfucache.EvictMemory] {
+		t.Errorf("Unexpected evictions %d, %v", st.Evicted(), st.EvictionsByReason)
+	}
+	if st.Rejected != 1 {
+		t.Errorf("Unexpected statistics %+v", st)
+	}
+}
+
+func TestStoreEvictionsAndResize(t *testing.T) {
+	s := NewStore(lfucache.New(2))
+
+	evicted := make(chan Eviction, 1)
+	s.Evictions(evicted)
+
+	s.Set(Item{Key: "test1", Value: []byte("a")})
+	s.Set(Item{Key: "test2", Value: []byte("b")})
+	s.Get("test2")
+	if err := s.Resize(0); err != lfucache.ErrInvalidCapacity {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if err := s.Resize(1); err != nil {
+		t.Error(err)
+	}
+	if ev := <-evicted; ev.Item.Key != "test1" || ev.Reason != lfucache.EvictResize {
+		t.Errorf("Unexpected eviction %+v", ev)
+	}
+
+	s.Set(Item{Key: "test2", Value: []byte("bb")})
+	if ev := <-evicted; ev.Item.Key != "test2" || string(ev.Item.Value) != "b" || ev.Reason != lfucache.EvictReplace {
+		t.Errorf("Unexpected eviction %+v", ev)
+	}
+
+	s.Set(Item{Key: "test3", Value: []byte("c")})
+	s.Set(Item{Key: "test4", Value: []byte("d")}) // dropped
+	s.UnregisterEvictions(evicted)
+	s.Set(Item{Key: "test5", Value: []byte("e")})
+
+	if st := s.Statistics(); st.Dropped != 1 || st.Cap != 1 || st.Evictions != 5 {
+		t.Errorf("Unexpected statistics %+v", st)
+	}
+}
@@ -0,0 +1,141 @@
+package sim
+
+import (
+	"container/list"
+)
+
+// ARC is an Adaptive Replacement Cache, as described in "ARC: A
+// Self-Tuning, Low Overhead Replacement Cache" by N. Megiddo and D. S.
+// Modha. It balances between recency (t1) and frequency (t2), guided by
+// ghost lists (b1, b2) of recently evicted keys.
+type ARC struct {
+	capacity       int
+	p              int // target size of t1
+	t1, t2, b1, b2 *list.List
+	index          map[interface{}]*arcEntry
+}
+
+type arcEntry struct {
+	key   interface{}
+	value interface{}
+	list  *list.List
+	elem  *list.Element
+}
+
+// NewARC returns a new ARC cache with the given capacity.
+func NewARC(capacity int) *ARC {
+	return &ARC{
+		capacity: capacity,
+		t1:       list.New(),
+		t2:       list.New(),
+		b1:       list.New(),
+		b2:       list.New(),
+		index:    make(map[interface{}]*arcEntry, 2*capacity),
+	}
+}
+
+// Access returns the value for the key, moving it to the frequency list.
+func (c *ARC) Access(key interface{}) (interface{}, bool) {
+	e, ok := c.index[key]
+	if !ok || e.list == c.b1 || e.list == c.b2 {
+		return nil, false
+	}
+	c.move(e, c.t2)
+	return e.value, true
+}
+
+// Insert inserts the item, adapting the target size of the recency list if
+// the key was recently evicted.
+func (c *ARC) Insert(key interface{}, value interface{}) {
+	e, ok := c.index[key]
+	switch {
+	case ok && (e.list == c.t1 || e.list == c.t2):
+		e.value = value
+		c.move(e, c.t2)
+		return
+
+	case ok && e.list == c.b1:
+		c.p = min(c.capacity, c.p+max(1, c.b2.Len()/c.b1.Len()))
+		c.replace(false)
+		e.value = value
+		c.move(e, c.t2)
+		return
+
+	case ok && e.list == c.b2:
+		c.p = max(0, c.p-max(1, c.b1.Len()/c.b2.Len()))
+		c.replace(true)
+		e.value = value
+		c.move(e, c.t2)
+		return
+	}
+
+	if c.t1.Len()+c.b1.Len() == c.capacity {
+		if c.t1.Len() < c.capacity {
+			c.drop(c.b1.Back())
+			c.replace(false)
+		} else {
+			c.drop(c.t1.Back())
+		}
+	} else if total := c.t1.Len() + c.t2.Len() + c.b1.Len() + c.b2.Len(); total >= c.capacity {
+		if total == 2*c.capacity {
+			c.drop(c.b2.Back())
+		}
+		c.replace(false)
+	}
+
+	e = &arcEntry{key: key, value: value}
+	c.index[key] = e
+	c.move(e, c.t1)
+}
+
+// Delete deletes the item, returning true if it was present.
+func (c *ARC) Delete(key interface{}) bool {
+	e, ok := c.index[key]
+	if !ok || e.list == c.b1 || e.list == c.b2 {
+		return false
+	}
+	c.drop(e.elem)
+	return true
+}
+
+// Len returns the number of items in the cache.
+func (c *ARC) Len() int {
+	return c.t1.Len() + c.t2.Len()
+}
+
+// Cap returns the maximum number of items in the cache.
+func (c *ARC) Cap() int {
+	return c.capacity
+}
+
+// replace evicts an item from t1 or t2 to the corresponding ghost list
+func (c *ARC) replace(inB2 bool) {
+	if c.t1.Len() > 0 && (c.t1.Len() > c.p || (inB2 && c.t1.Len() == c.p)) {
+		e := c.t1.Back().Value.(*arcEntry)
+		e.value = nil
+		c.move(e, c.b1)
+	} else if c.t2.Len() > 0 {
+		e := c.t2.Back().Value.(*arcEntry)
+		e.value = nil
+		c.move(e, c.b2)
+	}
+}
+
+// move makes the entry the most recent in the list l
+func (c *ARC) move(e *arcEntry, l *list.List) {
+	if e.list != nil {
+		e.list.Remove(e.elem)
+	}
+	e.list = l
+	e.elem = l.PushFront(e)
+}
+
+// drop removes the entry in element el completely
+func (c *ARC) drop(el *list.Element) {
+	if el == nil {
+		return
+	}
+	e := el.Value.(*arcEntry)
+	e.list.Remove(el)
+	delete(c.index, e.key)
+}
@@ -0,0 +1,83 @@
+package sim
+
+import (
+	"container/list"
+)
+
+// LRU is a least recently used cache.
+type LRU struct {
+	capacity int
+	ll       *list.List
+	index    map[interface{}]*list.Element
+}
+
+type entry struct {
+	key   interface{}
+	value interface{}
+}
+
+// NewLRU returns a new LRU cache with the given capacity.
+func NewLRU(capacity int) *LRU {
+	return &LRU{
+		capacity: capacity,
+		ll:       list.New(),
+		index:    make(map[interface{}]*list.Element, capacity),
+	}
+}
+
+// Access returns the value for the key and marks it most recently used.
+func (c *LRU) Access(key interface{}) (interface{}, bool) {
+	e, ok := c.index[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*entry).value, true
+}
+
+// Insert inserts the item as the most recently used, evicting the least
+// recently used item if the cache is full.
+func (c *LRU) Insert(key interface{}, value interface{}) {
+	if e, ok := c.index[key]; ok {
+		e.Value.(*entry).value = value
+		c.ll.MoveToFront(e)
+		return
+	}
+	if c.ll.Len() >= c.capacity {
+		c.removeElement(c.ll.Back())
+	}
+	c.index[key] = c.ll.PushFront(&entry{key, value})
+}
+
+// Delete deletes the item, returning true if it was present.
+func (c *LRU) Delete(key interface{}) bool {
+	e, ok := c.index[key]
+	if ok {
+		c.removeElement(e)
+	}
+	return ok
+}
+
+// Len returns the number of items in the cache.
+func (c *LRU) Len() int {
+	return c.ll.Len()
+}
+
+// Cap returns the maximum number of items in the cache.
+func (c *LRU) Cap() int {
+	return c.capacity
+}
+
+// victim returns the key of the item that would be evicted next, if any
+func (c *LRU) victim() (interface{}, bool) {
+	e := c.ll.Back()
+	if e == nil {
+		return nil, false
+	}
+	return e.Value.(*entry).key, true
+}
+
+func (c *LRU) removeElement(e *list.Element) {
+	c.ll.Remove(e)
+	delete(c.index, e.Value.(*entry).key)
+}
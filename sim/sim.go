@@ -0,0 +1,142 @@
+// Package sim compares cache eviction policies on synthetic workloads.
+//
+// It contains reference implementations of LRU, ARC and TinyLFU behind the
+// common Policy interface, which lfucache.Cache also implements, and a
+// harness measuring hit ratio, time and allocations per request for each
+// combination of policy and workload.
+package sim // import "github.com/calmh/deprecated_lfucache/sim"
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/calmh/deprecated_lfucache"
+)
+
+// Policy is a cache with a particular eviction policy.
+type Policy interface {
+	Access(key interface{}) (interface{}, bool)
+	Insert(key interface{}, value interface{})
+	Delete(key interface{}) bool
+	Len() int
+	Cap() int
+}
+
+// Policies maps policy names to constructors for caches of the given
+// capacity.
+var Policies = map[string]func(capacity int) Policy{
+	"lfu":     func(capacity int) Policy { return lfucache.New(capacity) },
+	"lru":     func(capacity int) Policy { return NewLRU(capacity) },
+	"arc":     func(capacity int) Policy { return NewARC(capacity) },
+	"tinylfu": func(capacity int) Policy { return NewTinyLFU(capacity) },
+}
+
+// PolicyNames returns the names of all policies, sorted.
+func PolicyNames() []string {
+	var names []string
+	for name := range Policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Result is the outcome of running one workload against one policy.
+type Result struct {
+	Policy   string
+	Workload string
+	Capacity int
+	Requests int
+	Hits     int
+	Duration time.Duration
+	Allocs   uint64
+}
+
+// HitRatio returns the ratio of hits to requests.
+func (r Result) HitRatio() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return float64(r.Hits) / float64(r.Requests)
+}
+
+// NsPerOp returns the average time per request.
+func (r Result) NsPerOp() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return float64(r.Duration.Nanoseconds()) / float64(r.Requests)
+}
+
+// AllocsPerOp returns the average number of heap allocations per request.
+func (r Result) AllocsPerOp() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return float64(r.Allocs) / float64(r.Requests)
+}
+
+// Run replays the keys against the cache, accessing each key and inserting
+// it on a miss, and returns the result. The keys should be converted to
+// interface{} ahead of time so that the conversion is not measured.
+func Run(c Policy, keys []interface{}) Result {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	t0 := time.Now()
+
+	res := Result{Capacity: c.Cap(), Requests: len(keys)}
+	for _, key := range keys {
+		if _, ok := c.Access(key); ok {
+			res.Hits++
+		} else {
+			c.Insert(key, key)
+		}
+	}
+
+	res.Duration = time.Since(t0)
+	runtime.ReadMemStats(&after)
+	res.Allocs = after.Mallocs - before.Mallocs
+	return res
+}
+
+// Compare runs every workload against a new cache of each named policy and
+// returns the results, ordered by workload and then policy.
+func Compare(policies []string, workloads []Workload, capacity int) ([]Result, error) {
+	var results []Result
+	for _, w := range workloads {
+		keys := boxed(w.Keys)
+		for _, p := range policies {
+			newPolicy, ok := Policies[p]
+			if !ok {
+				return nil, fmt.Errorf("unknown policy %q", p)
+			}
+			res := Run(newPolicy(capacity), keys)
+			res.Policy = p
+			res.Workload = w.Name
+			results = append(results, res)
+		}
+	}
+	return results, nil
+}
+
+// WriteTable writes the results to w as an aligned table.
+func WriteTable(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "workload\tpolicy\tcapacity\thit ratio\tns/op\tallocs/op\t\n")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%.4f\t%.1f\t%.2f\t\n", r.Workload, r.Policy, r.Capacity, r.HitRatio(), r.NsPerOp(), r.AllocsPerOp())
+	}
+	return tw.Flush()
+}
+
+func boxed(keys []uint64) []interface{} {
+	res := make([]interface{}, len(keys))
+	for i, k := range keys {
+		res[i] = k
+	}
+	return res
+}
@@ -0,0 +1,170 @@
+package sim_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/calmh/deprecated_lfucache/sim"
+)
+
+func TestLRU(t *testing.T) {
+	c := sim.NewLRU(2)
+	c.Insert("test1", 42)
+	c.Insert("test2", 43)
+	c.Access("test1")
+	c.Insert("test3", 44) // evicts test2
+
+	if _, ok := c.Access("test2"); ok {
+		t.Error("test2 was not evicted")
+	}
+	if v, ok := c.Access("test1"); !ok || v.(int) != 42 {
+		t.Error("Didn't get the right value back from the cache (test1)")
+	}
+	if !c.Delete("test3") || c.Len() != 1 {
+		t.Error("Delete failed")
+	}
+}
+
+func TestARC(t *testing.T) {
+	c := sim.NewARC(2)
+	c.Insert("test1", 42)
+	c.Access("test1") // test1 to the frequency list
+	c.Insert("test2", 43)
+	c.Insert("test3", 44) // evicts test2, the recency list victim
+
+	if _, ok := c.Access("test2"); ok {
+		t.Error("test2 was not evicted")
+	}
+	if _, ok := c.Access("test1"); !ok {
+		t.Error("test1 was evicted")
+	}
+
+	// A ghost hit brings test2 back
+	c.Insert("test2", 45)
+	if v, ok := c.Access("test2"); !ok || v.(int) != 45 {
+		t.Error("Didn't get the right value back from the cache (test2)")
+	}
+	if c.Len() != 2 {
+		t.Errorf("Unexpected size %d", c.Len())
+	}
+}
+
+func TestTinyLFUAdmission(t *testing.T) {
+	c := sim.NewTinyLFU(2)
+	for i := 0; i < 5; i++ {
+		c.Access("test1")
+		c.Access("test2")
+	}
+	c.Insert("test1", 42)
+	c.Insert("test2", 43)
+
+	// A key never seen before does not displace the frequent ones
+	c.Access("test3")
+	c.Insert("test3", 44)
+	if _, ok := c.Access("test3"); ok {
+		t.Error("test3 was admitted")
+	}
+	if c.Len() != 2 {
+		t.Errorf("Unexpected size %d", c.Len())
+	}
+}
+
+// TestPoliciesStayWithinCapacity runs every workload against every policy
+// and verifies that capacity is respected and hit ratios are sane.
+func TestPoliciesStayWithinCapacity(t *testing.T) {
+	workloads := sim.StandardWorkloads(1000, 20000)
+	for _, name := range sim.PolicyNames() {
+		for _, w := range workloads {
+			c := sim.Policies[name](100)
+			for _, k := range w.Keys {
+				if _, ok := c.Access(k); !ok {
+					c.Insert(k, k)
+				}
+				if c.Len() > c.Cap() {
+					t.Fatalf("%s on %s: length %d exceeds capacity", name, w.Name, c.Len())
+				}
+			}
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	results, err := sim.Compare([]string{"lfu", "lru"}, []sim.Workload{sim.Loop(10, 1000)}, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Unexpected results %+v", results)
+	}
+	for _, r := range results {
+		// Everything fits, so only the first round misses
+		if r.Requests != 1000 || r.Hits != 990 {
+			t.Errorf("Unexpected result %+v", r)
+		}
+	}
+
+	var buf bytes.Buffer
+	sim.WriteTable(&buf, results)
+	if !strings.Contains(buf.String(), "0.9900") {
+		t.Errorf("Unexpected table:\n%s", buf.String())
+	}
+
+	if _, err := sim.Compare([]string{"nonexistent"}, nil, 1); err != nil {
+		t.Error("Unexpected error without workloads", err)
+	}
+	if _, err := sim.Compare([]string{"nonexistent"}, []sim.Workload{sim.Loop(10, 10)}, 1); err == nil {
+		t.Error("Expected error for unknown policy")
+	}
+}
+
+func TestStandardWorkloadsFewRequests(t *testing.T) {
+	for _, w := range sim.StandardWorkloads(1000, 5) {
+		if len(w.Keys) != 5 {
+			t.Errorf("Unexpected number of requests %d for %s", len(w.Keys), w.Name)
+		}
+	}
+}
+
+func TestScanRatio(t *testing.T) {
+	const keys = 1000
+	w := sim.Scan(keys, 10000, 100, 100, 1)
+
+	scanned := 0
+	for _, k := range w.Keys {
+		if k >= keys {
+			scanned++
+		}
+	}
+	if len(w.Keys) != 10000 || scanned != 5000 {
+		t.Errorf("Scanned %d of %d requests, expected half", scanned, len(w.Keys))
+	}
+}
+
+// BenchmarkPolicies reports hit ratio, ns/op and allocs/op for each policy
+// on each standard workload. Run with -benchmem.
+func BenchmarkPolicies(b *testing.B) {
+	const capacity = 1000
+	for _, w := range sim.StandardWorkloads(10*capacity, 100000) {
+		keys := make([]interface{}, len(w.Keys))
+		for i, k := range w.Keys {
+			keys[i] = k
+		}
+		for _, name := range sim.PolicyNames() {
+			b.Run(w.Name+"/"+name, func(b *testing.B) {
+				b.ReportAllocs()
+				c := sim.Policies[name](capacity)
+				hits := 0
+				for i := 0; i < b.N; i++ {
+					k := keys[i%len(keys)]
+					if _, ok := c.Access(k); ok {
+						hits++
+					} else {
+						c.Insert(k, k)
+					}
+				}
+				b.ReportMetric(float64(hits)/float64(b.N), "hit-ratio")
+			})
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package sim
+
+import (
+	"fmt"
+	"hash/maphash"
+)
+
+// TinyLFU is an LRU cache with the TinyLFU admission policy, as described in
+// "TinyLFU: A Highly Efficient Cache Admission Policy" by G. Einziger, R.
+// Friedman and B. Manes. A new item is only admitted when full if its
+// approximate recent access frequency exceeds that of the LRU victim. The
+// frequencies are kept in a count-min sketch that is halved periodically to
+// age out old history.
+type TinyLFU struct {
+	lru    *LRU
+	sketch sketch
+}
+
+// NewTinyLFU returns a new TinyLFU cache with the given capacity.
+func NewTinyLFU(capacity int) *TinyLFU {
+	return &TinyLFU{
+		lru:    NewLRU(capacity),
+		sketch: newSketch(capacity),
+	}
+}
+
+// Access returns the value for the key, recording the access.
+func (c *TinyLFU) Access(key interface{}) (interface{}, bool) {
+	c.sketch.add(key)
+	return c.lru.Access(key)
+}
+
+// Insert inserts the item, if admitted by the frequency filter.
+func (c *TinyLFU) Insert(key interface{}, value interface{}) {
+	if c.lru.Len() >= c.lru.Cap() {
+		if _, ok := c.lru.index[key]; !ok {
+			victim, _ := c.lru.victim()
+			if c.sketch.estimate(key) <= c.sketch.estimate(victim) {
+				return
+			}
+		}
+	}
+	c.lru.Insert(key, value)
+}
+
+// Delete deletes the item, returning true if it was present.
+func (c *TinyLFU) Delete(key interface{}) bool {
+	return c.lru.Delete(key)
+}
+
+// Len returns the number of items in the cache.
+func (c *TinyLFU) Len() int {
+	return c.lru.Len()
+}
+
+// Cap returns the maximum number of items in the cache.
+func (c *TinyLFU) Cap() int {
+	return c.lru.Cap()
+}
+
+const sketchDepth = 4
+
+// sketch is a count-min sketch of small saturating counters
+type sketch struct {
+	rows    [sketchDepth][]uint8
+	mask    uint64
+	seed    maphash.Seed
+	samples int
+	resetAt int
+}
+
+func newSketch(capacity int) sketch {
+	width := 16
+	for width < capacity {
+		width *= 2
+	}
+
+	s := sketch{
+		mask:    uint64(width - 1),
+		seed:    maphash.MakeSeed(),
+		resetAt: 10 * capacity,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+func (s *sketch) add(key interface{}) {
+	h := s.hash(key)
+	for i := range s.rows {
+		idx := s.index(h, i)
+		if s.rows[i][idx] < 15 {
+			s.rows[i][idx]++
+		}
+	}
+
+	s.samples++
+	if s.samples >= s.resetAt {
+		for i := range s.rows {
+			for j := range s.rows[i] {
+				s.rows[i][j] /= 2
+			}
+		}
+		s.samples /= 2
+	}
+}
+
+func (s *sketch) estimate(key interface{}) uint8 {
+	h := s.hash(key)
+	est := uint8(255)
+	for i := range s.rows {
+		if v := s.rows[i][s.index(h, i)]; v < est {
+			est = v
+		}
+	}
+	return est
+}
+
+func (s *sketch) hash(key interface{}) uint64 {
+	switch k := key.(type) {
+	case uint64:
+		return k
+	case int:
+		return uint64(k)
+	case string:
+		return maphash.String(s.seed, k)
+	default:
+		return maphash.String(s.seed, fmt.Sprint(k))
+	}
+}
+
+// index returns the counter index in row i for the key hash h, remixing
+// the hash differently for each row as integer keys are used as is
+func (s *sketch) index(h uint64, i int) uint64 {
+	h = (h + uint64(i)) * 0x9e3779b97f4a7c15
+	return (h >> 32) & s.mask
+}
@@ -0,0 +1,83 @@
+package sim
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// Workload is a named sequence of requested keys.
+type Workload struct {
+	Name string
+	Keys []uint64
+}
+
+// Zipf returns n requests for keys in [0, keys) following a Zipf
+// distribution with exponent s, which must be greater than one. Higher
+// exponents mean more skew towards the most popular keys.
+func Zipf(s float64, keys uint64, n int, seed int64) Workload {
+	z := rand.NewZipf(rand.New(rand.NewSource(seed)), s, 1, keys-1)
+	w := Workload{Name: fmt.Sprintf("zipf-%g", s), Keys: make([]uint64, n)}
+	for i := range w.Keys {
+		w.Keys[i] = z.Uint64()
+	}
+	return w
+}
+
+// Scan returns n requests where a Zipf distributed base load is
+// interrupted, after every period requests of it, by a sequential scan of
+// scanLen keys that are never requested again.
+func Scan(keys uint64, n, period, scanLen int, seed int64) Workload {
+	z := rand.NewZipf(rand.New(rand.NewSource(seed)), 1.1, 1, keys-1)
+	w := Workload{Name: "scan", Keys: make([]uint64, 0, n)}
+	next := keys
+	base := 0
+	for len(w.Keys) < n {
+		w.Keys = append(w.Keys, z.Uint64())
+		base++
+		if base%period != 0 {
+			continue
+		}
+		for j := 0; j < scanLen && len(w.Keys) < n; j++ {
+			w.Keys = append(w.Keys, next)
+			next++
+		}
+	}
+	return w
+}
+
+// Loop returns n requests cycling through keys in order.
+func Loop(keys uint64, n int) Workload {
+	w := Workload{Name: "loop", Keys: make([]uint64, n)}
+	for i := range w.Keys {
+		w.Keys[i] = uint64(i) % keys
+	}
+	return w
+}
+
+// Shifting returns n requests following a Zipf distribution over a hot set
+// that moves to entirely new keys every phase requests. A phase shorter
+// than one request is taken as one.
+func Shifting(keys uint64, n, phase int, seed int64) Workload {
+	if phase < 1 {
+		phase = 1
+	}
+	z := rand.NewZipf(rand.New(rand.NewSource(seed)), 1.1, 1, keys-1)
+	w := Workload{Name: "shifting", Keys: make([]uint64, n)}
+	for i := range w.Keys {
+		w.Keys[i] = uint64(i/phase)*keys + z.Uint64()
+	}
+	return w
+}
+
+// StandardWorkloads returns a set of workloads of n requests each,
+// suitable for a cache holding around a tenth of keys items.
+func StandardWorkloads(keys uint64, n int) []Workload {
+	return []Workload{
+		Zipf(1.01, keys, n, 1),
+		Zipf(1.2, keys, n, 2),
+		Zipf(1.5, keys, n, 3),
+		Scan(keys, n, 10000, int(keys/10), 4),
+		Loop(keys/5, n),
+		Shifting(keys, n, n/10, 5),
+	}
+}
@@ -0,0 +1,197 @@
+package lfucache
+
+import (
+	"reflect"
+	"unsafe"
+)
+
+// Sizer is implemented by keys and values that know their own size in
+// bytes, including any memory they refer to. It takes precedence over the
+// estimate made by EstimateSize.
+type Sizer interface {
+	Size() int
+}
+
+// maxEstimateDepth limits how many pointers, interfaces, slices and maps
+// EstimateSize follows, bounding its work on deep or cyclic structures
+const maxEstimateDepth = 8
+
+// Estimated sizes of the bookkeeping structures
+var (
+	nodeSize          = int64(unsafe.Sizeof(node{}))
+	frequencyNodeSize = int64(unsafe.Sizeof(frequencyNode{}))
+	nsKeySize         = int64(unsafe.Sizeof(nsKey{}))
+	stringHeaderSize  = int64(unsafe.Sizeof(""))
+	sliceHeaderSize   = int64(unsafe.Sizeof([]byte(nil)))
+
+	// A map entry is its key and value plus about one byte of hash
+	indexEntrySize     = int64(unsafe.Sizeof(interface{}(nil))+unsafe.Sizeof(&node{})) + 1
+	hashIndexEntrySize = int64(unsafe.Sizeof(uint64(0))+unsafe.Sizeof(&node{})) + 1
+)
+
+// WithMemoryEstimate makes the cache estimate the memory used by its items
+// and bookkeeping, as reported by Statistics and Histogram. Each item is
+// sized once, when inserted, by EstimateSize of its key and value.
+func WithMemoryEstimate() Option {
+	return func(c *Cache) {
+		c.estimate = true
+	}
+}
+
+// WithMaxBytes limits the estimated memory used by the items in the cache,
+// in addition to the capacity, and implies WithMemoryEstimate. The limit
+// covers the keys and values and the node and index entry of each item;
+// the frequency list and the freelists are bounded by the capacity instead.
+// Items are evicted in LFU order to make room, with EvictMemory as the
+// reason. An item larger than the limit by itself is rejected, deleting the
+// item it would have replaced, see Insert.
+func WithMaxBytes(max int64) Option {
+	return func(c *Cache) {
+		c.estimate = true
+		c.maxBytes = max
+		c.maxBytesSet = true
+	}
+}
+
+// EstimateSize returns an estimate of the bytes of memory used by v,
+// including the memory it refers to. Sizers report their own size. Strings,
+// slices, maps, pointers and interfaces are followed to a limited depth,
+// and memory referred to more than once is counted each time.
+func EstimateSize(v interface{}) int64 {
+	switch v := v.(type) {
+	case nil:
+		return 0
+	case Sizer:
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+			// Refers to nothing, and Size may not handle nil
+			return int64(rv.Type().Size())
+		}
+		return int64(v.Size())
+	case string:
+		return stringHeaderSize + int64(len(v))
+	case []byte:
+		return sliceHeaderSize + int64(cap(v))
+	}
+
+	rv := reflect.ValueOf(v)
+	return int64(rv.Type().Size()) + indirectSize(rv, maxEstimateDepth)
+}
+
+// indirectSize returns the size of the memory referred to by v, beyond v
+// itself
+func indirectSize(v reflect.Value, depth int) int64 {
+	switch v.Kind() {
+	case reflect.String:
+		return int64(v.Len())
+
+	case reflect.Slice:
+		if v.IsNil() || depth == 0 {
+			return 0
+		}
+		size := int64(v.Cap()) * int64(v.Type().Elem().Size())
+		if refers(v.Type().Elem()) {
+			for i := 0; i < v.Len(); i++ {
+				size += indirectSize(v.Index(i), depth-1)
+			}
+		}
+		return size
+
+	case reflect.Array:
+		var size int64
+		if refers(v.Type().Elem()) {
+			for i := 0; i < v.Len(); i++ {
+				size += indirectSize(v.Index(i), depth)
+			}
+		}
+		return size
+
+	case reflect.Struct:
+		var size int64
+		for i := 0; i < v.NumField(); i++ {
+			size += indirectSize(v.Field(i), depth)
+		}
+		return size
+
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() || depth == 0 {
+			return 0
+		}
+		e := v.Elem()
+		return int64(e.Type().Size()) + indirectSize(e, depth-1)
+
+	case reflect.Map:
+		if v.IsNil() || depth == 0 {
+			return 0
+		}
+		t := v.Type()
+		size := int64(v.Len()) * int64(t.Key().Size()+t.Elem().Size()+1)
+		if refers(t.Key()) || refers(t.Elem()) {
+			it := v.MapRange()
+			for it.Next() {
+				size += indirectSize(it.Key(), depth-1) + indirectSize(it.Value(), depth-1)
+			}
+		}
+		return size
+	}
+
+	return 0
+}
+
+// refers returns true if values of the type may refer to other memory
+// counted by indirectSize
+func refers(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Slice, reflect.Ptr, reflect.Interface, reflect.Map:
+		return true
+	case reflect.Array:
+		return t.Len() > 0 && refers(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if refers(t.Field(i).Type) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// itemSize returns the estimated size of an item with the given index key
+// and value, including its node and index entry
+func (c *Cache) itemSize(key, value interface{}) int64 {
+	size := nodeSize + EstimateSize(value)
+	if k, ok := key.(nsKey); ok {
+		size += nsKeySize + EstimateSize(k.key)
+	} else {
+		size += EstimateSize(key)
+	}
+	if c.hasher != nil {
+		size += hashIndexEntrySize
+	} else {
+		size += indexEntrySize
+	}
+	return size
+}
+
+// itemOverhead returns the bookkeeping part of the size of an item
+func (c *Cache) itemOverhead() int64 {
+	if c.hasher != nil {
+		return nodeSize + hashIndexEntrySize
+	}
+	return nodeSize + indexEntrySize
+}
+
+// makeRoom evicts items until an item of the given size, which is no
+// larger than the limit, fits within the memory limit
+func (c *Cache) makeRoom(size int64) {
+	for c.length > 0 && c.bytes+size > c.maxBytes {
+		c.evict(c.victim(), EvictMemory)
+	}
+}
+
+// memoryStatistics fills in the estimated memory use, given the number of
+// frequency nodes
+func (c *Cache) memoryStatistics(s *Statistics, numFrequencyNodes int) {
+	lists := int64(numFrequencyNodes+c.numFreeFrequencyNodes)*frequencyNodeSize + int64(c.numFreeNodes)*nodeSize
+	s.Bytes = c.bytes + lists
+	s.OverheadBytes = int64(c.length)*c.itemOverhead() + lists
+}
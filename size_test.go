@@ -0,0 +1,161 @@
+package lfucache_test
+
+import (
+	"github.com/calmh/lfucache"
+	"testing"
+)
+
+// sized is a value reporting its size as given
+type sized int
+
+func (s sized) Size() int {
+	return int(s)
+}
+
+func TestEstimateSize(t *testing.T) {
+	type pair struct {
+		a string
+		b []byte
+	}
+
+	cases := []struct {
+		v    interface{}
+		min  int64
+		max  int64
+		name string
+	}{
+		{nil, 0, 0, "nil"},
+		{sized(1000), 1000, 1000, "Sizer"},
+		{(*sized)(nil), 8, 8, "nil Sizer"},
+		{"", 16, 16, "empty string"},
+		{string(make([]byte, 100)), 116, 116, "string"},
+		{make([]byte, 10, 100), 124, 124, "[]byte"},
+		{int64(1), 8, 8, "int64"},
+		{pair{"abc", make([]byte, 100)}, 140, 150, "struct"},
+		{&pair{"abc", make([]byte, 100)}, 140, 160, "pointer"},
+		{[]string{"abc", "def"}, 56, 70, "[]string"},
+		{map[string]int{"abc": 1}, 30, 60, "map"},
+	}
+
+	for _, tc := range cases {
+		if s := lfucache.EstimateSize(tc.v); s < tc.min || s > tc.max {
+			t.Errorf("Size %d of %s not in [%d, %d]", s, tc.name, tc.min, tc.max)
+		}
+	}
+}
+
+func TestEstimateSizeCycle(t *testing.T) {
+	type list struct {
+		next  *list
+		value string
+	}
+
+	l := &list{value: "abc"}
+	l.next = l
+	if s := lfucache.EstimateSize(l); s <= 0 {
+		t.Errorf("Unexpected size %d", s)
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	c := lfucache.New(100, lfucache.WithMaxBytes(4000))
+
+	c.Insert("a", sized(1000))
+	c.Insert("b", sized(1000))
+	c.Insert("c", sized(1000))
+	c.Access("a")
+	c.Access("c")
+
+	s := c.Statistics()
+	if s.Bytes < 3000 || s.Bytes > 4000 || s.OverheadBytes <= 0 || s.OverheadBytes >= s.Bytes-3000 {
+		t.Errorf("Unexpected sizes %d, %d", s.Bytes, s.OverheadBytes)
+	}
+	hist := c.Histogram()
+	if len(hist) != 2 || hist[0].Bytes < 1000 || hist[1].Bytes < 2000 || hist[0].Bytes+hist[1].Bytes > s.Bytes {
+		t.Errorf("Unexpected histogram %v", hist)
+	}
+
+	// Makes room by evicting the least frequently used b
+	c.Insert("d", sized(1500))
+	if _, ok := c.Peek("b"); ok {
+		t.Error("b was not evicted")
+	}
+	if c.Len() != 3 {
+		t.Errorf("Unexpected size %d", c.Len())
+	}
+
+	// Larger than the limit, rejected without evicting anything, but
+	// deleting the item it would replace
+	c.Insert("e", sized(5000))
+	if err := c.InsertWithUsage("a", sized(5000), 1); err != lfucache.ErrItemTooLarge {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if _, ok := c.Peek("e"); ok || c.Len() != 2 {
+		t.Errorf("Unexpected size %d", c.Len())
+	}
+	if v, ok := c.Peek("a"); ok {
+		t.Errorf("Stale a %v left after rejected insert", v)
+	}
+
+	s = c.Statistics()
+	if s.EvictionsByReason[lfucache.EvictMemory] != 1 || s.Rejected != 2 || s.Deletes != 1 {
+		t.Errorf("Unexpected memory evictions %d, rejected %d, deletes %d", s.EvictionsByReason[lfucache.EvictMemory], s.Rejected, s.Deletes)
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	c.DeleteMany([]interface{}{"c", "d"})
+	if s := c.Statistics(); s.Bytes != s.OverheadBytes {
+		t.Errorf("Unexpected sizes %d, %d for empty cache", s.Bytes, s.OverheadBytes)
+	}
+}
+
+func TestMaxBytesReplace(t *testing.T) {
+	c := lfucache.New(100, lfucache.WithMaxBytes(2500))
+
+	c.Insert("a", sized(1000))
+	c.Insert("b", sized(1000))
+	c.Insert("a", sized(1200))
+
+	if c.Len() != 2 {
+		t.Errorf("Unexpected size %d", c.Len())
+	}
+	if s := c.Statistics(); s.Evictions != 1 || s.EvictionsByReason[lfucache.EvictReplace] != 1 {
+		t.Errorf("Unexpected evictions %+v", s)
+	}
+}
+
+func TestMaxBytesNamespace(t *testing.T) {
+	c := lfucache.New(100, lfucache.WithMemoryEstimate())
+	a := c.Namespace("a")
+
+	c.Insert("a", sized(1000))
+	a.Insert("a", sized(2000))
+
+	if s := a.Statistics(); s.Bytes < 2000 || s.Bytes >= 3000 {
+		t.Errorf("Unexpected namespace size %d", s.Bytes)
+	}
+	if s := c.Statistics(); s.Bytes < 3000 {
+		t.Errorf("Unexpected size %d", s.Bytes)
+	}
+}
+
+func TestMaxBytesTieBreak(t *testing.T) {
+	c := lfucache.New(3, lfucache.WithMemoryEstimate(), lfucache.WithTieBreak(lfucache.TieBreakLargestCost))
+
+	c.Insert("a", sized(1000))
+	c.Insert("b", sized(3000))
+	c.Insert("c", sized(2000))
+	c.Insert("d", sized(1000))
+
+	if _, ok := c.Peek("b"); ok {
+		t.Error("The largest item was not evicted")
+	}
+}
+
+func TestInvalidMaxBytes(t *testing.T) {
+	if _, err := lfucache.NewWithOptions(lfucache.WithCapacity(10), lfucache.WithMaxBytes(0)); err != lfucache.ErrInvalidMaxBytes {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
@@ -0,0 +1,241 @@
+package lfucache
+
+// The slab type implements the same two levels of linked lists as the
+// "frequencyNode" and "node" types, but with all nodes kept in preallocated
+// slices and linked by slice index instead of by pointer. The resulting
+// structure is a few large allocations without any pointers, which the
+// garbage collector does not need to scan. Items are identified by their
+// slot number; mapping keys to slots and storing the actual keys and values
+// is left to the user of the slab.
+
+const nilIndex int32 = -1
+
+type slab struct {
+	nodes     []slabNode
+	freqs     []slabFreq // freqs[0] is the head of the list, with usage zero
+	freeNodes int32      // unused nodes, linked through next
+	freeFreqs int32      // unused frequency nodes, linked through next
+	length    int
+}
+
+type slabFreq struct {
+	usage int
+	prev  int32
+	next  int32
+	head  int32
+	tail  int32 // most recently inserted
+}
+
+type slabNode struct {
+	parent int32
+	prev   int32
+	next   int32
+}
+
+// newSlab returns a slab with room for capacity items.
+func newSlab(capacity int) slab {
+	s := slab{
+		nodes:     make([]slabNode, 0, capacity),
+		freqs:     make([]slabFreq, 1, capacity+2),
+		freeNodes: nilIndex,
+		freeFreqs: nilIndex,
+	}
+	s.freqs[0] = slabFreq{prev: nilIndex, next: nilIndex, head: nilIndex, tail: nilIndex}
+	s.grow(capacity)
+	return s
+}
+
+// grow makes room for at least capacity items. A slab never shrinks.
+func (s *slab) grow(capacity int) {
+	for i := len(s.nodes); i < capacity; i++ {
+		s.nodes = append(s.nodes, slabNode{next: s.freeNodes})
+		s.freeNodes = int32(i)
+	}
+
+	// There can be at most one frequency node per item, plus the head and
+	// the one created by access before the old one is deleted.
+	for i := len(s.freqs); i < capacity+2; i++ {
+		s.freqs = append(s.freqs, slabFreq{next: s.freeFreqs})
+		s.freeFreqs = int32(i)
+	}
+}
+
+// insert allocates a slot for a new item at usage zero and returns it
+func (s *slab) insert() int32 {
+	i := s.freeNodes
+	s.freeNodes = s.nodes[i].next
+
+	s.nodes[i] = slabNode{parent: nilIndex, prev: nilIndex, next: nilIndex}
+	s.attach(i, 0)
+	s.length++
+	return i
+}
+
+// remove removes the item in slot i and frees the slot
+func (s *slab) remove(i int32) {
+	s.detach(i)
+	s.nodes[i] = slabNode{next: s.freeNodes}
+	s.freeNodes = i
+	s.length--
+}
+
+// access increases the use count of the item in slot i
+func (s *slab) access(i int32) {
+	p := s.nodes[i].parent
+	nextUsage := s.freqs[p].usage + 1
+	next := s.freqs[p].next
+	if next == nilIndex || s.freqs[next].usage != nextUsage {
+		next = s.newFreq(nextUsage, p)
+	}
+
+	s.detach(i)
+	s.attach(i, next)
+}
+
+// usage returns the use count of the item in slot i
+func (s *slab) usage(i int32) int {
+	return s.freqs[s.nodes[i].parent].usage
+}
+
+// lfu returns the slot of the least frequently used item, prefering the
+// oldest if there are multiple items with the same lowest usage count, or
+// nilIndex if the slab is empty
+func (s *slab) lfu() int32 {
+	for f := int32(0); f != nilIndex; f = s.freqs[f].next {
+		if s.freqs[f].head != nilIndex {
+			return s.freqs[f].head
+		}
+	}
+	return nilIndex
+}
+
+// newFreq inserts a new frequency node after the specified prev node
+func (s *slab) newFreq(usage int, prev int32) int32 {
+	f := s.freeFreqs
+	s.freeFreqs = s.freqs[f].next
+
+	next := s.freqs[prev].next
+	s.freqs[f] = slabFreq{usage: usage, prev: prev, next: next, head: nilIndex, tail: nilIndex}
+	if next != nilIndex {
+		s.freqs[next].prev = f
+	}
+	s.freqs[prev].next = f
+
+	return f
+}
+
+// deleteFreq removes a frequency node from the list and frees it
+func (s *slab) deleteFreq(f int32) {
+	fn := &s.freqs[f]
+	if fn.next != nilIndex {
+		s.freqs[fn.next].prev = fn.prev
+	}
+	s.freqs[fn.prev].next = fn.next
+
+	*fn = slabFreq{next: s.freeFreqs}
+	s.freeFreqs = f
+}
+
+// detach removes the node in slot i from its frequency node, deleting the
+// frequency node if it became empty
+func (s *slab) detach(i int32) {
+	n := &s.nodes[i]
+	if n.prev != nilIndex {
+		s.nodes[n.prev].next = n.next
+	}
+	if n.next != nilIndex {
+		s.nodes[n.next].prev = n.prev
+	}
+
+	fn := &s.freqs[n.parent]
+	if fn.head == i {
+		fn.head = n.next
+	}
+	if fn.tail == i {
+		fn.tail = n.prev
+	}
+	if fn.head == nilIndex && fn.usage != 0 {
+		s.deleteFreq(n.parent)
+	}
+
+	n.parent = nilIndex
+	n.prev = nilIndex
+	n.next = nilIndex
+}
+
+// attach adds the detached node in slot i at the tail of frequency node f
+func (s *slab) attach(i int32, f int32) {
+	n := &s.nodes[i]
+	fn := &s.freqs[f]
+
+	n.parent = f
+	n.prev = fn.tail
+	if fn.tail != nilIndex {
+		s.nodes[fn.tail].next = i
+	}
+	if fn.head == nilIndex {
+		fn.head = i
+	}
+	fn.tail = i
+}
+
+// items0 returns the number of items with usage count zero
+func (s *slab) items0() (count int) {
+	for i := s.freqs[0].head; i != nilIndex; i = s.nodes[i].next {
+		count++
+	}
+	return
+}
+
+// numFreqs returns the number of frequency nodes in the list
+func (s *slab) numFreqs() (count int) {
+	for f := int32(0); f != nilIndex; f = s.freqs[f].next {
+		count++
+	}
+	return
+}
+
+// validate verifies the same invariants as Cache.validate, returning the
+// first violation found. Keys are not known to the slab, so the Key field
+// of the returned error is the slot number.
+func (s *slab) validate() *ValidationError {
+	count := 0
+	prevF := nilIndex
+	for f := int32(0); f != nilIndex; f = s.freqs[f].next {
+		fn := s.freqs[f]
+		if fn.head == nilIndex && fn.usage != 0 {
+			return &ValidationError{Reason: "empty non-head frequency node", Usage: fn.usage}
+		}
+		if fn.prev != prevF {
+			return &ValidationError{Reason: "incorrect prev frequencyNode pointer", Usage: fn.usage}
+		}
+		if prevF != nilIndex && fn.usage <= s.freqs[prevF].usage {
+			return &ValidationError{Reason: "frequency node usage not increasing", Usage: fn.usage}
+		}
+
+		prev := nilIndex
+		for i := fn.head; i != nilIndex; i = s.nodes[i].next {
+			n := s.nodes[i]
+			if n.parent != f {
+				return &ValidationError{Reason: "incorrect parent pointer", Usage: fn.usage, Key: i}
+			}
+			if n.prev != prev {
+				return &ValidationError{Reason: "incorrect prev node pointer", Usage: fn.usage, Key: i}
+			}
+			prev = i
+			count++
+
+			if n.next == nilIndex && fn.tail != i {
+				return &ValidationError{Reason: "tail pointer not pointing to last node", Usage: fn.usage, Key: i}
+			}
+		}
+
+		prevF = f
+	}
+
+	if count != s.length {
+		return &ValidationError{Reason: "index/item count mismatch"}
+	}
+
+	return nil
+}
@@ -0,0 +1,317 @@
+package lfucache
+
+import (
+	"math"
+	"reflect"
+)
+
+// maxSlabCapacity is the largest capacity whose slots, and frequency nodes
+// in addition to them, can be addressed by int32 links
+const maxSlabCapacity = math.MaxInt32 - 2
+
+// SlabCache is an LFU cache with the same LFU semantics as Cache and the
+// core of its API, but with the LFU bookkeeping kept in preallocated slices
+// using index based links instead of pointers. This makes it considerably
+// cheaper for the garbage collector when holding a large number of items,
+// at the price of preallocating storage for the full capacity up front.
+//
+// Keys of integer types are kept entirely without pointers. Other keys, and
+// all values, are stored as interfaces that the garbage collector still
+// scans; BytesCache avoids this for []byte keys and values.
+type SlabCache struct {
+	capacity     int
+	slab         slab
+	keys         []interface{} // non-integer keys, allocated on first use
+	intKeys      []intKey      // integer keys, with a zero kind for other keys
+	values       []interface{}
+	index        map[interface{}]int32
+	intIndex     map[intKey]int32
+	evictedChans []chan<- interface{}
+	stats        Statistics
+}
+
+// intKey is a key of an integer type, comparable and without pointers. The
+// kind keeps keys of different types apart, as they are in a map.
+type intKey struct {
+	kind reflect.Kind
+	v    uint64
+}
+
+// NewSlab initializes a new SlabCache structure with the specified
+// capacity. Storage for the full capacity is allocated immediately. It
+// panics with ErrInvalidCapacity if the capacity is not positive or too
+// large to be addressed by the slab; NewSlabCache returns the error
+// instead.
+func NewSlab(capacity int) *SlabCache {
+	c, err := NewSlabCache(capacity)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// NewSlabCache initializes a new SlabCache structure like NewSlab. Returns
+// ErrInvalidCapacity if the capacity is not positive or larger than
+// math.MaxInt32-2.
+func NewSlabCache(capacity int) (*SlabCache, error) {
+	if capacity <= 0 || int64(capacity) > maxSlabCapacity {
+		return nil, ErrInvalidCapacity
+	}
+
+	return &SlabCache{
+		capacity: capacity,
+		slab:     newSlab(capacity),
+		intKeys:  make([]intKey, capacity),
+		values:   make([]interface{}, capacity),
+		index:    make(map[interface{}]int32),
+		intIndex: make(map[intKey]int32),
+	}, nil
+}
+
+// Resize the cache to a new capacity. When shrinking, items may get evicted.
+// Storage is grown as necessary but never released. Returns
+// ErrInvalidCapacity, leaving the cache unchanged, if the capacity is not
+// positive or larger than math.MaxInt32-2.
+func (c *SlabCache) Resize(capacity int) error {
+	if capacity <= 0 || int64(capacity) > maxSlabCapacity {
+		return ErrInvalidCapacity
+	}
+
+	c.capacity = capacity
+	for c.slab.length > c.capacity {
+		c.evict(c.slab.lfu(), EvictResize)
+	}
+
+	if capacity > len(c.values) {
+		c.slab.grow(capacity)
+		if c.keys != nil {
+			c.keys = append(c.keys, make([]interface{}, capacity-len(c.keys))...)
+		}
+		c.intKeys = append(c.intKeys, make([]intKey, capacity-len(c.intKeys))...)
+		c.values = append(c.values, make([]interface{}, capacity-len(c.values))...)
+	}
+	return nil
+}
+
+// Insert inserts an item into the cache. See Cache.Insert.
+func (c *SlabCache) Insert(key interface{}, value interface{}) {
+	if debug {
+		c.check()
+	}
+
+	if i, ok := c.lookup(key); ok {
+		c.evict(i, EvictReplace)
+	}
+
+	if c.slab.length == c.capacity {
+		c.evict(c.slab.lfu(), EvictCapacity)
+	}
+
+	i := c.slab.insert()
+	c.values[i] = value
+	if k, ok := toIntKey(key); ok {
+		c.intKeys[i] = k
+		c.intIndex[k] = i
+	} else {
+		if c.keys == nil {
+			c.keys = make([]interface{}, len(c.values))
+		}
+		c.keys[i] = key
+		c.index[key] = i
+	}
+	c.stats.Inserts++
+
+	if debug {
+		c.check()
+	}
+}
+
+// Delete deletes an item from the cache. See Cache.Delete.
+func (c *SlabCache) Delete(key interface{}) bool {
+	if debug {
+		c.check()
+	}
+
+	i, ok := c.lookup(key)
+	if ok {
+		c.remove(i)
+		c.stats.Deletes++
+	}
+
+	if debug {
+		c.check()
+	}
+
+	return ok
+}
+
+// Access an item in the cache. See Cache.Access.
+func (c *SlabCache) Access(key interface{}) (interface{}, bool) {
+	if debug {
+		c.check()
+	}
+
+	i, ok := c.lookup(key)
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+
+	c.slab.access(i)
+	c.stats.Hits++
+
+	if debug {
+		c.check()
+	}
+
+	return c.values[i], true
+}
+
+// Len returns the number of items currently stored in the cache.
+func (c *SlabCache) Len() int {
+	return c.slab.length
+}
+
+// Cap returns the maximum number of items the cache will hold.
+func (c *SlabCache) Cap() int {
+	return c.capacity
+}
+
+// Statistics returns the cache statistics.
+func (c *SlabCache) Statistics() Statistics {
+	if debug {
+		c.check()
+	}
+
+	c.stats.LenFreq0 = c.slab.items0()
+	c.stats.FreqListLen = c.slab.numFreqs()
+	return c.stats
+}
+
+// Evictions registers a channel used to report items that get evicted from
+// the cache. See Cache.Evictions.
+func (c *SlabCache) Evictions(e chan<- interface{}) {
+	c.evictedChans = append(c.evictedChans, e)
+}
+
+// UnregisterEvictions removes the channel from the list of channels to be
+// notified on item eviction. See Cache.UnregisterEvictions.
+func (c *SlabCache) UnregisterEvictions(e chan<- interface{}) {
+	c.evictedChans = removeChan(c.evictedChans, e)
+}
+
+// EvictIf applies test to each item in the cache and evicts it if the test
+// returns true. Returns the number of items that were evicted.
+func (c *SlabCache) EvictIf(test func(interface{}) bool) int {
+	if debug {
+		c.check()
+	}
+
+	cnt := 0
+	s := &c.slab
+	for f := int32(0); f != nilIndex; {
+		// Evicting the last item of a frequency node frees it, so pick up
+		// the links before doing so.
+		nextF := s.freqs[f].next
+		for i := s.freqs[f].head; i != nilIndex; {
+			next := s.nodes[i].next
+			if test(c.values[i]) {
+				c.evict(i, EvictManual)
+				cnt++
+			}
+			i = next
+		}
+		f = nextF
+	}
+
+	if debug {
+		c.check()
+	}
+
+	return cnt
+}
+
+// lookup returns the slot holding key
+func (c *SlabCache) lookup(key interface{}) (int32, bool) {
+	if k, ok := toIntKey(key); ok {
+		i, ok := c.intIndex[k]
+		return i, ok
+	}
+	i, ok := c.index[key]
+	return i, ok
+}
+
+// evict evicts the item in slot i for the given reason and notifies any
+// interested eviction listeners
+func (c *SlabCache) evict(i int32, reason EvictReason) {
+	for j := range c.evictedChans {
+		c.evictedChans[j] <- c.values[i]
+	}
+	c.remove(i)
+	c.stats.Evictions++
+	c.stats.EvictionsByReason[reason]++
+}
+
+// remove removes the item in slot i from the index and the slab
+func (c *SlabCache) remove(i int32) {
+	if k := c.intKeys[i]; k.kind != reflect.Invalid {
+		delete(c.intIndex, k)
+		c.intKeys[i] = intKey{}
+	} else {
+		delete(c.index, c.keys[i])
+		c.keys[i] = nil
+	}
+	c.values[i] = nil
+	c.slab.remove(i)
+}
+
+// Validate verifies the internal consistency of the cache structure. See
+// Cache.Validate.
+func (c *SlabCache) Validate() error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *SlabCache) validate() *ValidationError {
+	if c.slab.length != len(c.index)+len(c.intIndex) {
+		return &ValidationError{Reason: "index/numItems mismatch"}
+	}
+	return c.slab.validate()
+}
+
+func (c *SlabCache) check() {
+	if err := c.validate(); err != nil {
+		panic("bug: " + err.Error())
+	}
+}
+
+// toIntKey returns the intKey for keys of integer types
+func toIntKey(key interface{}) (intKey, bool) {
+	switch k := key.(type) {
+	case int:
+		return intKey{reflect.Int, uint64(k)}, true
+	case int8:
+		return intKey{reflect.Int8, uint64(k)}, true
+	case int16:
+		return intKey{reflect.Int16, uint64(k)}, true
+	case int32:
+		return intKey{reflect.Int32, uint64(k)}, true
+	case int64:
+		return intKey{reflect.Int64, uint64(k)}, true
+	case uint:
+		return intKey{reflect.Uint, uint64(k)}, true
+	case uint8:
+		return intKey{reflect.Uint8, uint64(k)}, true
+	case uint16:
+		return intKey{reflect.Uint16, uint64(k)}, true
+	case uint32:
+		return intKey{reflect.Uint32, uint64(k)}, true
+	case uint64:
+		return intKey{reflect.Uint64, k}, true
+	case uintptr:
+		return intKey{reflect.Uintptr, uint64(k)}, true
+	}
+	return intKey{}, false
+}
@@ -0,0 +1,203 @@
+package lfucache_test
+
+import (
+	"github.com/calmh/lfucache"
+	"math"
+	"math/rand"
+	"runtime"
+	"testing"
+)
+
+func TestSlabExpiry(t *testing.T) {
+	c := lfucache.NewSlab(3)
+
+	c.Insert("test1", 42) // usage=1
+	c.Access("test1")     // usage=2
+	c.Access("test1")     // usage=3
+
+	c.Insert("test2", 43) // usage=1
+
+	c.Insert("test3", 44) // usage=1
+	c.Access("test3")     // usage=2
+
+	c.Insert("test4", 45) // usage=1, should remove test2 which is lfu
+
+	if _, ok := c.Access("test2"); ok {
+		t.Error("Node test2 was not removed")
+	}
+
+	for k, v := range map[string]int{"test1": 42, "test3": 44, "test4": 45} {
+		if r, _ := c.Access(k); r.(int) != v {
+			t.Errorf("Didn't get the right value back from the cache (%s)", k)
+		}
+	}
+}
+
+func TestSlabEvictIf(t *testing.T) {
+	c := lfucache.NewSlab(10)
+
+	for i := 0; i < 5; i++ {
+		c.Insert(i, 42+i)
+		if i%2 == 0 {
+			c.Access(i)
+		}
+	}
+
+	ev := c.EvictIf(func(v interface{}) bool {
+		return v.(int)%2 == 0
+	})
+
+	if ev != 3 {
+		t.Error("Incorrect number of items evicted", ev)
+	}
+	if c.Len() != 2 {
+		t.Errorf("Unexpected size %d", c.Len())
+	}
+	if _, ok := c.Access(1); !ok {
+		t.Error("1 expected to exist")
+	}
+}
+
+func TestSlabResize(t *testing.T) {
+	c := lfucache.NewSlab(2)
+
+	c.Insert("test1", 42)
+	c.Access("test1")
+	c.Insert("test2", 43)
+
+	c.Resize(4)
+	c.Insert("test3", 44)
+	c.Insert("test4", 45)
+	if c.Len() != 4 {
+		t.Errorf("Unexpected size %d", c.Len())
+	}
+
+	c.Resize(1)
+	if _, ok := c.Access("test1"); !ok || c.Len() != 1 {
+		t.Error("test1 expected to be the only remaining item")
+	}
+}
+
+func TestSlabKeyTypes(t *testing.T) {
+	c := lfucache.NewSlab(10)
+
+	c.Insert(1, "int")
+	c.Insert(int64(1), "int64")
+	c.Insert(uint8(1), "uint8")
+	c.Insert("1", "string")
+
+	if c.Len() != 4 {
+		t.Errorf("Unexpected size %d", c.Len())
+	}
+	for k, v := range map[interface{}]string{1: "int", int64(1): "int64", uint8(1): "uint8", "1": "string"} {
+		if r, _ := c.Access(k); r != v {
+			t.Errorf("Didn't get the right value back for %T", k)
+		}
+	}
+
+	c.Delete(int64(1))
+	c.Delete("1")
+	if _, ok := c.Access(1); !ok || c.Len() != 2 {
+		t.Error("Deleted the wrong item")
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSlabInvalidCapacity(t *testing.T) {
+	for _, capacity := range []int{0, -1, math.MaxInt32} {
+		if _, err := lfucache.NewSlabCache(capacity); err != lfucache.ErrInvalidCapacity {
+			t.Errorf("Unexpected error %v for capacity %d", err, capacity)
+		}
+	}
+	if err := lfucache.NewSlab(1).Resize(math.MaxInt32); err != lfucache.ErrInvalidCapacity {
+		t.Errorf("Unexpected error %v for Resize", err)
+	}
+}
+
+// TestSlabMatchesCache runs the same random operations against a Cache and a
+// SlabCache and verifies that they behave identically.
+func TestSlabMatchesCache(t *testing.T) {
+	c := lfucache.New(100)
+	s := lfucache.NewSlab(100)
+
+	for i := 0; i < 100000; i++ {
+		key := rand.Intn(250)
+		switch rand.Intn(10) {
+		case 0:
+			c.Insert(key, i)
+			s.Insert(key, i)
+		case 1:
+			if c.Delete(key) != s.Delete(key) {
+				t.Fatalf("Delete(%d) mismatch", key)
+			}
+		default:
+			cv, cok := c.Access(key)
+			sv, sok := s.Access(key)
+			if cok != sok || cv != sv {
+				t.Fatalf("Access(%d) mismatch, %v, %v != %v, %v", key, cv, cok, sv, sok)
+			}
+		}
+	}
+
+	if c.Statistics() != s.Statistics() {
+		t.Errorf("Statistics mismatch, %+v != %+v", c.Statistics(), s.Statistics())
+	}
+}
+
+// The GC benchmarks measure the time for a full garbage collection with a
+// large cache in memory.
+
+const gcCacheSize = 1e6
+
+func BenchmarkGCPointer(b *testing.B) {
+	c := lfucache.New(gcCacheSize)
+	for i := 0; i < gcCacheSize; i++ {
+		c.Insert(i, i)
+		if i%2 == 0 {
+			c.Access(i)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+	}
+	runtime.KeepAlive(c)
+}
+
+func BenchmarkGCSlab(b *testing.B) {
+	c := lfucache.NewSlab(gcCacheSize)
+	for i := 0; i < gcCacheSize; i++ {
+		c.Insert(i, i)
+		if i%2 == 0 {
+			c.Access(i)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+	}
+	runtime.KeepAlive(c)
+}
+
+func BenchmarkSlabAccessHitRandomInt(b *testing.B) {
+	c := lfucache.NewSlab(cacheSize)
+
+	for i := 0; i < cacheSize; i++ {
+		c.Insert(i, i)
+	}
+
+	indexes := make([]interface{}, cacheSize)
+	for i := 0; i < cacheSize; i++ {
+		indexes[i] = int(rand.Int31n(cacheSize))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Access(indexes[i%cacheSize])
+	}
+}
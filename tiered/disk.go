@@ -0,0 +1,344 @@
+package tiered
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/calmh/deprecated_lfucache"
+)
+
+const (
+	logName     = "items.log"
+	compactName = "items.log.compact"
+)
+
+// A record in the log is a header followed by the key and value. The
+// checksum covers everything following it, so that a record torn by a
+// crash is detected.
+//
+//	checksum  uint32
+//	op        uint8
+//	usage     uint32
+//	keyLen    uint32
+//	valueLen  uint32
+const recordHeaderLen = 4 + 1 + 4 + 4 + 4
+
+const (
+	opPut    byte = 1
+	opDelete byte = 2
+)
+
+// maxRecordLen limits the allocation for a record read from a corrupt log
+const maxRecordLen = 1 << 30
+
+var errCorruptRecord = errors.New("corrupt record")
+
+// disk is an LFU store of items in an append-only log. The index of the
+// items is kept in memory, in an lfucache.Cache limiting the number of
+// items; the values are read from the log on demand. The log is compacted
+// once it holds more garbage than live records.
+type disk struct {
+	dir        string
+	f          *os.File
+	size       int64 // Length of the log
+	live       int64 // Length of the live put records in the log
+	minCompact int64
+	index      *lfucache.Cache // string key → *diskEntry
+	evicted    []string        // Keys evicted from the index, pending delete records
+	compacts   int
+	rejected   int
+}
+
+// diskEntry is the location of the live put record for an item
+type diskEntry struct {
+	key      string
+	offset   int64
+	length   int64 // Of the entire record
+	valueLen int
+}
+
+func (e *diskEntry) valueOffset() int64 {
+	return e.offset + recordHeaderLen + int64(len(e.key))
+}
+
+// openDisk opens the log in dir, creating it if necessary, and rebuilds
+// the index from it. A torn or corrupt tail left by a crash is truncated.
+func openDisk(dir string, capacity int, minCompact int64) (*disk, error) {
+	d := &disk{dir: dir, minCompact: minCompact}
+	index, err := lfucache.NewWithOptions(lfucache.WithCapacity(capacity), lfucache.WithObserver(diskObserver{d: d}))
+	if err != nil {
+		return nil, err
+	}
+	d.index = index
+
+	// A compaction interrupted by a crash leaves the old log in place
+	if err := os.Remove(filepath.Join(dir, compactName)); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, logName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	d.f = f
+
+	if err := d.recover(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	// Items evicted while replaying have no delete records, but are
+	// evicted again on every replay until compacted away
+	d.evicted = d.evicted[:0]
+	if err := d.maybeCompact(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// recover replays the log into the index and truncates it after the last
+// valid record
+func (d *disk) recover() error {
+	if _, err := d.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(d.f)
+	var offset int64
+	for {
+		op, usage, key, value, length, err := readRecord(r)
+		if err != nil {
+			// io.EOF at a record boundary is the regular end of the log;
+			// anything else is a tail torn by a crash
+			break
+		}
+		switch op {
+		case opPut:
+			if err := d.index.InsertWithUsage(key, &diskEntry{key: key, offset: offset, length: length, valueLen: len(value)}, usage); err != nil {
+				return err
+			}
+			d.live += length
+		case opDelete:
+			d.index.Delete(key)
+		}
+		offset += length
+	}
+
+	d.size = offset
+	if err := d.f.Truncate(offset); err != nil {
+		return err
+	}
+	_, err := d.f.Seek(offset, io.SeekStart)
+	return err
+}
+
+// get returns the value and usage of the item for the key
+func (d *disk) get(key string) ([]byte, int, bool, error) {
+	v, ok := d.index.Peek(key)
+	if !ok {
+		return nil, 0, false, nil
+	}
+	e := v.(*diskEntry)
+	usage, _ := d.index.Usage(key)
+
+	value := make([]byte, e.valueLen)
+	if _, err := d.f.ReadAt(value, e.valueOffset()); err != nil {
+		return nil, 0, false, err
+	}
+	return value, usage, true, nil
+}
+
+// put stores the item with the given usage, evicting the least frequently
+// used item if the disk is full. An item used less frequently than all
+// items on a full disk is not stored and false is returned.
+func (d *disk) put(key string, value []byte, usage int) (bool, error) {
+	if _, ok := d.index.Peek(key); !ok && d.index.Len() == d.index.Cap() {
+		if cold := d.index.Coldest(1); len(cold) == 1 && cold[0].Usage > usage {
+			d.rejected++
+			return false, nil
+		}
+	}
+
+	offset := d.size
+	length, err := d.append(opPut, key, value, usage)
+	if err != nil {
+		return false, err
+	}
+	if err := d.index.InsertWithUsage(key, &diskEntry{key: key, offset: offset, length: length, valueLen: len(value)}, usage); err != nil {
+		return false, err
+	}
+	d.live += length
+
+	for len(d.evicted) > 0 {
+		key := d.evicted[0]
+		d.evicted = d.evicted[1:]
+		if _, err := d.append(opDelete, key, nil, 0); err != nil {
+			return true, err
+		}
+	}
+	return true, d.maybeCompact()
+}
+
+// delete removes the item for the key, if present
+func (d *disk) delete(key string) (bool, error) {
+	if !d.index.Delete(key) {
+		return false, nil
+	}
+	if _, err := d.append(opDelete, key, nil, 0); err != nil {
+		return true, err
+	}
+	return true, d.maybeCompact()
+}
+
+// append writes a record at the end of the log and returns its length
+func (d *disk) append(op byte, key string, value []byte, usage int) (int64, error) {
+	buf := appendRecord(nil, op, key, value, usage)
+	if _, err := d.f.Write(buf); err != nil {
+		return 0, err
+	}
+	d.size += int64(len(buf))
+	return int64(len(buf)), nil
+}
+
+// maybeCompact compacts the log when more than half of it is garbage
+func (d *disk) maybeCompact() error {
+	if d.size < d.minCompact || d.size <= 2*d.live {
+		return nil
+	}
+	return d.compact()
+}
+
+// compact rewrites the live records to a new log, replacing the old one.
+// Records are written from the least to the most frequently used, so that
+// replaying the new log orders the items as in the index.
+func (d *disk) compact() error {
+	path := filepath.Join(d.dir, compactName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+
+	hot := d.index.Hottest(d.index.Len())
+	entries := make([]*diskEntry, len(hot))
+	w := bufio.NewWriter(f)
+	var offset int64
+	for i := len(hot) - 1; i >= 0; i-- {
+		key := hot[i].Key.(string)
+		v, _ := d.index.Peek(key)
+		old := v.(*diskEntry)
+
+		value := make([]byte, old.valueLen)
+		if _, err := d.f.ReadAt(value, old.valueOffset()); err != nil {
+			return fail(err)
+		}
+		buf := appendRecord(nil, opPut, key, value, hot[i].Usage)
+		if _, err := w.Write(buf); err != nil {
+			return fail(err)
+		}
+		entries[i] = &diskEntry{key: key, offset: offset, length: int64(len(buf)), valueLen: len(value)}
+		offset += int64(len(buf))
+	}
+	if err := w.Flush(); err != nil {
+		return fail(err)
+	}
+	if err := f.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(path, filepath.Join(d.dir, logName)); err != nil {
+		return fail(err)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+
+	d.f.Close()
+	d.f = f
+	d.size = offset
+	d.live = offset
+	for _, e := range entries {
+		// Replace the entries in place, as reinserting would disturb the
+		// order of the index
+		v, _ := d.index.Peek(e.key)
+		*v.(*diskEntry) = *e
+	}
+	d.compacts++
+	return nil
+}
+
+// close syncs and closes the log
+func (d *disk) close() error {
+	if err := d.f.Sync(); err != nil {
+		d.f.Close()
+		return err
+	}
+	return d.f.Close()
+}
+
+// appendRecord appends the encoded record to buf
+func appendRecord(buf []byte, op byte, key string, value []byte, usage int) []byte {
+	start := len(buf)
+	buf = append(buf, 0, 0, 0, 0, op)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(usage))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(key)))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(value)))
+	buf = append(buf, key...)
+	buf = append(buf, value...)
+	binary.BigEndian.PutUint32(buf[start:], crc32.ChecksumIEEE(buf[start+4:]))
+	return buf
+}
+
+// readRecord reads and verifies the next record
+func readRecord(r io.Reader) (op byte, usage int, key string, value []byte, length int64, err error) {
+	var hdr [recordHeaderLen]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, 0, "", nil, 0, err
+	}
+	keyLen := binary.BigEndian.Uint32(hdr[9:])
+	valueLen := binary.BigEndian.Uint32(hdr[13:])
+	if uint64(keyLen)+uint64(valueLen) > maxRecordLen {
+		return 0, 0, "", nil, 0, errCorruptRecord
+	}
+
+	body := make([]byte, int(keyLen)+int(valueLen))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, 0, "", nil, 0, errCorruptRecord
+	}
+	crc := crc32.ChecksumIEEE(hdr[4:])
+	crc = crc32.Update(crc, crc32.IEEETable, body)
+	op = hdr[4]
+	if crc != binary.BigEndian.Uint32(hdr[:]) || (op != opPut && op != opDelete) {
+		return 0, 0, "", nil, 0, errCorruptRecord
+	}
+
+	usage = int(binary.BigEndian.Uint32(hdr[5:]))
+	return op, usage, string(body[:keyLen]), body[keyLen:], int64(len(hdr) + len(body)), nil
+}
+
+// diskObserver keeps the live length of the log and collects the items
+// evicted from the index
+type diskObserver struct {
+	lfucache.NopObserver
+	d *disk
+}
+
+func (o diskObserver) OnEvict(e lfucache.Event) {
+	entry := e.Value.(*diskEntry)
+	o.d.live -= entry.length
+	if e.Reason != lfucache.EvictReplace {
+		o.d.evicted = append(o.d.evicted, entry.key)
+	}
+}
+
+func (o diskObserver) OnDelete(e lfucache.Event) {
+	o.d.live -= e.Value.(*diskEntry).length
+}
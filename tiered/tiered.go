@@ -0,0 +1,231 @@
+// Package tiered provides a two level cache: an LFU cache in memory over a
+// larger LFU store on disk.
+//
+// Items evicted from memory to make room are demoted to disk rather than
+// lost, keeping their use counts. An item found on disk is promoted back
+// into memory, continuing from its use count there, and removed from disk.
+// The disk level holds a limited number of items and evicts the least
+// frequently used of them in turn, unless the item demoted is used less
+// frequently still, in which case it is dropped.
+//
+// The disk level is an append-only log of puts and deletes, with its index
+// kept in memory. The log is replayed when opened, truncating any record
+// torn by a crash, and compacted once more than half of it is garbage. On
+// Close the items in memory are demoted as well, so that a cache reopened
+// from the same directory holds the items of both levels, as far as the
+// disk capacity allows. After a crash only the disk level survives.
+package tiered // import "github.com/calmh/deprecated_lfucache/tiered"
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/calmh/deprecated_lfucache"
+)
+
+// DefaultMinCompact is the log size below which the log is never
+// compacted, unless overridden by Options.MinCompact.
+const DefaultMinCompact = 1 << 20
+
+// ErrClosed is returned by operations on a closed cache.
+var ErrClosed = errors.New("cache closed")
+
+// Options configures a TieredCache.
+type Options struct {
+	MemoryCapacity int   // Number of items held in memory
+	DiskCapacity   int   // Number of items held on disk
+	MinCompact     int64 // Log size below which it is never compacted, DefaultMinCompact if zero
+}
+
+// Statistics contains the statistics of both levels.
+type Statistics struct {
+	Memory      lfucache.Statistics // The memory level; its misses include disk hits
+	Disk        lfucache.Statistics // The index of the disk level
+	DiskHits    int                 // Number of Access()es served by promoting an item from disk
+	Demotions   int                 // Number of items moved from memory to disk
+	Rejected    int                 // Number of items evicted from memory but not stored on a full disk
+	Compactions int                 // Number of log compactions
+	LogSize     int64               // Current length of the log
+	LiveSize    int64               // Length of the live records in the log
+}
+
+// TieredCache is a two level LFU cache of byte string items. It is safe
+// for concurrent use.
+type TieredCache struct {
+	mut       sync.Mutex
+	mem       *lfucache.Cache
+	disk      *disk
+	demoted   []demotion // Items evicted from memory during the current operation
+	diskHits  int
+	demotions int
+	closed    bool
+}
+
+// demotion is an item evicted from memory, pending its write to disk
+type demotion struct {
+	key   string
+	value []byte
+	usage int
+}
+
+// Open opens the cache stored in the directory, which must exist, creating
+// it if it is empty. Returns lfucache.ErrInvalidCapacity if either
+// capacity is not positive.
+func Open(dir string, opts Options) (*TieredCache, error) {
+	c := &TieredCache{}
+	mem, err := lfucache.NewWithOptions(lfucache.WithCapacity(opts.MemoryCapacity), lfucache.WithObserver(memObserver{c: c}))
+	if err != nil {
+		return nil, err
+	}
+	c.mem = mem
+
+	minCompact := opts.MinCompact
+	if minCompact <= 0 {
+		minCompact = DefaultMinCompact
+	}
+	c.disk, err = openDisk(dir, opts.DiskCapacity, minCompact)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Insert inserts an item into memory, replacing any item with the same key
+// on either level. The value must not be modified afterwards.
+func (c *TieredCache) Insert(key string, value []byte) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	if _, err := c.disk.delete(key); err != nil {
+		return err
+	}
+	c.mem.Insert(key, value)
+	return c.demote()
+}
+
+// Access returns the value for the key from memory or, promoting it to
+// memory, from disk. The returned value must not be modified.
+func (c *TieredCache) Access(key string) ([]byte, bool, error) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if c.closed {
+		return nil, false, ErrClosed
+	}
+	if v, ok := c.mem.Access(key); ok {
+		return v.([]byte), true, nil
+	}
+
+	value, usage, ok, err := c.disk.get(key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	if _, err := c.disk.delete(key); err != nil {
+		return nil, false, err
+	}
+	// The access counts towards the use count, as it would have in memory
+	if err := c.mem.InsertWithUsage(key, value, usage+1); err != nil {
+		return nil, false, err
+	}
+	c.diskHits++
+	return value, true, c.demote()
+}
+
+// Delete deletes the item with the key from both levels and returns true.
+// Returns false if there was no such item.
+func (c *TieredCache) Delete(key string) (bool, error) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if c.closed {
+		return false, ErrClosed
+	}
+	inMem := c.mem.Delete(key)
+	onDisk, err := c.disk.delete(key)
+	return inMem || onDisk, err
+}
+
+// Len returns the number of items in memory and on disk.
+func (c *TieredCache) Len() (memory, disk int) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	return c.mem.Len(), c.disk.index.Len()
+}
+
+// Statistics returns the cache statistics.
+func (c *TieredCache) Statistics() Statistics {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	return Statistics{
+		Memory:      c.mem.Statistics(),
+		Disk:        c.disk.index.Statistics(),
+		DiskHits:    c.diskHits,
+		Demotions:   c.demotions,
+		Rejected:    c.disk.rejected,
+		Compactions: c.disk.compacts,
+		LogSize:     c.disk.size,
+		LiveSize:    c.disk.live,
+	}
+}
+
+// Close demotes all items in memory to disk and closes the log. The items
+// are demoted from the least to the most frequently used, so that the disk
+// level keeps the most frequently used items when it cannot hold all of
+// them.
+func (c *TieredCache) Close() error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	c.closed = true
+
+	hot := c.mem.Hottest(c.mem.Len())
+	for i := len(hot) - 1; i >= 0; i-- {
+		key := hot[i].Key.(string)
+		v, _ := c.mem.Peek(key)
+		if _, err := c.disk.put(key, v.([]byte), hot[i].Usage); err != nil {
+			c.disk.close()
+			return err
+		}
+	}
+	return c.disk.close()
+}
+
+// demote writes the items evicted from memory by the current operation to
+// disk
+func (c *TieredCache) demote() error {
+	for len(c.demoted) > 0 {
+		d := c.demoted[0]
+		c.demoted = c.demoted[1:]
+		stored, err := c.disk.put(d.key, d.value, d.usage)
+		if err != nil {
+			c.demoted = nil
+			return err
+		}
+		if stored {
+			c.demotions++
+		}
+	}
+	return nil
+}
+
+// memObserver collects the items evicted from memory to make room, to be
+// demoted once the operation evicting them has returned
+type memObserver struct {
+	lfucache.NopObserver
+	c *TieredCache
+}
+
+func (o memObserver) OnEvict(e lfucache.Event) {
+	switch e.Reason {
+	case lfucache.EvictCapacity, lfucache.EvictResize:
+		o.c.demoted = append(o.c.demoted, demotion{e.Key.(string), e.Value.([]byte), e.UsageBefore})
+	}
+}
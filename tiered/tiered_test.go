@@ -0,0 +1,192 @@
+package tiered
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func open(t *testing.T, dir string, opts Options) *TieredCache {
+	t.Helper()
+	c, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func expectValue(t *testing.T, c *TieredCache, key, value string) {
+	t.Helper()
+	v, ok, err := c.Access(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || string(v) != value {
+		t.Errorf("Access(%q) returned %q, %v, expected %q", key, v, ok, value)
+	}
+}
+
+func TestDemoteAndPromote(t *testing.T) {
+	c := open(t, t.TempDir(), Options{MemoryCapacity: 1, DiskCapacity: 10})
+	defer c.Close()
+
+	c.Insert("test1", []byte("a"))
+	for i := 0; i < 3; i++ {
+		c.Access("test1")
+	}
+	c.Insert("test2", []byte("b")) // demotes test1
+	if u, _ := c.disk.index.Usage("test1"); u != 3 {
+		t.Errorf("Demoted with usage %d", u)
+	}
+
+	expectValue(t, c, "test1", "a") // promotes test1, demotes test2
+	if u, _ := c.mem.Usage("test1"); u != 4 {
+		t.Errorf("Promoted with usage %d", u)
+	}
+	if m, d := c.Len(); m != 1 || d != 1 {
+		t.Errorf("Unexpected lengths %d, %d", m, d)
+	}
+	expectValue(t, c, "test2", "b")
+
+	if ok, _ := c.Delete("test1"); !ok {
+		t.Error("Delete of demoted test1 failed")
+	}
+	if _, ok, _ := c.Access("test1"); ok {
+		t.Error("Deleted test1 found")
+	}
+
+	s := c.Statistics()
+	if s.DiskHits != 2 || s.Demotions != 3 || s.Memory.Hits != 3 {
+		t.Errorf("Unexpected statistics %+v", s)
+	}
+}
+
+func TestInsertReplacesDiskItem(t *testing.T) {
+	c := open(t, t.TempDir(), Options{MemoryCapacity: 1, DiskCapacity: 10})
+	defer c.Close()
+
+	c.Insert("test1", []byte("a"))
+	c.Insert("test2", []byte("b"))
+	c.Insert("test1", []byte("c"))
+	expectValue(t, c, "test1", "c")
+	expectValue(t, c, "test2", "b")
+}
+
+func TestDiskCapacity(t *testing.T) {
+	dir := t.TempDir()
+	c := open(t, dir, Options{MemoryCapacity: 1, DiskCapacity: 2})
+
+	c.Insert("test1", []byte("a"))
+	c.Access("test1")
+	c.Insert("test2", []byte("b"))
+	c.Insert("test3", []byte("c"))
+	c.Access("test3")
+	c.Insert("test4", []byte("d")) // demotes test3, evicting test2 from disk
+	if _, ok, _ := c.Access("test2"); ok {
+		t.Error("test2 not evicted from disk")
+	}
+	c.Close()
+	if s := c.Statistics(); s.Rejected != 1 {
+		t.Errorf("test4 was not rejected, %+v", s)
+	}
+
+	c = open(t, dir, Options{MemoryCapacity: 1, DiskCapacity: 2})
+	defer c.Close()
+	if m, d := c.Len(); m != 0 || d != 2 {
+		t.Errorf("Unexpected lengths %d, %d after reopen", m, d)
+	}
+	expectValue(t, c, "test1", "a")
+	expectValue(t, c, "test3", "c")
+}
+
+func TestCloseAndReopen(t *testing.T) {
+	dir := t.TempDir()
+	c := open(t, dir, Options{MemoryCapacity: 10, DiskCapacity: 10})
+	c.Insert("test1", []byte("a"))
+	c.Access("test1")
+	c.Insert("test2", []byte("b"))
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Insert("test3", nil); err != ErrClosed {
+		t.Errorf("Unexpected error %v after Close", err)
+	}
+
+	c = open(t, dir, Options{MemoryCapacity: 10, DiskCapacity: 10})
+	defer c.Close()
+	if u, _ := c.disk.index.Usage("test1"); u != 1 {
+		t.Errorf("Usage %d after reopen", u)
+	}
+	expectValue(t, c, "test1", "a")
+	expectValue(t, c, "test2", "b")
+}
+
+func TestCrashRecovery(t *testing.T) {
+	dir := t.TempDir()
+	c := open(t, dir, Options{MemoryCapacity: 1, DiskCapacity: 10})
+	for i := 0; i < 5; i++ {
+		c.Insert("test"+strconv.Itoa(i), []byte{byte('a' + i)})
+	}
+	// Crash, losing test4 in memory and tearing the record of test3
+	c.disk.f.Close()
+	path := filepath.Join(dir, logName)
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, fi.Size()-1); err != nil {
+		t.Fatal(err)
+	}
+	// A compaction in progress is discarded
+	os.WriteFile(filepath.Join(dir, compactName), []byte("garbage"), 0o644)
+
+	c = open(t, dir, Options{MemoryCapacity: 1, DiskCapacity: 10})
+	defer c.Close()
+	if _, d := c.Len(); d != 3 {
+		t.Errorf("Recovered %d items", d)
+	}
+	for i := 0; i < 3; i++ {
+		expectValue(t, c, "test"+strconv.Itoa(i), string(rune('a'+i)))
+	}
+	if _, err := os.Stat(filepath.Join(dir, compactName)); !os.IsNotExist(err) {
+		t.Errorf("Compaction file left behind, %v", err)
+	}
+
+	// New records are appended after the truncated tail
+	c.Insert("test5", []byte("f"))
+	c.Insert("test6", []byte("g"))
+	c.Close()
+	c = open(t, dir, Options{MemoryCapacity: 1, DiskCapacity: 10})
+	expectValue(t, c, "test5", "f")
+}
+
+func TestCompaction(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{MemoryCapacity: 1, DiskCapacity: 4, MinCompact: 256}
+	c := open(t, dir, opts)
+	for i := 0; i < 100; i++ {
+		c.Insert("test"+strconv.Itoa(i%5), []byte(strconv.Itoa(i)))
+	}
+
+	s := c.Statistics()
+	if s.Compactions == 0 || s.LogSize > 2*s.LiveSize+256 {
+		t.Errorf("Unexpected statistics %+v", s)
+	}
+	c.Close()
+
+	c = open(t, dir, opts)
+	defer c.Close()
+	for i := 96; i < 100; i++ {
+		expectValue(t, c, "test"+strconv.Itoa(i%5), strconv.Itoa(i))
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	if _, err := Open(t.TempDir(), Options{DiskCapacity: 1}); err == nil {
+		t.Error("Unexpected nil error for zero memory capacity")
+	}
+	if _, err := Open(filepath.Join(t.TempDir(), "missing"), Options{MemoryCapacity: 1, DiskCapacity: 1}); err == nil {
+		t.Error("Unexpected nil error for missing directory")
+	}
+}
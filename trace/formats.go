@@ -0,0 +1,112 @@
+package trace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The standard trace formats are line based and contain only requests, so
+// the records read from them have Op set to OpAccess and no time.
+
+// lineSource is a Source parsing one line at a time into a run of count
+// records with consecutive keys, starting with rec. The records of a run
+// are produced as they are read, so that a large count costs no memory.
+type lineSource struct {
+	s     *bufio.Scanner
+	line  int
+	next  Record // next record of the current run
+	left  uint64 // number of records left in the current run
+	parse func(fields []string) (rec Record, count uint64, err error)
+}
+
+func (l *lineSource) Next() (Record, error) {
+	for l.left == 0 {
+		if !l.s.Scan() {
+			if err := l.s.Err(); err != nil {
+				return Record{}, err
+			}
+			return Record{}, io.EOF
+		}
+		l.line++
+
+		line := strings.TrimSpace(l.s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rec, count, err := l.parse(strings.FieldsFunc(line, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ','
+		}))
+		if err != nil {
+			return Record{}, fmt.Errorf("trace: line %d: %w", l.line, err)
+		}
+		l.next, l.left = rec, count
+	}
+
+	rec := l.next
+	l.next.Key++
+	l.left--
+	return rec, nil
+}
+
+// NewARCReader returns a Source reading the format of the ARC paper traces,
+// where each line is "start count ignored request" and denotes requests for
+// the count blocks starting at start.
+func NewARCReader(r io.Reader) Source {
+	return &lineSource{s: bufio.NewScanner(r), parse: func(fields []string) (Record, uint64, error) {
+		if len(fields) < 2 {
+			return Record{}, 0, fmt.Errorf("expected at least two fields, got %d", len(fields))
+		}
+		start, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return Record{}, 0, err
+		}
+		count, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return Record{}, 0, err
+		}
+		return Record{Op: OpAccess, Key: start}, count, nil
+	}}
+}
+
+// NewLIRSReader returns a Source reading the format of the LIRS paper
+// traces, with one block number per line. Lines containing "*" are
+// ignored.
+func NewLIRSReader(r io.Reader) Source {
+	return &lineSource{s: bufio.NewScanner(r), parse: func(fields []string) (Record, uint64, error) {
+		if fields[0] == "*" {
+			return Record{}, 0, nil
+		}
+		block, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return Record{}, 0, err
+		}
+		return Record{Op: OpAccess, Key: block}, 1, nil
+	}}
+}
+
+// NewUMassReader returns a Source reading the UMass Trace Repository SPC
+// format, where each line is "ASU,LBA,size,opcode,timestamp". Each line is
+// one request, keyed by the ASU and LBA.
+func NewUMassReader(r io.Reader) Source {
+	return &lineSource{s: bufio.NewScanner(r), parse: func(fields []string) (Record, uint64, error) {
+		if len(fields) < 3 {
+			return Record{}, 0, fmt.Errorf("expected at least three fields, got %d", len(fields))
+		}
+		asu, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil {
+			return Record{}, 0, err
+		}
+		lba, err := strconv.ParseUint(fields[1], 10, 48)
+		if err != nil {
+			return Record{}, 0, err
+		}
+		size, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return Record{}, 0, err
+		}
+		return Record{Op: OpAccess, Key: asu<<48 | lba, Size: size}, 1, nil
+	}}
+}
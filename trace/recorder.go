@@ -0,0 +1,119 @@
+package trace
+
+import (
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/calmh/deprecated_lfucache"
+)
+
+// Recorder is an lfucache.Observer writing a trace of all item operations.
+// Resizes are not recorded.
+type Recorder struct {
+	mut sync.Mutex
+	w   *Writer
+	err error
+}
+
+// NewRecorder returns a Recorder writing the trace to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: NewWriter(w)}
+}
+
+func (r *Recorder) OnInsert(e lfucache.Event)       { r.record(OpInsert, e) }
+func (r *Recorder) OnHit(e lfucache.Event)          { r.record(OpHit, e) }
+func (r *Recorder) OnMiss(e lfucache.Event)         { r.record(OpMiss, e) }
+func (r *Recorder) OnEvict(e lfucache.Event)        { r.record(OpEvict, e) }
+func (r *Recorder) OnDelete(e lfucache.Event)       { r.record(OpDelete, e) }
+func (r *Recorder) OnResize(e lfucache.ResizeEvent) {}
+
+// Flush writes any buffered records and returns the first error
+// encountered while recording, if any.
+func (r *Recorder) Flush() error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.err = r.w.Flush()
+	return r.err
+}
+
+func (r *Recorder) record(op Op, e lfucache.Event) {
+	rec := Record{
+		Op:   op,
+		Key:  HashNamespacedKey(e.Namespace, e.Key),
+		Size: valueSize(e.Value),
+		Time: time.Now(),
+	}
+
+	r.mut.Lock()
+	if r.err == nil {
+		r.err = r.w.Write(rec)
+	}
+	r.mut.Unlock()
+}
+
+// HashKey returns a 64 bit FNV-1a hash of the key, as recorded for keys
+// outside of namespaces. The hash is stable across processes for strings,
+// byte slices and integers; other types are hashed by their %#v
+// representation. The type is hashed along with the value, so that keys
+// the cache keeps apart, such as 1 and "1", hash differently.
+func HashKey(key interface{}) uint64 {
+	h := fnv.New64a()
+	writeKey(h, key)
+	return h.Sum64()
+}
+
+// HashNamespacedKey returns the hash of the key in the namespace, as
+// recorded for keys in namespaces. The empty namespace is the root key
+// space, giving the same hash as HashKey.
+func HashNamespacedKey(namespace string, key interface{}) uint64 {
+	h := fnv.New64a()
+	if namespace != "" {
+		// The length keeps the namespace apart from the key
+		buf := append([]byte{'n'}, strconv.Itoa(len(namespace))...)
+		buf = append(buf, ':')
+		h.Write(append(buf, namespace...))
+	}
+	writeKey(h, key)
+	return h.Sum64()
+}
+
+// writeKey writes a type tag and the representation of the key to the hash
+func writeKey(h hash.Hash64, key interface{}) {
+	switch k := key.(type) {
+	case string:
+		h.Write([]byte{'s'})
+		h.Write([]byte(k))
+	case []byte:
+		h.Write([]byte{'b'})
+		h.Write(k)
+	case int:
+		h.Write(strconv.AppendInt([]byte{'i'}, int64(k), 10))
+	case int64:
+		h.Write(strconv.AppendInt([]byte{'l'}, k, 10))
+	case uint64:
+		h.Write(strconv.AppendUint([]byte{'u'}, k, 10))
+	default:
+		h.Write([]byte{'v'})
+		fmt.Fprintf(h, "%#v", key)
+	}
+}
+
+// valueSize returns the size of values with an obvious size, zero
+// otherwise
+func valueSize(v interface{}) uint64 {
+	switch v := v.(type) {
+	case string:
+		return uint64(len(v))
+	case []byte:
+		return uint64(len(v))
+	}
+	return 0
+}
@@ -0,0 +1,193 @@
+// Package trace records cache access patterns in a compact binary format
+// and reads them back, along with some standard trace formats used in
+// cache research, for offline replay.
+//
+// A trace starts with the eight byte magic "LFUTRACE" followed by a version
+// byte. Each record is then one op byte, the eight byte little endian key
+// hash, the value size as a uvarint and the time since the previous record,
+// in nanoseconds, as a uvarint.
+package trace // import "github.com/calmh/deprecated_lfucache/trace"
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Op is the kind of operation a record describes.
+type Op byte
+
+const (
+	OpAccess Op = iota // A request for a key, outcome unknown
+	OpHit              // A request for a key that was in the cache
+	OpMiss             // A request for a key that was not in the cache
+	OpInsert
+	OpDelete
+	OpEvict
+	numOps
+)
+
+var opNames = [numOps]string{"access", "hit", "miss", "insert", "delete", "evict"}
+
+func (o Op) String() string {
+	if o >= numOps {
+		return fmt.Sprintf("op(%d)", byte(o))
+	}
+	return opNames[o]
+}
+
+// IsRequest returns true for the ops representing a request for a key,
+// which is what a simulation replays.
+func (o Op) IsRequest() bool {
+	return o == OpAccess || o == OpHit || o == OpMiss
+}
+
+// Record is a single traced operation.
+type Record struct {
+	Op   Op
+	Key  uint64 // Hash of the key
+	Size uint64 // Size of the value, or zero if unknown
+	Time time.Time
+}
+
+// Source is a sequence of records. Next returns io.EOF at the end of the
+// sequence.
+type Source interface {
+	Next() (Record, error)
+}
+
+const (
+	magic   = "LFUTRACE"
+	version = 1
+)
+
+var (
+	errBadMagic   = errors.New("trace: not a trace file")
+	errBadVersion = errors.New("trace: unsupported version")
+	errBadOp      = errors.New("trace: invalid op")
+)
+
+// Writer writes records in the binary trace format.
+type Writer struct {
+	w    *bufio.Writer
+	last time.Time
+	buf  [1 + 8 + 2*binary.MaxVarintLen64]byte
+	err  error
+}
+
+// NewWriter returns a Writer writing to w. The header is written
+// immediately.
+func NewWriter(w io.Writer) *Writer {
+	tw := &Writer{w: bufio.NewWriter(w)}
+	tw.w.WriteString(magic)
+	tw.err = tw.w.WriteByte(version)
+	return tw
+}
+
+// Write appends a record to the trace. Records should be written in time
+// order; a record older than the previous one is stored as happening at
+// the same time as the previous one.
+func (w *Writer) Write(r Record) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	// The first record carries the absolute time, later ones the time
+	// since the previous record.
+	var delta uint64
+	switch {
+	case w.last.IsZero():
+		delta = uint64(r.Time.UnixNano())
+		w.last = r.Time
+	case r.Time.After(w.last):
+		delta = uint64(r.Time.Sub(w.last))
+		w.last = r.Time
+	}
+
+	w.buf[0] = byte(r.Op)
+	binary.LittleEndian.PutUint64(w.buf[1:], r.Key)
+	n := 9
+	n += binary.PutUvarint(w.buf[n:], r.Size)
+	n += binary.PutUvarint(w.buf[n:], delta)
+	_, w.err = w.w.Write(w.buf[:n])
+	return w.err
+}
+
+// Flush writes any buffered records to the underlying writer.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.w.Flush()
+	return w.err
+}
+
+// Reader reads records in the binary trace format.
+type Reader struct {
+	r    *bufio.Reader
+	last time.Time
+	key  [8]byte
+}
+
+// NewReader returns a Reader reading from r, after verifying the header.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	hdr := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return nil, errBadMagic
+	}
+	if string(hdr[:len(magic)]) != magic {
+		return nil, errBadMagic
+	}
+	if hdr[len(magic)] != version {
+		return nil, errBadVersion
+	}
+	return &Reader{r: br}, nil
+}
+
+// Next returns the next record in the trace, or io.EOF at the end. A
+// truncated final record results in io.ErrUnexpectedEOF.
+func (r *Reader) Next() (Record, error) {
+	op, err := r.r.ReadByte()
+	if err != nil {
+		return Record{}, err
+	}
+	if Op(op) >= numOps {
+		return Record{}, errBadOp
+	}
+
+	if _, err := io.ReadFull(r.r, r.key[:]); err != nil {
+		return Record{}, unexpected(err)
+	}
+	size, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return Record{}, unexpected(err)
+	}
+	delta, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return Record{}, unexpected(err)
+	}
+
+	if r.last.IsZero() {
+		r.last = time.Unix(0, int64(delta))
+	} else {
+		r.last = r.last.Add(time.Duration(delta))
+	}
+
+	return Record{
+		Op:   Op(op),
+		Key:  binary.LittleEndian.Uint64(r.key[:]),
+		Size: size,
+		Time: r.last,
+	}, nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,187 @@
+package trace_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/calmh/deprecated_lfucache"
+	"github.com/calmh/deprecated_lfucache/trace"
+)
+
+func TestWriteRead(t *testing.T) {
+	t0 := time.Unix(1234567890, 0)
+	recs := []trace.Record{
+		{Op: trace.OpInsert, Key: 1, Size: 100, Time: t0},
+		{Op: trace.OpHit, Key: 1, Time: t0.Add(time.Millisecond)},
+		{Op: trace.OpMiss, Key: 2, Time: t0.Add(time.Second)},
+		{Op: trace.OpEvict, Key: 1<<64 - 1, Size: 1 << 40, Time: t0.Add(time.Second)},
+	}
+
+	var buf bytes.Buffer
+	w := trace.NewWriter(&buf)
+	for _, r := range recs {
+		if err := w.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := trace.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, exp := range recs {
+		rec, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Op != exp.Op || rec.Key != exp.Key || rec.Size != exp.Size || !rec.Time.Equal(exp.Time) {
+			t.Errorf("Record %d mismatch, %+v != %+v", i, rec, exp)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+}
+
+func TestTruncatedTrace(t *testing.T) {
+	var buf bytes.Buffer
+	w := trace.NewWriter(&buf)
+	w.Write(trace.Record{Op: trace.OpHit, Key: 42, Time: time.Now()})
+	w.Flush()
+
+	r, err := trace.NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-3]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected unexpected EOF, got %v", err)
+	}
+
+	if _, err := trace.NewReader(strings.NewReader("NOTATRACE")); err == nil {
+		t.Error("Expected error for bad magic")
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	var buf bytes.Buffer
+	rec := trace.NewRecorder(&buf)
+	c := lfucache.New(1, lfucache.WithObserver(rec))
+
+	c.Access("test1")
+	c.Insert("test1", "value")
+	c.Access("test1")
+	c.Insert("test2", "value2")
+	if err := rec.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := trace.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []trace.Record{
+		{Op: trace.OpMiss, Key: trace.HashKey("test1")},
+		{Op: trace.OpInsert, Key: trace.HashKey("test1"), Size: 5},
+		{Op: trace.OpHit, Key: trace.HashKey("test1"), Size: 5},
+		{Op: trace.OpEvict, Key: trace.HashKey("test1"), Size: 5},
+		{Op: trace.OpInsert, Key: trace.HashKey("test2"), Size: 6},
+	}
+	for i, exp := range expected {
+		rec, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Op != exp.Op || rec.Key != exp.Key || rec.Size != exp.Size {
+			t.Errorf("Record %d mismatch, %+v != %+v", i, rec, exp)
+		}
+	}
+}
+
+func readAll(t *testing.T, src trace.Source) []trace.Record {
+	var recs []trace.Record
+	for {
+		rec, err := src.Next()
+		if err == io.EOF {
+			return recs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rec)
+	}
+}
+
+func TestStandardFormats(t *testing.T) {
+	arc := readAll(t, trace.NewARCReader(strings.NewReader("10 3 0 1\n\n20 1 0 2\n")))
+	if len(arc) != 4 || arc[0].Key != 10 || arc[2].Key != 12 || arc[3].Key != 20 {
+		t.Errorf("Unexpected ARC records %+v", arc)
+	}
+
+	lirs := readAll(t, trace.NewLIRSReader(strings.NewReader("5\n*\n7\n")))
+	if len(lirs) != 2 || lirs[0].Key != 5 || lirs[1].Key != 7 {
+		t.Errorf("Unexpected LIRS records %+v", lirs)
+	}
+
+	umass := readAll(t, trace.NewUMassReader(strings.NewReader("0,20941264,8192,W,0.551706\n1,20939840,4096,r,0.554041\n")))
+	if len(umass) != 2 || umass[0].Key != 20941264 || umass[1].Key != 1<<48|20939840 || umass[1].Size != 4096 {
+		t.Errorf("Unexpected UMass records %+v", umass)
+	}
+
+	if _, err := trace.NewLIRSReader(strings.NewReader("1\nx\n")).Next(); err != nil {
+		t.Error("Unexpected error on first record", err)
+	}
+	src := trace.NewLIRSReader(strings.NewReader("1\nx\n"))
+	src.Next()
+	if _, err := src.Next(); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected line 2 error, got %v", err)
+	}
+}
+
+func TestHashKeyDistinct(t *testing.T) {
+	hashes := map[uint64]string{}
+	for name, h := range map[string]uint64{
+		`1`:            trace.HashKey(1),
+		`"1"`:          trace.HashKey("1"),
+		`int64(1)`:     trace.HashKey(int64(1)),
+		`[]byte("1")`:  trace.HashKey([]byte("1")),
+		`"a"`:          trace.HashKey("a"),
+		`a/"b"`:        trace.HashNamespacedKey("a", "b"),
+		`b/"a"`:        trace.HashNamespacedKey("b", "a"),
+		`a/"a"`:        trace.HashNamespacedKey("a", "a"),
+		`ab/"c"`:       trace.HashNamespacedKey("ab", "c"),
+		`a/"bc"`:       trace.HashNamespacedKey("a", "bc"),
+		`namespace ""`: trace.HashNamespacedKey("", "x"),
+	} {
+		if h == 0 {
+			t.Errorf("Zero hash for %s", name)
+		}
+		if other, ok := hashes[h]; ok {
+			t.Errorf("Hash collision between %s and %s", name, other)
+		}
+		hashes[h] = name
+	}
+
+	if trace.HashNamespacedKey("", "x") != trace.HashKey("x") {
+		t.Error("Empty namespace should hash as the root key space")
+	}
+}
+
+func TestARCLargeCount(t *testing.T) {
+	// A count of four billion must not be allocated up front
+	src := trace.NewARCReader(strings.NewReader("10 4000000000 0 1\n"))
+	for i := uint64(0); i < 3; i++ {
+		rec, err := src.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Key != 10+i {
+			t.Errorf("Unexpected key %d", rec.Key)
+		}
+	}
+}
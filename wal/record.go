@@ -0,0 +1,130 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// A record is a header followed by the key and value. The checksum covers
+// everything following it, so that a record torn by a crash is detected.
+//
+//	checksum  uint32
+//	op        uint8
+//	usage     uint32
+//	keyLen    uint32
+//	valueLen  uint32
+const recordHeaderLen = 4 + 1 + 4 + 4 + 4
+
+const (
+	opPut      byte = 1 // Key, value and usage of an inserted item
+	opDelete   byte = 2 // Key of a deleted or evicted item
+	opUsage    byte = 3 // Key and new usage of an accessed item
+	opSnapshot byte = 4 // First record of a snapshot, the value holds the sequence number of the following segment
+)
+
+// maxRecordLen limits the allocation for a record read from a corrupt log
+const maxRecordLen = 1 << 30
+
+var errCorruptRecord = errors.New("corrupt record")
+
+// ErrUnsupportedType is recorded by the log when a key or value cannot be
+// marshalled by its Codec.
+var ErrUnsupportedType = errors.New("unsupported type")
+
+// Codec converts keys or values to and from their logged representation.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte) (interface{}, error)
+}
+
+// Codecs for the common key and value types.
+var (
+	StringCodec Codec = stringCodec{} // string values
+	BytesCodec  Codec = bytesCodec{}  // []byte values
+)
+
+type stringCodec struct{}
+
+func (stringCodec) Marshal(v interface{}) ([]byte, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, ErrUnsupportedType
+	}
+	return []byte(s), nil
+}
+
+func (stringCodec) Unmarshal(data []byte) (interface{}, error) {
+	return string(data), nil
+}
+
+type bytesCodec struct{}
+
+func (bytesCodec) Marshal(v interface{}) ([]byte, error) {
+	bs, ok := v.([]byte)
+	if !ok {
+		return nil, ErrUnsupportedType
+	}
+	return bs, nil
+}
+
+func (bytesCodec) Unmarshal(data []byte) (interface{}, error) {
+	return data, nil
+}
+
+// record is a decoded record
+type record struct {
+	op    byte
+	usage int
+	key   []byte
+	value []byte
+}
+
+// appendRecord appends the encoded record to buf
+func appendRecord(buf []byte, r record) []byte {
+	start := len(buf)
+	buf = append(buf, 0, 0, 0, 0, r.op)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(r.usage))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(r.key)))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(r.value)))
+	buf = append(buf, r.key...)
+	buf = append(buf, r.value...)
+	binary.BigEndian.PutUint32(buf[start:], crc32.ChecksumIEEE(buf[start+4:]))
+	return buf
+}
+
+// readRecord reads and verifies the next record and returns it with its
+// encoded length. Returns io.EOF at the end of the input and
+// errCorruptRecord for a torn or otherwise invalid record.
+func readRecord(r io.Reader) (record, int64, error) {
+	var hdr [recordHeaderLen]byte
+	if n, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.EOF && n == 0 {
+			return record{}, 0, io.EOF
+		}
+		return record{}, 0, errCorruptRecord
+	}
+	keyLen := binary.BigEndian.Uint32(hdr[9:])
+	valueLen := binary.BigEndian.Uint32(hdr[13:])
+	if uint64(keyLen)+uint64(valueLen) > maxRecordLen {
+		return record{}, 0, errCorruptRecord
+	}
+
+	body := make([]byte, int(keyLen)+int(valueLen))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return record{}, 0, errCorruptRecord
+	}
+	crc := crc32.Update(crc32.ChecksumIEEE(hdr[4:]), crc32.IEEETable, body)
+	op := hdr[4]
+	if crc != binary.BigEndian.Uint32(hdr[:]) || op < opPut || op > opSnapshot {
+		return record{}, 0, errCorruptRecord
+	}
+
+	return record{
+		op:    op,
+		usage: int(binary.BigEndian.Uint32(hdr[5:])),
+		key:   body[:keyLen:keyLen],
+		value: body[keyLen:],
+	}, int64(len(hdr) + len(body)), nil
+}
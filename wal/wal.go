@@ -0,0 +1,635 @@
+// Package wal persists an lfucache.Cache in a directory, as a snapshot
+// followed by a write-ahead log of the operations since.
+//
+// The log is an lfucache.Observer, recording inserts, deletes, evictions
+// and the use counts of accessed items. Accesses are coalesced: the new
+// use count of an item is recorded once, before the log is synced or once
+// enough accessed items are pending, however many times the item was
+// accessed, and not at all if the item is deleted in the meantime. On
+// Open, the snapshot and the log are replayed to reconstruct both the
+// items and their use counts. A record torn by a crash at the end of the
+// log is discarded.
+//
+// The log is written in segments, starting a new one when the current one
+// exceeds Options.SegmentSize. Compact writes a new snapshot of the cache
+// and removes the segments preceding it.
+//
+// Keys and values are marshalled by a Codec, by default StringCodec for
+// keys and BytesCodec for values. Items in namespaces are not logged.
+package wal // import "github.com/calmh/deprecated_lfucache/wal"
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/calmh/deprecated_lfucache"
+)
+
+const (
+	snapshotName    = "snapshot"
+	snapshotTmpName = "snapshot.tmp"
+	segmentPrefix   = "wal-"
+	segmentSuffix   = ".log"
+)
+
+// Defaults for the zero values in Options.
+const (
+	DefaultSegmentSize  = 64 << 20
+	DefaultSyncInterval = time.Second
+	DefaultMaxPending   = 4096
+)
+
+// ErrCorruptLog is returned by Open when a snapshot, or a log segment
+// other than at its very end, is corrupt.
+var ErrCorruptLog = errors.New("corrupt log")
+
+// ErrClosed is returned by operations on a closed log.
+var ErrClosed = errors.New("log closed")
+
+// SyncPolicy determines when the log is written to stable storage.
+type SyncPolicy int
+
+const (
+	SyncInterval SyncPolicy = iota // Flushed and synced every Options.SyncInterval
+	SyncAlways                     // Flushed and synced after every operation
+	SyncNone                       // Flushed when the buffer is full, synced only on Sync, Compact and Close
+)
+
+// Options configures the log.
+type Options struct {
+	Sync         SyncPolicy
+	SyncInterval time.Duration // For SyncInterval, DefaultSyncInterval if zero
+	SegmentSize  int64         // Size at which a new segment is started, DefaultSegmentSize if zero
+	MaxPending   int           // Accessed items pending before their use counts are written, DefaultMaxPending if zero
+	KeyCodec     Codec         // StringCodec if nil
+	ValueCodec   Codec         // BytesCodec if nil
+}
+
+// Log is the write-ahead log of a cache. Its methods must not be called
+// concurrently with operations on the cache, as the cache itself; the log
+// is safe for concurrent use otherwise.
+type Log struct {
+	dir   string
+	opts  Options
+	cache *lfucache.Cache
+
+	mut      sync.Mutex
+	f        *os.File
+	w        *bufio.Writer
+	seq      uint64 // Of the current segment
+	size     int64  // Of the current segment
+	pending  map[interface{}]pendingUsage
+	accesses uint64 // Orders the pending use counts
+	closed   bool
+	err      error // The first error, after which nothing is written
+	buf      []byte
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// pendingUsage is the use count of an accessed item, not yet written
+type pendingUsage struct {
+	usage  int
+	access uint64 // When the item was last accessed
+}
+
+// Open opens the cache persisted in the directory, which must exist,
+// creating it if empty. The cache is created with the given options, and
+// the log is added to any observer among them once the cache is loaded.
+func Open(dir string, opts Options, cacheOpts ...lfucache.Option) (*lfucache.Cache, *Log, error) {
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = DefaultSyncInterval
+	}
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+	if opts.MaxPending <= 0 {
+		opts.MaxPending = DefaultMaxPending
+	}
+	if opts.KeyCodec == nil {
+		opts.KeyCodec = StringCodec
+	}
+	if opts.ValueCodec == nil {
+		opts.ValueCodec = BytesCodec
+	}
+
+	l := &Log{
+		dir:     dir,
+		opts:    opts,
+		pending: make(map[interface{}]pendingUsage),
+	}
+	cache, err := lfucache.NewWithOptions(cacheOpts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	l.cache = cache
+
+	// The replayed operations are not observed
+	observer := cache.Observer()
+	cache.SetObserver(nil)
+	if err := l.recover(); err != nil {
+		if l.f != nil {
+			l.f.Close()
+		}
+		return nil, nil, err
+	}
+	cache.SetObserver(lfucache.MultiObserver(observer, l))
+
+	if opts.Sync == SyncInterval {
+		l.stop = make(chan struct{})
+		l.done = make(chan struct{})
+		go l.syncLoop()
+	}
+	return cache, l, nil
+}
+
+// recover replays the snapshot and the segments following it, and opens
+// the last segment for appending
+func (l *Log) recover() error {
+	os.Remove(filepath.Join(l.dir, snapshotTmpName))
+
+	first := uint64(1)
+	if f, err := os.Open(filepath.Join(l.dir, snapshotName)); err == nil {
+		first, err = l.replaySnapshot(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	seqs, err := l.segments()
+	if err != nil {
+		return err
+	}
+	var replay []uint64
+	for _, seq := range seqs {
+		if seq < first {
+			// Left behind by a Compact interrupted after the snapshot
+			if err := os.Remove(l.segmentPath(seq)); err != nil {
+				return err
+			}
+			continue
+		}
+		replay = append(replay, seq)
+	}
+
+	l.seq = first
+	for i, seq := range replay {
+		last := i == len(replay)-1
+		size, err := l.replaySegment(seq, last)
+		if err != nil {
+			return err
+		}
+		l.seq, l.size = seq, size
+	}
+
+	f, err := os.OpenFile(l.segmentPath(l.seq), os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(l.size); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(l.size, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	l.f = f
+	l.w = bufio.NewWriter(f)
+	return nil
+}
+
+// replaySnapshot loads the snapshot into the cache and returns the
+// sequence number of the segment following it
+func (l *Log) replaySnapshot(f *os.File) (uint64, error) {
+	r := bufio.NewReader(f)
+	rec, _, err := readRecord(r)
+	if err != nil || rec.op != opSnapshot || len(rec.value) != 8 {
+		return 0, fmt.Errorf("%w: %s: missing header", ErrCorruptLog, snapshotName)
+	}
+	next := binary.BigEndian.Uint64(rec.value)
+
+	for {
+		rec, _, err := readRecord(r)
+		if err == io.EOF {
+			return next, nil
+		}
+		if err != nil || rec.op != opPut {
+			return 0, fmt.Errorf("%w: %s", ErrCorruptLog, snapshotName)
+		}
+		if err := l.apply(rec); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// replaySegment applies the records in the segment and returns the length
+// of its valid part. A corrupt record is tolerated only in the last
+// segment, where it marks the end of the log.
+func (l *Log) replaySegment(seq uint64, last bool) (int64, error) {
+	f, err := os.Open(l.segmentPath(seq))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var size int64
+	for {
+		rec, n, err := readRecord(r)
+		if err == io.EOF {
+			return size, nil
+		}
+		if err != nil || rec.op == opSnapshot {
+			if last {
+				return size, nil
+			}
+			return 0, fmt.Errorf("%w: %s at offset %d", ErrCorruptLog, filepath.Base(l.segmentPath(seq)), size)
+		}
+		if err := l.apply(rec); err != nil {
+			return 0, err
+		}
+		size += n
+	}
+}
+
+// apply replays a record onto the cache
+func (l *Log) apply(rec record) error {
+	key, err := l.opts.KeyCodec.Unmarshal(rec.key)
+	if err != nil {
+		return err
+	}
+
+	switch rec.op {
+	case opPut:
+		value, err := l.opts.ValueCodec.Unmarshal(rec.value)
+		if err != nil {
+			return err
+		}
+		return l.cache.InsertWithUsage(key, value, rec.usage)
+	case opDelete:
+		l.cache.Delete(key)
+	case opUsage:
+		// There is no way to set the use count of an item in place, so it
+		// is reinserted
+		if value, ok := l.cache.Peek(key); ok {
+			return l.cache.InsertWithUsage(key, value, rec.usage)
+		}
+	}
+	return nil
+}
+
+// segments returns the sequence numbers of the segments in the directory,
+// in increasing order
+func (l *Log) segments() ([]uint64, error) {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	var seqs []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		var seq uint64
+		if _, err := fmt.Sscanf(strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentSuffix), "%x", &seq); err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(a, b int) bool {
+		return seqs[a] < seqs[b]
+	})
+	return seqs, nil
+}
+
+func (l *Log) segmentPath(seq uint64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%s%016x%s", segmentPrefix, seq, segmentSuffix))
+}
+
+// Sync writes the pending use counts and buffered records and syncs the
+// current segment to stable storage. Returns the first error encountered
+// by the log, if any.
+func (l *Log) Sync() error {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+	l.sync()
+	return l.err
+}
+
+// Err returns the first error encountered while writing the log. Nothing
+// is written after an error.
+func (l *Log) Err() error {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	return l.err
+}
+
+// Compact writes a snapshot of the cache and removes the log segments
+// preceding it. Like the log itself, it must not be called concurrently
+// with operations on the cache.
+func (l *Log) Compact() error {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+	// Pending use counts are part of the snapshot
+	for key := range l.pending {
+		delete(l.pending, key)
+	}
+	l.rotate()
+	if l.err != nil {
+		return l.err
+	}
+
+	if err := l.writeSnapshot(); err != nil {
+		return err
+	}
+
+	seqs, err := l.segments()
+	if err != nil {
+		return err
+	}
+	for _, seq := range seqs {
+		if seq < l.seq {
+			if err := os.Remove(l.segmentPath(seq)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeSnapshot atomically replaces the snapshot with the contents of the
+// cache, from the least to the most frequently used item so that loading
+// it reproduces their order
+func (l *Log) writeSnapshot() error {
+	path := filepath.Join(l.dir, snapshotTmpName)
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	hdr := record{op: opSnapshot, value: binary.BigEndian.AppendUint64(nil, l.seq)}
+	if _, err := w.Write(appendRecord(nil, hdr)); err != nil {
+		return fail(err)
+	}
+	var buf []byte
+	l.cache.Range(func(key, value interface{}, usage int) bool {
+		var rec record
+		if rec, err = l.record(opPut, key, value, usage); err != nil {
+			return false
+		}
+		buf = appendRecord(buf[:0], rec)
+		_, err = w.Write(buf)
+		return err == nil
+	})
+	if err != nil {
+		return fail(err)
+	}
+	if err := w.Flush(); err != nil {
+		return fail(err)
+	}
+	if err := f.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return err
+	}
+	if err := os.Rename(path, filepath.Join(l.dir, snapshotName)); err != nil {
+		return err
+	}
+	return syncDir(l.dir)
+}
+
+// Close syncs and closes the log. The cache may be used on afterwards, but
+// nothing more is logged.
+func (l *Log) Close() error {
+	l.mut.Lock()
+	if l.closed {
+		l.mut.Unlock()
+		return ErrClosed
+	}
+	l.sync()
+	l.closed = true
+	if err := l.f.Close(); err != nil && l.err == nil {
+		l.err = err
+	}
+	err := l.err
+	l.mut.Unlock()
+
+	if l.stop != nil {
+		close(l.stop)
+		<-l.done
+	}
+	return err
+}
+
+func (l *Log) syncLoop() {
+	defer close(l.done)
+	t := time.NewTicker(l.opts.SyncInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			l.mut.Lock()
+			if !l.closed {
+				l.sync()
+			}
+			l.mut.Unlock()
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// sync writes the pending use counts, flushes the buffer and syncs the
+// segment
+func (l *Log) sync() {
+	l.flushPending()
+	if l.err != nil {
+		return
+	}
+	if err := l.w.Flush(); err != nil {
+		l.err = err
+		return
+	}
+	if err := l.f.Sync(); err != nil {
+		l.err = err
+	}
+}
+
+// rotate syncs and closes the current segment and starts the next one
+func (l *Log) rotate() {
+	l.sync()
+	if l.err != nil {
+		return
+	}
+	if err := l.f.Close(); err != nil {
+		l.err = err
+		return
+	}
+	f, err := os.OpenFile(l.segmentPath(l.seq+1), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		l.err = err
+		return
+	}
+	l.f = f
+	l.w.Reset(f)
+	l.seq++
+	l.size = 0
+}
+
+// flushPending writes the pending use counts, in the order the items were
+// last accessed so that replaying them reproduces the order of items with
+// the same use count
+func (l *Log) flushPending() {
+	keys := make([]interface{}, 0, len(l.pending))
+	for key := range l.pending {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		return l.pending[keys[a]].access < l.pending[keys[b]].access
+	})
+	for _, key := range keys {
+		l.write(opUsage, key, nil, l.pending[key].usage)
+		delete(l.pending, key)
+	}
+}
+
+// log writes a record for an operation on the cache
+func (l *Log) log(op byte, key, value interface{}, usage int) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if l.closed || l.err != nil {
+		return
+	}
+
+	if op == opUsage {
+		l.accesses++
+		l.pending[key] = pendingUsage{usage: usage, access: l.accesses}
+		if len(l.pending) < l.opts.MaxPending {
+			return
+		}
+		l.flushPending()
+	} else {
+		// Put and delete records supersede pending use counts
+		delete(l.pending, key)
+		l.write(op, key, value, usage)
+	}
+
+	if l.opts.Sync == SyncAlways {
+		l.sync()
+	}
+	if l.size >= l.opts.SegmentSize {
+		l.rotate()
+	}
+}
+
+// write buffers a record
+func (l *Log) write(op byte, key, value interface{}, usage int) {
+	if l.err != nil {
+		return
+	}
+	rec, err := l.record(op, key, value, usage)
+	if err != nil {
+		l.err = err
+		return
+	}
+	l.buf = appendRecord(l.buf[:0], rec)
+	if _, err := l.w.Write(l.buf); err != nil {
+		l.err = err
+		return
+	}
+	l.size += int64(len(l.buf))
+}
+
+// record marshals the key and value of a record
+func (l *Log) record(op byte, key, value interface{}, usage int) (record, error) {
+	rec := record{op: op, usage: usage}
+	var err error
+	if rec.key, err = l.opts.KeyCodec.Marshal(key); err != nil {
+		return record{}, fmt.Errorf("key %v: %w", key, err)
+	}
+	if op == opPut {
+		if rec.value, err = l.opts.ValueCodec.Marshal(value); err != nil {
+			return record{}, fmt.Errorf("value for %v: %w", key, err)
+		}
+	}
+	return rec, nil
+}
+
+// OnInsert implements lfucache.Observer.
+func (l *Log) OnInsert(e lfucache.Event) {
+	if e.Namespace == "" {
+		l.log(opPut, e.Key, e.Value, e.UsageAfter)
+	}
+}
+
+// OnHit implements lfucache.Observer.
+func (l *Log) OnHit(e lfucache.Event) {
+	if e.Namespace == "" {
+		l.log(opUsage, e.Key, nil, e.UsageAfter)
+	}
+}
+
+// OnEvict implements lfucache.Observer.
+func (l *Log) OnEvict(e lfucache.Event) {
+	// A replaced item is superseded by the insert following
+	if e.Namespace == "" && e.Reason != lfucache.EvictReplace {
+		l.log(opDelete, e.Key, nil, 0)
+	}
+}
+
+// OnDelete implements lfucache.Observer.
+func (l *Log) OnDelete(e lfucache.Event) {
+	if e.Namespace == "" {
+		l.log(opDelete, e.Key, nil, 0)
+	}
+}
+
+// OnMiss implements lfucache.Observer.
+func (l *Log) OnMiss(lfucache.Event) {}
+
+// OnResize implements lfucache.Observer. The capacity is not logged; the
+// evictions caused by shrinking are.
+func (l *Log) OnResize(lfucache.ResizeEvent) {}
+
+// syncDir syncs the directory, making a rename in it durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
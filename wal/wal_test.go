@@ -0,0 +1,273 @@
+package wal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/calmh/deprecated_lfucache"
+)
+
+func open(t *testing.T, dir string, opts Options, capacity int) (*lfucache.Cache, *Log) {
+	t.Helper()
+	c, l, err := Open(dir, opts, lfucache.WithCapacity(capacity))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, l
+}
+
+// expectItem checks the value and usage of the item, or that it is missing
+// if value is empty
+func expectItem(t *testing.T, c *lfucache.Cache, key, value string, usage int) {
+	t.Helper()
+	v, ok := c.Peek(key)
+	if value == "" {
+		if ok {
+			t.Errorf("Unexpected item %s", key)
+		}
+		return
+	}
+	if !ok || string(v.([]byte)) != value {
+		t.Errorf("Item %s is %q, %v, expected %q", key, v, ok, value)
+	}
+	if u, _ := c.Usage(key); u != usage {
+		t.Errorf("Item %s has usage %d, expected %d", key, u, usage)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	dir := t.TempDir()
+	c, l := open(t, dir, Options{}, 2)
+
+	c.Insert("test1", []byte("a"))
+	c.Insert("test2", []byte("b"))
+	for i := 0; i < 3; i++ {
+		c.Access("test1")
+	}
+	c.Access("test2")
+	c.Insert("test3", []byte("c")) // evicts test2
+	c.Insert("test3", []byte("d"))
+	c.Access("test3")
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, l = open(t, dir, Options{}, 2)
+	expectItem(t, c, "test1", "a", 3)
+	expectItem(t, c, "test2", "", 0)
+	expectItem(t, c, "test3", "d", 1)
+
+	c.Delete("test1")
+	l.Close()
+	c, l = open(t, dir, Options{}, 2)
+	defer l.Close()
+	expectItem(t, c, "test1", "", 0)
+}
+
+func TestCoalescedUsage(t *testing.T) {
+	c, l := open(t, t.TempDir(), Options{Sync: SyncNone}, 10)
+	defer l.Close()
+
+	c.Insert("test1", []byte("a"))
+	c.Insert("test2", []byte("b"))
+	l.Sync()
+	before := l.size
+	for i := 0; i < 100; i++ {
+		c.Access("test1")
+	}
+	c.Access("test2")
+	c.Delete("test2")
+	l.Sync()
+
+	usage := int64(recordHeaderLen + len("test1"))
+	del := int64(recordHeaderLen + len("test2"))
+	if l.size-before != usage+del {
+		t.Errorf("Logged %d bytes for accesses, expected %d", l.size-before, usage+del)
+	}
+}
+
+func TestUsageOrder(t *testing.T) {
+	dir := t.TempDir()
+	c, l := open(t, dir, Options{}, 10)
+
+	// Accessed in an order unrelated to the insert order, so that the
+	// replayed use counts must keep it for the items to be evicted the same
+	var keys []string
+	for i := 0; i < 10; i++ {
+		key := "test" + strconv.Itoa(i)
+		c.Insert(key, []byte(key))
+		keys = append(keys, "test"+strconv.Itoa(i*7%10))
+	}
+	for _, key := range keys {
+		c.Access(key)
+	}
+	c.Access(keys[0])
+	keys = append(keys[1:], keys[0])
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, l = open(t, dir, Options{}, 10)
+	defer l.Close()
+	for i, ku := range c.Coldest(10) {
+		if ku.Key != keys[i] {
+			t.Errorf("Item %d is %v, expected %s", i, ku.Key, keys[i])
+		}
+	}
+	c.Insert("test10", []byte("10"))
+	expectItem(t, c, keys[0], "", 0)
+}
+
+func TestSnapshotNamespace(t *testing.T) {
+	dir := t.TempDir()
+	c, l := open(t, dir, Options{}, 10)
+
+	ns := c.Namespace("ns")
+	c.Insert("test1", []byte("a"))
+	ns.Insert("test1", []byte("b"))
+	ns.Access("test1")
+	ns.Access("test1")
+	if err := l.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	c, l = open(t, dir, Options{}, 10)
+	defer l.Close()
+	expectItem(t, c, "test1", "a", 0)
+	if c.Len() != 1 {
+		t.Errorf("Unexpected length %d", c.Len())
+	}
+}
+
+// countingObserver counts the inserts it observes
+type countingObserver struct {
+	lfucache.NopObserver
+	inserts int
+}
+
+func (o *countingObserver) OnInsert(lfucache.Event) { o.inserts++ }
+
+func TestOpenObserver(t *testing.T) {
+	dir := t.TempDir()
+	c, l := open(t, dir, Options{}, 10)
+	c.Insert("test1", []byte("a"))
+	l.Close()
+
+	o := &countingObserver{}
+	c, l, err := Open(dir, Options{}, lfucache.WithCapacity(10), lfucache.WithObserver(o))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Insert("test2", []byte("b"))
+	l.Close()
+	if o.inserts != 1 {
+		t.Errorf("Observed %d inserts, expected 1", o.inserts)
+	}
+
+	c, l = open(t, dir, Options{}, 10)
+	defer l.Close()
+	expectItem(t, c, "test1", "a", 0)
+	expectItem(t, c, "test2", "b", 0)
+}
+
+func TestTornTail(t *testing.T) {
+	dir := t.TempDir()
+	c, l := open(t, dir, Options{Sync: SyncAlways}, 10)
+	for i := 0; i < 5; i++ {
+		c.Insert("test"+strconv.Itoa(i), []byte{byte('a' + i)})
+	}
+	// Crash while writing the record of test4
+	l.f.Close()
+	path := l.segmentPath(l.seq)
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, fi.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	c, l = open(t, dir, Options{}, 10)
+	for i := 0; i < 4; i++ {
+		expectItem(t, c, "test"+strconv.Itoa(i), string(rune('a'+i)), 0)
+	}
+	expectItem(t, c, "test4", "", 0)
+
+	// Appending continues after the last valid record
+	c.Insert("test5", []byte("f"))
+	l.Close()
+	c, l = open(t, dir, Options{}, 10)
+	defer l.Close()
+	expectItem(t, c, "test3", "d", 0)
+	expectItem(t, c, "test5", "f", 0)
+}
+
+func TestRotateAndCompact(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{SegmentSize: 100, MaxPending: 1}
+	c, l := open(t, dir, opts, 10)
+	for i := 0; i < 20; i++ {
+		c.Insert("test"+strconv.Itoa(i%12), []byte(strconv.Itoa(i)))
+		c.Access("test0")
+	}
+	if seqs, _ := l.segments(); len(seqs) < 3 {
+		t.Errorf("Log not rotated, segments %v", seqs)
+	}
+
+	if err := l.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if seqs, _ := l.segments(); len(seqs) != 1 || seqs[0] != l.seq {
+		t.Errorf("Unexpected segments %v after Compact", seqs)
+	}
+	c.Access("test7")
+	c.Insert("test20", []byte("20"))
+	hist := c.Histogram()
+	l.Close()
+
+	c, l = open(t, dir, opts, 10)
+	defer l.Close()
+	expectItem(t, c, "test0", "12", 8)
+	expectItem(t, c, "test7", "19", 1)
+	expectItem(t, c, "test20", "20", 0)
+	if got := c.Histogram(); len(got) != len(hist) {
+		t.Errorf("Histogram %v after reopen, expected %v", got, hist)
+	}
+}
+
+func TestCorruptLog(t *testing.T) {
+	dir := t.TempDir()
+	c, l := open(t, dir, Options{SegmentSize: 1}, 10)
+	c.Insert("test1", []byte("a"))
+	c.Insert("test2", []byte("b"))
+	l.Close()
+
+	seqs, _ := l.segments()
+	if err := os.WriteFile(l.segmentPath(seqs[0]), []byte("garbage"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Open(dir, Options{}, lfucache.WithCapacity(10)); !errors.Is(err, ErrCorruptLog) {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	os.WriteFile(filepath.Join(dir, snapshotName), []byte("garbage"), 0o644)
+	if _, _, err := Open(dir, Options{}, lfucache.WithCapacity(10)); !errors.Is(err, ErrCorruptLog) {
+		t.Errorf("Unexpected error %v for snapshot", err)
+	}
+}
+
+func TestUnsupportedType(t *testing.T) {
+	c, l := open(t, t.TempDir(), Options{}, 10)
+	c.Insert(42, []byte("a"))
+	c.Insert("test1", []byte("b"))
+	if err := l.Err(); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if err := l.Close(); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("Unexpected error %v from Close", err)
+	}
+}
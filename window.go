@@ -0,0 +1,136 @@
+package lfucache
+
+import (
+	"time"
+)
+
+// The windowed statistics are kept in two rings of counters, one with a
+// bucket per second and one with a bucket per minute over the last hour.
+// Each bucket remembers which interval it holds counts for, so stale
+// buckets are simply reset when reused and ignored when summing.
+
+const windowBuckets = 60
+
+// WindowStatistics contains operation counters for a recent period of time.
+type WindowStatistics struct {
+	Period    time.Duration // The period covered by the counters
+	Inserts   int           // Number of Insert()s
+	Hits      int           // Number of hits (Access() to item)
+	Misses    int           // Number of misses (Access() to non-existant key)
+	Evictions int           // Number of evictions
+}
+
+// HitRatio returns the ratio of hits to all accesses during the period, or
+// zero if there were no accesses.
+func (s WindowStatistics) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+type windows struct {
+	now     func() time.Time
+	seconds ring
+	minutes ring
+}
+
+type ring struct {
+	interval time.Duration
+	counters [windowBuckets]windowCounters
+}
+
+type windowCounters struct {
+	stamp  int64 // interval number the counters are for
+	counts [numWindowOps]int
+}
+
+const (
+	windowInserts = iota
+	windowHits
+	windowMisses
+	windowEvictions
+	numWindowOps
+)
+
+// WithWindowedStatistics enables keeping per second and per minute counters
+// for the last hour, queried by WindowedStatistics.
+func WithWindowedStatistics() Option {
+	return func(c *Cache) {
+		c.windows = &windows{
+			now:     time.Now,
+			seconds: ring{interval: time.Second},
+			minutes: ring{interval: time.Minute},
+		}
+	}
+}
+
+// WindowedStatistics returns the operation counters for the last d, rounded
+// up to whole seconds when d is at most a minute and to whole minutes
+// otherwise, and capped to an hour. The counters for the current second or
+// minute are included. Returns zero counters unless the cache was created
+// with WithWindowedStatistics.
+func (c *Cache) WindowedStatistics(d time.Duration) WindowStatistics {
+	if c.windows == nil {
+		return WindowStatistics{}
+	}
+
+	now := c.windows.now()
+	if d <= time.Minute {
+		return c.windows.seconds.sum(now, d)
+	}
+	return c.windows.minutes.sum(now, d)
+}
+
+// ResetStatistics zeroes the operation counters of the cache and all its
+// namespaces, including any windowed statistics.
+func (c *Cache) ResetStatistics() {
+	c.stats = Statistics{}
+	for _, ns := range c.namespaces {
+		ns.stats = Statistics{}
+	}
+	if c.windows != nil {
+		c.windows.seconds.counters = [windowBuckets]windowCounters{}
+		c.windows.minutes.counters = [windowBuckets]windowCounters{}
+	}
+}
+
+// add increments the current counter for op in each ring
+func (w *windows) add(op int) {
+	now := w.now()
+	w.seconds.current(now).counts[op]++
+	w.minutes.current(now).counts[op]++
+}
+
+// current returns the counters for the interval containing now
+func (r *ring) current(now time.Time) *windowCounters {
+	stamp := now.UnixNano() / int64(r.interval)
+	wc := &r.counters[stamp%windowBuckets]
+	if wc.stamp != stamp {
+		*wc = windowCounters{stamp: stamp}
+	}
+	return wc
+}
+
+// sum returns the sum of the counters for the intervals covering the last d
+func (r *ring) sum(now time.Time, d time.Duration) WindowStatistics {
+	n := int64((d + r.interval - 1) / r.interval)
+	if n < 1 {
+		n = 1
+	}
+	if n > windowBuckets {
+		n = windowBuckets
+	}
+
+	s := WindowStatistics{Period: time.Duration(n) * r.interval}
+	stamp := now.UnixNano() / int64(r.interval)
+	for _, wc := range r.counters {
+		if wc.stamp > stamp-n && wc.stamp <= stamp {
+			s.Inserts += wc.counts[windowInserts]
+			s.Hits += wc.counts[windowHits]
+			s.Misses += wc.counts[windowMisses]
+			s.Evictions += wc.counts[windowEvictions]
+		}
+	}
+	return s
+}